@@ -0,0 +1,148 @@
+package rudp
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// Every packet starts with its kind. SYN, data and FIN packets follow it
+// with a sequence number; data packets then carry the payload. SYN and FIN
+// take sequence numbers of their own, so they're delivered reliably and in
+// order like data. The client's stream starts with a SYN, numbered 0,
+// which is what a server waiting for a peer looks for.
+//
+//	syn:   kind | seq uint32
+//	data:  kind | seq uint32 | payload
+//	fin:   kind | seq uint32
+//	ack:   kind | next uint32 | window uint16 | count uint8 | count * (start uint32 | end uint32)
+//	probe: kind
+//
+// An ack's next is the lowest sequence number not yet received; every
+// segment before it has arrived. window is how many segments from next on
+// the receiver has room for. The blocks that follow are selective acks:
+// ranges [start, end) received out of order beyond next. A sender that has
+// been told the window is 0 probes for an ack until it opens.
+const (
+	kindData byte = iota + 1
+	kindAck
+	kindFin
+	kindSyn
+	kindProbe
+)
+
+const (
+	dataHeaderSize = 5
+	ackHeaderSize  = 8
+	blockSize      = 8
+	maxBlocks      = 8
+)
+
+var errMalformed = errors.New("rudp: malformed packet")
+
+// block is a range [start, end) of sequence numbers.
+type block struct {
+	start, end uint32
+}
+
+func (b block) contains(seq uint32) bool {
+	return !seqLess(seq, b.start) && seqLess(seq, b.end)
+}
+
+// seqLess compares sequence numbers so they may wrap around.
+func seqLess(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+func marshalSegment(kind byte, seq uint32, payload []byte) []byte {
+	b := make([]byte, dataHeaderSize, dataHeaderSize+len(payload))
+	b[0] = kind
+	binary.BigEndian.PutUint32(b[1:], seq)
+
+	return append(b, payload...)
+}
+
+func unmarshalSegment(b []byte) (*segment, error) {
+	if len(b) < dataHeaderSize {
+		return nil, errMalformed
+	}
+
+	return &segment{
+		seq:  binary.BigEndian.Uint32(b[1:]),
+		syn:  b[0] == kindSyn,
+		fin:  b[0] == kindFin,
+		data: append([]byte(nil), b[dataHeaderSize:]...),
+	}, nil
+}
+
+// isSyn reports whether b opens a stream.
+func isSyn(b []byte) bool {
+	return len(b) == dataHeaderSize && b[0] == kindSyn && binary.BigEndian.Uint32(b[1:]) == 0
+}
+
+func marshalAck(next uint32, window uint16, blocks []block) []byte {
+	b := make([]byte, ackHeaderSize+len(blocks)*blockSize)
+	b[0] = kindAck
+	binary.BigEndian.PutUint32(b[1:], next)
+	binary.BigEndian.PutUint16(b[5:], window)
+	b[7] = byte(len(blocks))
+
+	for i, blk := range blocks {
+		off := ackHeaderSize + i*blockSize
+		binary.BigEndian.PutUint32(b[off:], blk.start)
+		binary.BigEndian.PutUint32(b[off+4:], blk.end)
+	}
+
+	return b
+}
+
+func unmarshalAck(b []byte) (uint32, uint16, []block, error) {
+	if len(b) < ackHeaderSize {
+		return 0, 0, nil, errMalformed
+	}
+
+	count := int(b[7])
+	if len(b) != ackHeaderSize+count*blockSize {
+		return 0, 0, nil, errMalformed
+	}
+
+	blocks := make([]block, count)
+	for i := range blocks {
+		off := ackHeaderSize + i*blockSize
+		blocks[i] = block{
+			start: binary.BigEndian.Uint32(b[off:]),
+			end:   binary.BigEndian.Uint32(b[off+4:]),
+		}
+	}
+
+	return binary.BigEndian.Uint32(b[1:]), binary.BigEndian.Uint16(b[5:]), blocks, nil
+}
+
+// sackBlocks merges the out-of-order sequence numbers received beyond
+// next into at most maxBlocks ranges, nearest first.
+func sackBlocks(next uint32, received map[uint32]*segment) []block {
+	if len(received) == 0 {
+		return nil
+	}
+
+	offsets := make([]uint32, 0, len(received))
+	for seq := range received {
+		offsets = append(offsets, seq-next)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+	var blocks []block
+	for _, off := range offsets {
+		seq := next + off
+		if n := len(blocks); n > 0 && blocks[n-1].end == seq {
+			blocks[n-1].end++
+			continue
+		}
+		if len(blocks) == maxBlocks {
+			break
+		}
+		blocks = append(blocks, block{start: seq, end: seq + 1})
+	}
+
+	return blocks
+}
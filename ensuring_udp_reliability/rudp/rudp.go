@@ -0,0 +1,708 @@
+// Package rudp is a reliable, ordered byte stream over UDP. Where the TFTP
+// server waits for each block's acknowledgment before sending the next,
+// rudp keeps a window of segments in flight, acknowledges out-of-order
+// arrivals selectively so only the gaps are resent, and sizes its
+// retransmission timeout from measured round-trip times. The receiver
+// drops duplicates, delivers bytes in order and advertises how much more
+// it can buffer, so a slow reader holds up the sender instead of dropping
+// what it sends. The whole thing looks like a net.Conn.
+package rudp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"networks/unreliable_udp_communication/pmtu"
+)
+
+const (
+	defaultMaxSegment   = 1200 // Fits a typical path MTU with room for headers
+	defaultWindow       = 64
+	defaultInitialRTO   = time.Second
+	defaultMinRTO       = 200 * time.Millisecond
+	defaultMaxRTO       = 10 * time.Second
+	defaultMaxRetries   = 10
+	defaultCloseTimeout = 10 * time.Second
+
+	maxWindow    = 1<<16 - 1 // An ack's window is 16 bits
+	dupThreshold = 3         // Selective acks past a gap before it's resent early
+	granularity  = time.Millisecond
+	maxPacket    = 1 << 16
+)
+
+// ErrUnreachable is returned once a segment goes unacknowledged after
+// MaxRetries retransmissions.
+var ErrUnreachable = errors.New("rudp: peer stopped acknowledging")
+
+// Config tunes the transport. Zero values use the defaults. Both ends
+// should use the same Window.
+type Config struct {
+	// MaxSegment is the largest payload per packet. If the PacketConn is
+	// a connected *net.UDPConn, it defaults to what fills the path, from
+	// pmtu.Recommended less the 5-byte segment header; otherwise to 1200
+	// bytes.
+	MaxSegment int
+
+	// Window is the most unacknowledged segments in flight, and the most
+	// segments buffered for Read. Defaults to 64, at most 65535.
+	Window int
+
+	// The retransmission timeout starts at InitialRTO, follows the
+	// measured round-trip time after that (RFC 6298), and stays between
+	// MinRTO and MaxRTO. Defaults are 1s, 200ms and 10s.
+	InitialRTO time.Duration
+	MinRTO     time.Duration
+	MaxRTO     time.Duration
+
+	MaxRetries int // Retransmissions of one segment before giving up; defaults to 10
+
+	// CloseTimeout bounds how long Close waits for everything written to
+	// be acknowledged, and how long the Conn lingers afterward for the
+	// peer to close its side. Defaults to 10s.
+	CloseTimeout time.Duration
+}
+
+// Stats counts the transport's work.
+type Stats struct {
+	Sent            int // Segments sent for the first time
+	Retransmits     int // Segments resent after a timeout or gap
+	FastRetransmits int // Of those, the ones resent because of a gap
+	Received        int // Segments delivered in order
+	Duplicates      int // Segments received more than once
+	OutOfOrder      int // Segments held back until a gap was filled
+	SRTT            time.Duration
+	RTO             time.Duration
+}
+
+type segment struct {
+	seq           uint32
+	syn           bool
+	fin           bool
+	data          []byte
+	sent          time.Time
+	retries       int
+	retransmitted bool // Its acknowledgment can't be timed (Karn's algorithm)
+	sacked        bool
+	dups          int // Selective acks received for later segments
+}
+
+// Conn is a reliable stream to a single peer over a PacketConn.
+type Conn struct {
+	pc        net.PacketConn
+	connected *net.UDPConn // pc, if it's connected, when WriteTo won't do
+	cfg       Config
+
+	mu      sync.Mutex
+	changed chan struct{} // Closed and replaced whenever the state changes
+	kick    chan struct{} // Wakes the retransmission timer
+	peer    net.Addr
+
+	// Sending
+	nextSeq  uint32
+	inflight []*segment // Unacknowledged, in sequence order
+	sndUna   uint32     // The peer's latest next
+	sndWnd   int        // and window; we may send up to sndUna+sndWnd
+	probeAt  time.Time  // When to next ask about a zero window
+	srtt     time.Duration
+	rttvar   time.Duration
+	rto      time.Duration
+
+	// Receiving
+	rcvNext    uint32
+	outOfOrder map[uint32]*segment
+	readQ      [][]byte // One entry per segment, so len is what the window counts
+	advertised int      // The window in our latest ack
+	finRcvd    bool
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+	stats         Stats
+	err           error
+	closed        bool
+	done          chan struct{}
+	shutdownOnce  sync.Once
+}
+
+// New starts a stream with peer over pc. If peer is nil, as for a server,
+// the Conn waits for a client to open a stream and adopts its address;
+// Write waits until then, and other packets are ignored. After that,
+// packets from any other address are ignored. The Conn owns pc and closes
+// it when it's done.
+func New(pc net.PacketConn, peer net.Addr, cfg Config) *Conn {
+	uc, ok := pc.(*net.UDPConn)
+	if !ok || uc.RemoteAddr() == nil {
+		uc = nil
+	}
+
+	if cfg.MaxSegment <= 0 {
+		cfg.MaxSegment = defaultMaxSegment
+		if uc != nil {
+			cfg.MaxSegment = pmtu.Recommended(uc) - dataHeaderSize
+		}
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = defaultWindow
+	}
+	cfg.Window = min(cfg.Window, maxWindow)
+	if cfg.InitialRTO <= 0 {
+		cfg.InitialRTO = defaultInitialRTO
+	}
+	if cfg.MinRTO <= 0 {
+		cfg.MinRTO = defaultMinRTO
+	}
+	if cfg.MaxRTO <= 0 {
+		cfg.MaxRTO = defaultMaxRTO
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = defaultMaxRetries
+	}
+	if cfg.CloseTimeout <= 0 {
+		cfg.CloseTimeout = defaultCloseTimeout
+	}
+
+	c := &Conn{
+		pc:         pc,
+		connected:  uc,
+		cfg:        cfg,
+		peer:       peer,
+		changed:    make(chan struct{}),
+		kick:       make(chan struct{}, 1),
+		sndWnd:     cfg.Window,
+		rto:        cfg.InitialRTO,
+		outOfOrder: make(map[uint32]*segment),
+		advertised: cfg.Window,
+		done:       make(chan struct{}),
+	}
+
+	if peer != nil {
+		c.queue(&segment{syn: true}) // Resent like data until the server acks it
+	}
+
+	go c.readLoop()
+	go c.timerLoop()
+
+	return c
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for {
+		switch {
+		case c.closed:
+			return 0, net.ErrClosed
+		case len(c.readQ) > 0:
+			n := copy(p, c.readQ[0])
+			if c.readQ[0] = c.readQ[0][n:]; len(c.readQ[0]) == 0 {
+				c.readQ = c.readQ[1:]
+				if c.advertised == 0 && c.err == nil {
+					c.sendAck() // Tell the stalled sender there's room again
+				}
+			}
+			return n, nil
+		case c.finRcvd:
+			return 0, io.EOF
+		case c.err != nil:
+			return 0, c.err
+		}
+
+		if err := c.wait(c.readDeadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write sends p in segments, waiting while the window is full. It returns
+// once the last segment is sent, not acknowledged; Close waits for that.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var written int
+
+	for written < len(p) {
+		switch {
+		case c.closed:
+			return written, net.ErrClosed
+		case c.err != nil:
+			return written, c.err
+		case c.peer == nil || len(c.inflight) >= c.cfg.Window || !seqLess(c.nextSeq, c.sndUna+uint32(c.sndWnd)):
+			if err := c.wait(c.writeDeadline); err != nil {
+				return written, err
+			}
+			continue
+		}
+
+		n := min(len(p)-written, c.cfg.MaxSegment)
+		c.queue(&segment{data: append([]byte(nil), p[written:written+n]...)})
+		written += n
+	}
+
+	return written, nil
+}
+
+// queue numbers seg and sends it. c.mu must be held.
+func (c *Conn) queue(seg *segment) {
+	seg.seq = c.nextSeq
+	seg.sent = time.Now()
+	c.nextSeq++
+	c.inflight = append(c.inflight, seg)
+	c.stats.Sent++
+	c.send(seg)
+	c.kickTimer()
+}
+
+// kickTimer makes the timer loop look at the state again.
+func (c *Conn) kickTimer() {
+	select {
+	case c.kick <- struct{}{}:
+	default:
+	}
+}
+
+// send writes seg to the peer. Lost writes are left for the timer to
+// retry. c.mu must be held.
+func (c *Conn) send(seg *segment) {
+	kind := kindData
+	switch {
+	case seg.syn:
+		kind = kindSyn
+	case seg.fin:
+		kind = kindFin
+	}
+	c.write(marshalSegment(kind, seg.seq, seg.data))
+}
+
+// write sends a packet to the peer. c.mu must be held.
+func (c *Conn) write(b []byte) {
+	if c.connected != nil {
+		_, _ = c.connected.Write(b)
+		return
+	}
+	_, _ = c.pc.WriteTo(b, c.peer)
+}
+
+// wait releases c.mu until the state changes, the deadline passes or the
+// Conn shuts down. c.mu must be held.
+func (c *Conn) wait(deadline time.Time) error {
+	changed := c.changed
+	c.mu.Unlock()
+	defer c.mu.Lock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-changed:
+	case <-c.done:
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+
+	return nil
+}
+
+// notify wakes everyone waiting on the state. c.mu must be held.
+func (c *Conn) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *Conn) readLoop() {
+	buf := make([]byte, maxPacket)
+
+	for {
+		n, addr, err := c.pc.ReadFrom(buf)
+		if err != nil {
+			c.mu.Lock()
+			c.fail(err)
+			c.mu.Unlock()
+			return
+		}
+
+		c.mu.Lock()
+		if c.peer == nil && isSyn(buf[:n]) {
+			c.peer = addr // Only a client opening a stream becomes the peer
+			c.notify()
+		}
+		if c.peer != nil && addr.String() == c.peer.String() && n > 0 {
+			c.handle(buf[:n])
+		}
+		c.mu.Unlock()
+	}
+}
+
+// handle processes one packet from the peer. c.mu must be held.
+func (c *Conn) handle(b []byte) {
+	switch b[0] {
+	case kindSyn, kindData, kindFin:
+		seg, err := unmarshalSegment(b)
+		if err != nil {
+			return
+		}
+		c.receive(seg)
+	case kindAck:
+		next, window, blocks, err := unmarshalAck(b)
+		if err != nil {
+			return
+		}
+		c.acknowledge(next, window, blocks)
+	case kindProbe:
+		c.sendAck()
+	}
+}
+
+// receive delivers seg in order, holding it back if it's early, and acks
+// everything received so far. Segments beyond the window are dropped
+// unacked, except a SYN or FIN that's next, which takes no room. c.mu must
+// be held.
+func (c *Conn) receive(seg *segment) {
+	switch {
+	case seqLess(seg.seq, c.rcvNext):
+		c.stats.Duplicates++ // Our ack was lost; send it again
+	case seg.seq-c.rcvNext >= uint32(c.window()) && (seg.seq != c.rcvNext || len(seg.data) > 0):
+		return // Beyond the window; the sender will try again
+	case seg.seq == c.rcvNext:
+		c.deliver(seg)
+		for {
+			next, ok := c.outOfOrder[c.rcvNext]
+			if !ok {
+				break
+			}
+			delete(c.outOfOrder, c.rcvNext)
+			c.deliver(next)
+		}
+		c.notify()
+	default:
+		if _, ok := c.outOfOrder[seg.seq]; ok {
+			c.stats.Duplicates++
+		} else {
+			c.outOfOrder[seg.seq] = seg
+			c.stats.OutOfOrder++
+		}
+	}
+
+	c.sendAck()
+}
+
+// window is how many segments from rcvNext on there's room for. Segments
+// held out of order are within it already, and delivering them moves
+// rcvNext as far as it fills readQ, so readQ never outgrows Window. c.mu
+// must be held.
+func (c *Conn) window() int {
+	return max(c.cfg.Window-len(c.readQ), 0)
+}
+
+// sendAck acks everything received so far and advertises the window.
+// c.mu must be held.
+func (c *Conn) sendAck() {
+	c.advertised = c.window()
+	c.write(marshalAck(c.rcvNext, uint16(c.advertised), sackBlocks(c.rcvNext, c.outOfOrder)))
+}
+
+func (c *Conn) deliver(seg *segment) {
+	c.rcvNext++
+	c.stats.Received++
+
+	if seg.fin {
+		c.finRcvd = true
+	} else if !c.closed && len(seg.data) > 0 {
+		c.readQ = append(c.readQ, seg.data)
+	}
+}
+
+// acknowledge retires the segments the peer has received, notes its
+// window, and resends those it has skipped over dupThreshold times. c.mu
+// must be held.
+func (c *Conn) acknowledge(next uint32, window uint16, blocks []block) {
+	now := time.Now()
+
+	if !seqLess(next, c.sndUna) { // Not an ack that's been overtaken
+		c.sndUna, c.sndWnd = next, int(window)
+		if window == 0 {
+			c.kickTimer() // Start probing
+		}
+	}
+
+	for len(c.inflight) > 0 && seqLess(c.inflight[0].seq, next) {
+		if !c.inflight[0].sacked {
+			c.acked(c.inflight[0], now)
+		}
+		c.inflight = c.inflight[1:]
+	}
+
+	var highest *segment
+	for _, seg := range c.inflight {
+		for _, blk := range blocks {
+			if blk.contains(seg.seq) {
+				if !seg.sacked {
+					seg.sacked = true
+					c.acked(seg, now)
+				}
+				highest = seg
+				break
+			}
+		}
+	}
+
+	if highest != nil {
+		for _, seg := range c.inflight {
+			if seg == highest {
+				break
+			}
+			if seg.sacked {
+				continue
+			}
+			// Resend a gap at most once per round trip, or every ack past
+			// it would resend it again.
+			if seg.dups++; seg.dups >= dupThreshold && now.Sub(seg.sent) > c.srtt {
+				c.stats.FastRetransmits++
+				c.retransmit(seg, now)
+			}
+		}
+	}
+
+	c.notify()
+}
+
+// acked takes an RTT sample from seg, unless it was resent and the ack
+// might be for either copy.
+func (c *Conn) acked(seg *segment, now time.Time) {
+	if seg.retransmitted {
+		return
+	}
+
+	r := now.Sub(seg.sent)
+	if c.srtt == 0 {
+		c.srtt, c.rttvar = r, r/2
+	} else {
+		c.rttvar = (3*c.rttvar + abs(c.srtt-r)) / 4
+		c.srtt = (7*c.srtt + r) / 8
+	}
+	c.rto = min(max(c.srtt+max(granularity, 4*c.rttvar), c.cfg.MinRTO), c.cfg.MaxRTO)
+}
+
+func (c *Conn) retransmit(seg *segment, now time.Time) {
+	seg.sent = now
+	seg.retransmitted = true
+	seg.dups = 0
+	c.stats.Retransmits++
+	c.send(seg)
+}
+
+// timerLoop resends segments that go unacknowledged for an RTO.
+func (c *Conn) timerLoop() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		c.mu.Lock()
+		next := c.expire(time.Now())
+		c.mu.Unlock()
+
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+		}
+
+		select {
+		case <-c.done:
+			return
+		case <-c.kick:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// expire resends timed-out segments, backing off the RTO, and returns
+// when the next one times out. c.mu must be held.
+func (c *Conn) expire(now time.Time) time.Time {
+	var expired []*segment
+	for _, seg := range c.inflight {
+		if !seg.sacked && !now.Before(seg.sent.Add(c.rto)) {
+			if seg.retries >= c.cfg.MaxRetries {
+				c.fail(ErrUnreachable)
+				return time.Time{}
+			}
+			expired = append(expired, seg)
+		}
+	}
+
+	if len(expired) > 0 {
+		c.rto = min(2*c.rto, c.cfg.MaxRTO)
+		for _, seg := range expired {
+			seg.retries++ // Only timeouts count toward MaxRetries
+			c.retransmit(seg, now)
+		}
+	}
+
+	var next time.Time
+	for _, seg := range c.inflight {
+		if t := seg.sent.Add(c.rto); !seg.sacked && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+
+	// With nothing in flight, no ack will bring news of the window
+	// opening. The peer sends one when it does, but that could be lost, so
+	// keep asking.
+	if c.sndWnd == 0 && len(c.inflight) == 0 && c.peer != nil {
+		if !now.Before(c.probeAt) {
+			c.write([]byte{kindProbe})
+			c.probeAt = now.Add(c.rto)
+		}
+		if next.IsZero() || c.probeAt.Before(next) {
+			next = c.probeAt
+		}
+	}
+
+	return next
+}
+
+// fail ends the stream with err, unless it's already over. c.mu must be
+// held.
+func (c *Conn) fail(err error) {
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+
+	if c.err == nil {
+		c.err = err
+	}
+	c.shutdown()
+}
+
+// shutdown stops the loops and closes pc. c.mu must be held.
+func (c *Conn) shutdown() {
+	c.shutdownOnce.Do(func() {
+		close(c.done)
+		_ = c.pc.Close()
+		c.notify()
+	})
+}
+
+// Close sends a FIN after everything written and waits up to CloseTimeout
+// for all of it to be acknowledged, returning ErrUnreachable if it isn't.
+// Like TCP, the Conn then lingers in the background, acking the rest of
+// the peer's stream until its FIN arrives, before closing the PacketConn.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return net.ErrClosed
+	}
+	c.closed = true
+	c.readQ = nil
+	c.notify() // Blocked reads and writes return
+
+	deadline := time.Now().Add(c.cfg.CloseTimeout)
+	if c.err == nil && c.peer != nil {
+		c.queue(&segment{fin: true})
+		for c.err == nil && len(c.inflight) > 0 {
+			if c.wait(deadline) != nil {
+				break
+			}
+		}
+	}
+
+	var err error
+	if len(c.inflight) > 0 {
+		err = ErrUnreachable
+	}
+	go c.linger(deadline)
+
+	return err
+}
+
+// linger waits for the peer's FIN until deadline, then sticks around for
+// a couple of RTOs in case our ack of it was lost and it's resent.
+func (c *Conn) linger(deadline time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.err == nil && !c.finRcvd && c.peer != nil {
+		if c.wait(deadline) != nil {
+			break
+		}
+	}
+
+	if c.finRcvd {
+		timeWait := time.NewTimer(2 * c.rto)
+		c.mu.Unlock()
+		select {
+		case <-timeWait.C:
+		case <-c.done:
+		}
+		timeWait.Stop()
+		c.mu.Lock()
+	}
+
+	c.shutdown()
+}
+
+// Stats returns the transport's counters and current RTT estimate.
+func (c *Conn) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.stats
+	s.SRTT, s.RTO = c.srtt, c.rto
+
+	return s
+}
+
+func (c *Conn) LocalAddr() net.Addr { return c.pc.LocalAddr() }
+
+// RemoteAddr returns the peer's address, or nil if it isn't known yet.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.peer
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	_ = c.SetReadDeadline(t)
+
+	return c.SetWriteDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.readDeadline = t
+	c.notify()
+
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeDeadline = t
+	c.notify()
+
+	return nil
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+
+	return d
+}
@@ -0,0 +1,326 @@
+package rudp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"networks/unreliable_udp_communication/netem"
+	"networks/unreliable_udp_communication/pmtu"
+)
+
+var testConfig = Config{
+	InitialRTO:   50 * time.Millisecond,
+	MinRTO:       20 * time.Millisecond,
+	CloseTimeout: 5 * time.Second,
+}
+
+// pair connects a client to a server, each over a PacketConn wrapped by
+// wrap.
+func pair(t *testing.T, wrap func(net.PacketConn) net.PacketConn) (*Conn, *Conn) {
+	t.Helper()
+
+	a, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := New(wrap(a), b.LocalAddr(), testConfig)
+	server := New(wrap(b), nil, testConfig)
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+
+	return client, server
+}
+
+func TestTransfer(t *testing.T) {
+	var seed uint64
+	client, server := pair(t, func(pc net.PacketConn) net.PacketConn {
+		seed++
+		return netem.NewPacketConn(pc, netem.Config{
+			Seed:         seed,
+			Loss:         0.1,
+			Duplicate:    0.05,
+			Reorder:      0.05,
+			ReorderDelay: 5 * time.Millisecond,
+		})
+	})
+
+	payload := make([]byte, 256<<10)
+	_, _ = rand.Read(payload)
+
+	received := make(chan []byte)
+	go func() {
+		b, err := io.ReadAll(server)
+		if err != nil {
+			t.Error(err)
+		}
+		received <- b
+	}()
+
+	if _, err := client.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if b := <-received; !bytes.Equal(b, payload) {
+		t.Fatalf("received %d bytes that don't match the %d sent", len(b), len(payload))
+	}
+	if err := server.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cs, ss := client.Stats(), server.Stats()
+	t.Logf("client %+v", cs)
+	t.Logf("server %+v", ss)
+
+	if cs.Retransmits == 0 {
+		t.Error("expected retransmissions over a lossy link")
+	}
+	if ss.Duplicates == 0 || ss.OutOfOrder == 0 {
+		t.Error("expected duplicate and out-of-order segments over a lossy link")
+	}
+	if cs.SRTT <= 0 {
+		t.Error("expected an RTT estimate")
+	}
+}
+
+func TestEcho(t *testing.T) {
+	client, server := pair(t, func(pc net.PacketConn) net.PacketConn { return pc })
+
+	go func() { _, _ = io.Copy(server, server) }()
+
+	for _, msg := range []string{"ping", "pong", "done"} {
+		_, err := client.Write([]byte(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf := make([]byte, len(msg))
+		if _, err = io.ReadFull(client, buf); err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != msg {
+			t.Fatalf("expected %q; actual %q", msg, buf)
+		}
+	}
+}
+
+func TestUnreachable(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	silent, err := net.ListenPacket("udp", "127.0.0.1:") // Never answers
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = silent.Close() }()
+
+	cfg := testConfig
+	cfg.MaxRetries = 3
+	c := New(pc, silent.LocalAddr(), cfg)
+	defer func() { _ = c.Close() }()
+
+	if _, err = c.Write([]byte("hello?")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Read(make([]byte, 1)); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("expected %v; actual %v", ErrUnreachable, err)
+	}
+	if _, err = c.Write([]byte("hello?")); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("expected %v; actual %v", ErrUnreachable, err)
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	client, _ := pair(t, func(pc net.PacketConn) net.PacketConn { return pc })
+
+	_ = client.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := client.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected %v; actual %v", os.ErrDeadlineExceeded, err)
+	}
+}
+
+// TestStranger checks a server waiting for its client isn't taken over by
+// whoever sends it something first.
+func TestStranger(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := New(pc, nil, testConfig)
+	defer func() { _ = server.Close() }()
+
+	stranger, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = stranger.Close() }()
+
+	for _, b := range [][]byte{{0}, marshalSegment(kindData, 0, []byte("hi")), marshalSegment(kindSyn, 1, nil)} {
+		if _, err = stranger.WriteTo(b, pc.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	if addr := server.RemoteAddr(); addr != nil {
+		t.Fatalf("adopted %s without a handshake", addr)
+	}
+
+	a, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := New(a, pc.LocalAddr(), testConfig)
+	defer func() { _ = client.Close() }()
+
+	if _, err = client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	_ = server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 5)
+	if _, err = io.ReadFull(server, buf); err != nil {
+		t.Fatal(err)
+	}
+	if addr := server.RemoteAddr(); addr.String() != a.LocalAddr().String() {
+		t.Errorf("expected peer %s; actual %s", a.LocalAddr(), addr)
+	}
+}
+
+// TestFlowControl checks a reader that falls behind holds up the writer
+// rather than buffering without bound, and that the writer resumes once
+// it catches up.
+func TestFlowControl(t *testing.T) {
+	cfg := testConfig
+	cfg.Window = 4
+	cfg.MaxSegment = 100
+
+	a, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server := New(a, b.LocalAddr(), cfg), New(b, nil, cfg)
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+
+	payload := make([]byte, 50*cfg.MaxSegment)
+	_, _ = rand.Read(payload)
+
+	_ = client.SetWriteDeadline(time.Now().Add(200 * time.Millisecond))
+	n, err := client.Write(payload)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected %v; actual %v", os.ErrDeadlineExceeded, err)
+	}
+	if limit := 2 * cfg.Window * cfg.MaxSegment; n > limit {
+		t.Errorf("expected at most %d bytes written to a stalled reader; actual %d", limit, n)
+	}
+
+	server.mu.Lock()
+	queued := len(server.readQ)
+	server.mu.Unlock()
+	if queued > cfg.Window {
+		t.Errorf("expected at most %d segments queued; actual %d", cfg.Window, queued)
+	}
+
+	received := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(server)
+		received <- b
+	}()
+
+	_ = client.SetWriteDeadline(time.Time{})
+	if _, err = client.Write(payload[n:]); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if b := <-received; !bytes.Equal(b, payload) {
+		t.Fatalf("received %d bytes that don't match the %d sent", len(b), len(payload))
+	}
+}
+
+// TestConnected checks a client on a connected socket sizes its segments
+// to the path, and can send on it at all.
+func TestConnected(t *testing.T) {
+	b, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := net.DialUDP("udp", nil, b.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := pmtu.Recommended(a) - dataHeaderSize
+
+	client, server := New(a, a.RemoteAddr(), testConfig), New(b, nil, testConfig)
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+
+	if client.cfg.MaxSegment != expected {
+		t.Errorf("expected %d-byte segments; actual %d", expected, client.cfg.MaxSegment)
+	}
+	if server.cfg.MaxSegment != defaultMaxSegment {
+		t.Errorf("expected %d-byte segments unconnected; actual %d", defaultMaxSegment, server.cfg.MaxSegment)
+	}
+
+	go func() { _, _ = io.Copy(server, server) }()
+
+	_ = client.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err = client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err = io.ReadFull(client, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf("expected %q; actual %q", "ping", buf)
+	}
+}
+
+func TestSACKBlocks(t *testing.T) {
+	received := map[uint32]*segment{}
+	for _, seq := range []uint32{12, 13, 15, 16, 17, 20} {
+		received[seq] = &segment{}
+	}
+
+	blocks := sackBlocks(10, received)
+	expected := []block{{12, 14}, {15, 18}, {20, 21}}
+
+	next, window, decoded, err := unmarshalAck(marshalAck(10, 7, blocks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != 10 || window != 7 || len(decoded) != len(expected) {
+		t.Fatalf("expected next 10, window 7 and %v; actual %d, %d and %v", expected, next, window, decoded)
+	}
+	for i := range expected {
+		if decoded[i] != expected[i] {
+			t.Errorf("expected %v; actual %v", expected, decoded)
+		}
+	}
+
+	// Sequence numbers wrap around.
+	if !seqLess(0xfffffffe, 1) || seqLess(1, 0xfffffffe) {
+		t.Error("sequence comparison doesn't wrap")
+	}
+}
@@ -4,6 +4,8 @@ import(
 	"flag"
 	"io/ioutil"
 	"log"
+
+	tftp "networks/ensuring_udp_reliability"
 )
 
 var (
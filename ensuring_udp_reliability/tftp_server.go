@@ -3,10 +3,11 @@ package tftp
 import(
 	"bytes"
 	"errors"
-	"fmt"
 	"log"
 	"net"
 	"time"
+
+	"networks/unreliable_udp_communication/demux"
 )
 
 type Server struct {
@@ -46,35 +47,47 @@ func (s *Server) Serve(conn net.PacketConn) error {
 		s.Timeout = 6 * time.Second
 	}
 
-	var rrq ReadReq 
+	// Sort datagrams into a session per client, so each transfer reads
+	// only its own client's ACKs, and a client resending its request while
+	// the transfer is under way doesn't start another.
+	l := demux.NewListener(conn, demux.Config{
+		IdleTimeout: s.Timeout * time.Duration(s.Retries),
+	})
 
 	for {
-		buf := make([]byte, DatagramSize)
-
-		_, addr, err := conn.ReadFrom(buf)
+		client, err := l.Accept()
 		if err != nil {
 			return err
 		}
 
-		err := rrq.UnmarshalBinary(buf)
-		if err != nil {
-			log.Printf("[%s] bad request: %v", addr, err)
-			continue 
-		}
-
-		go s.handle(addr.String(), rrq)
+		go s.handle(client)
 	}
 }
 
-func (s Server) handle(clientAddr string, rrq ReadReq) {
-	log.Printf("[%s] requested file: %s", clientAddr, rrq.Filename)
+func (s Server) handle(session net.Conn) {
+	defer func() {_ = session.Close()} ()
+
+	clientAddr := session.RemoteAddr().String()
 
-	conn, err := net.Dial("udp", clientAddr)
+	// The session's first datagram is the read request. The transfer runs
+	// on the same session, from the listening port. That port is our
+	// transfer ID (RFC 1350, section 4): clients take it from our first
+	// DATA packet, whichever port it comes from.
+	req := make([]byte, DatagramSize)
+	n, err := session.Read(req)
 	if err != nil {
-		log.Printf("[%s] dial: %v", clientAddr, err)
+		log.Printf("[%s] reading request: %v", clientAddr, err)
 		return
 	}
-	defer func() {_ = conn.Close()} ()
+
+	var rrq ReadReq
+	err = rrq.UnmarshalBinary(req[:n])
+	if err != nil {
+		log.Printf("[%s] bad request: %v", clientAddr, err)
+		return
+	}
+
+	log.Printf("[%s] requested file: %s", clientAddr, rrq.Filename)
 
 	var(
 		ackPkt Ack 
@@ -84,24 +97,24 @@ func (s Server) handle(clientAddr string, rrq ReadReq) {
 	)
 
 	NEXTPACKET:
-		for n := DatagramSize, n == DatagramSize; {
+		for n := DatagramSize; n == DatagramSize; {
 			data, err := dataPkt.MarshalBinary()
 			if err != nil {
-				log.Printf("[%s] preparing data packet: %v, clientAddr, err")
+				log.Printf("[%s] preparing data packet: %v", clientAddr, err)
 				return
 			}
 	RETRY:
 			for i := s.Retries; i > 0; i-- {
-				n, err = conn.Write(data)
+				n, err = session.Write(data)
 				if err != nil {
 					log.Printf("[%s] dial: %v", clientAddr, err)
 					return 
 				}
 				
 				// Wait for the client ACK packet
-				_ = conn.SetReadDeadline(time.Now().Add(s.Timeout))
+				_ = session.SetReadDeadline(time.Now().Add(s.Timeout))
 
-				_, err = conn.read(buf)
+				_, err = session.Read(buf)
 				if err != nil {
 					if nErr, ok := err.(net.Error); ok && nErr.Timeout() {
 						continue RETRY
@@ -117,6 +130,8 @@ func (s Server) handle(clientAddr string, rrq ReadReq) {
 						// Received ACK; send next data packet
 						continue NEXTPACKET
 					}
+				case rrq.UnmarshalBinary(buf) == nil:
+					// The client resent its request; resend the block
 				case errPkt.UnmarshalBinary(buf) == nil:
 					log.Printf("[%s] received error: %v", 
 						clientAddr, errPkt.Message)
@@ -0,0 +1,77 @@
+package tftp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestServeRRQ downloads a payload of a few blocks from Serve, sending
+// the request twice as a client whose first copy seemed lost would.
+func TestServeRRQ(t *testing.T) {
+	payload := make([]byte, 2*BlockSize+100)
+	_, _ = rand.Read(payload)
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = pc.Close() }()
+
+	s := &Server{Payload: payload, Retries: 3, Timeout: time.Second}
+	go func() { _ = s.Serve(pc) }()
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	rrq, err := ReadReq{Filename: "test"}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if _, err = client.WriteTo(rrq, pc.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var received bytes.Buffer
+	buf := make([]byte, DatagramSize)
+	for expected := uint16(1); ; {
+		_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, from, err := client.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if from.String() != pc.LocalAddr().String() {
+			t.Fatalf("expected DATA from %s; actual %s", pc.LocalAddr(), from)
+		}
+
+		var data Data
+		if err = data.UnmarshalBinary(buf[:n]); err != nil {
+			t.Fatal(err)
+		}
+		ack, _ := Ack(data.Block).MarshalBinary()
+		if _, err = client.WriteTo(ack, from); err != nil {
+			t.Fatal(err)
+		}
+		if data.Block != expected {
+			continue // A resent block we already have
+		}
+
+		m, _ := io.Copy(&received, data.Payload)
+		if m < BlockSize {
+			break
+		}
+		expected++
+	}
+
+	if !bytes.Equal(received.Bytes(), payload) {
+		t.Errorf("expected %d bytes; actual %d bytes differing", len(payload), received.Len())
+	}
+}
@@ -49,10 +49,10 @@ func (q ReadReq) MarshalBinary() ([]byte, error) {
 		mode = q.Mode
 	}
 
-	cap := 2 + 2 + len(q.Filename) + 1 + len(q.Mode) + 1
+	cap := 2 + len(q.Filename) + 1 + len(mode) + 1
 
 	b := new(bytes.Buffer)
-	b.Grow(gap)
+	b.Grow(cap)
 
 	err := binary.Write(b, binary.BigEndian, OpRRQ) // Write operation code
 	if err != nil {
@@ -71,7 +71,7 @@ func (q ReadReq) MarshalBinary() ([]byte, error) {
 
 	_, err = b.WriteString(mode)
 	if err != nil {
-		return nill, err
+		return nil, err
 	}
 
 	err = b.WriteByte(0)
@@ -111,7 +111,7 @@ func (q *ReadReq) UnmarshalBinary (p []byte) error {
 		return errors.New("Invalid RRQ")
 	}
 
-	q.Mode = string.TrimRight(q.Mode, "\x00")
+	q.Mode = strings.TrimRight(q.Mode, "\x00")
 	if len(q.Mode) == 0 {
 		return errors.New("Invalid RRQ")
 	}
@@ -134,14 +134,19 @@ func (d *Data) MarshalBinary() ([]byte, error) {
 
 	d.Block++ //block numbers increment from 1
 
-	err := binary.Write(b, binary.BigEndian, d.Block)
+	err := binary.Write(b, binary.BigEndian, OpData) // Write operation code
+	if err != nil {
+		return nil, err
+	}
+
+	err = binary.Write(b, binary.BigEndian, d.Block)
 	if err != nil {
 		return nil, err
 	}
 
 	// Write up to blockSize worth of bytes
 
-	_, err = io.Copy(b, d.Payload, BlockSize)
+	_, err = io.CopyN(b, d.Payload, BlockSize)
 	if err != nil && err != io.EOF {
 		return nil, err
 	}
@@ -149,18 +154,18 @@ func (d *Data) MarshalBinary() ([]byte, error) {
 }
 
 func (d *Data) UnmarshalBinary(p []byte) error {
-	if l := len(p); 1 < 4 || 1 > DatagramSize {
+	if l := len(p); l < 4 || l > DatagramSize {
 		return errors.New("Invalid DATA")
 	}
 
-	var OpCode
+	var code OpCode
 
-	err := binary.Read(bytes.NewReader(p[:2]), binary.BigEndian, &OpCode)
-	if err != nil {
+	err := binary.Read(bytes.NewReader(p[:2]), binary.BigEndian, &code)
+	if err != nil || code != OpData {
 		return errors.New("Invalid DATA")
 	}
 
-	err = binary.Read(bytes.NewReader(p[:2]), binary.BigEndian, &d.Block)
+	err = binary.Read(bytes.NewReader(p[2:4]), binary.BigEndian, &d.Block)
 	if err != nil {
 		return errors.New("Invalid DATA")
 	}
@@ -179,15 +184,20 @@ func (a Ack) MarshalBinary() ([]byte, error) {
 	b := new(bytes.Buffer)
 	b.Grow(cap)
 
-	err := binary.Write(b, binary.BigEndian, OpAck) {
-		if err != nil {
-			return nil, err
-		}
+	err := binary.Write(b, binary.BigEndian, OpAck) // Write operation code
+	if err != nil {
+		return nil, err
+	}
 
-		return b.Bytes(), nil
+	err = binary.Write(b, binary.BigEndian, a) // Write block number
+	if err != nil {
+		return nil, err
 	}
 
-	func (a *ACK) UnmarshalBinary(p []byte) error {
+	return b.Bytes(), nil
+}
+
+	func (a *Ack) UnmarshalBinary(p []byte) error {
 		var code OpCode
 
 		r := bytes.NewReader(p)
@@ -221,7 +231,7 @@ func (a Ack) MarshalBinary() ([]byte, error) {
 			return nil, err
 		}
 
-		err := binary.Write(b, binary.BigEndian, e.Error)
+		err = binary.Write(b, binary.BigEndian, e.Error)
 		if err != nil {
 			return nil, err
 		}
@@ -265,9 +275,3 @@ func (a Ack) MarshalBinary() ([]byte, error) {
 
 		return err
 	}
-
-}
-
-
-
-
@@ -2,7 +2,10 @@ module networks
 
 go 1.23.2
 
-require golang.org/x/sys v0.26.0
+require (
+	golang.org/x/crypto v0.28.0
+	golang.org/x/sys v0.26.0
+)
 
 require (
 	github.com/awoodbeck/gnp v0.0.0-20240419140822-ff6599607212 // indirect
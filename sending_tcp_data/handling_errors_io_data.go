@@ -3,34 +3,38 @@
 package main
 
 import (
-	"errors"
 	"log"
 	"net"
 	"time"
+
+	"networks/sending_tcp_data/retry"
 )
 
+// Delay between write attempts, and how long each attempt may block
+// before it times out and is retried
 var (
-	err error
-	n   int
-	i   = 7 // Threshold for temporary write retries
+	writeBackoff = retry.Backoff{Initial: time.Second, Max: 10 * time.Second}
+	writeTimeout = 5 * time.Second
 )
 
 func WriteData(conn net.Conn) error {
-	for i := 7; i > 0; i-- { // Iterating with retry attempts
-		n, err = conn.Write([]byte("Hello world"))
-		if err != nil {
-			if nErr, ok := err.(net.Error); ok && nErr.Temporary() { // Correct type assertion
-				log.Println("Temporary error: ", nErr)
-				time.Sleep(10 * time.Second)
-				continue
-			}
-			return err // Return the error if it's not temporary
-		}
-		break // Exit the loop on success
-	}
+	w := retry.NewRetryingConn(conn, retry.RetryWriter{
+		MaxRetries: 7, // Threshold for temporary write retries
+		Backoff:    writeBackoff,
+		Classify: func(err error) bool {
+			// A busy kernel, a timed out attempt, or a reset, which
+			// goes away if conn reconnects underneath us
+			return retry.Transient(err) || retry.Timeout(err) || retry.Reset(err)
+		},
+		OnRetry: func(attempt int, err error, delay time.Duration) {
+			log.Printf("Temporary error (retry %d in %s): %v", attempt, delay, err)
+		},
+	})
+	w.AttemptTimeout = writeTimeout // Give every attempt its own write deadline
 
-	if i == 0 {
-		return errors.New("Temporary write failure threshold exceeded") // Error if retries exhausted
+	n, err := w.Write([]byte("Hello world"))
+	if err != nil {
+		return err // Permanent error, or retry.ErrRetriesExhausted
 	}
 
 	log.Printf("Wrote %d bytes to %s\n", n, conn.RemoteAddr()) // Log the successful write
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"networks/sending_tcp_data/retry"
+)
+
+func TestWriteDataRetriesTimeout(t *testing.T) {
+	writeBackoff = retry.Backoff{Initial: time.Millisecond, Max: time.Millisecond}
+	writeTimeout = 50 * time.Millisecond
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		// Nobody reads until the first attempt has timed out
+		time.Sleep(3 * writeTimeout)
+
+		buf := make([]byte, len("Hello world"))
+		_, err := io.ReadFull(client, buf)
+		if err != nil {
+			t.Error(err)
+		}
+		received <- string(buf)
+	}()
+
+	err := WriteData(server)
+	if err != nil {
+		t.Fatalf("expected the timed out write to be retried; actual %v", err)
+	}
+	if actual := <-received; actual != "Hello world" {
+		t.Errorf("expected %q; actual %q", "Hello world", actual)
+	}
+}
@@ -0,0 +1,66 @@
+package httpproxy
+
+import (
+	"net"
+	"strings"
+)
+
+// Allowlist holds the destinations a Proxy may connect to. Each entry
+// is a host with an optional port:
+//
+//	example.com        any port on example.com
+//	example.com:443    only port 443
+//	*.internal:*       any subdomain of internal, any port
+//	10.0.0.0/8:22      port 22 on any address in 10.0.0.0/8
+//
+// Host names are compared case-insensitively. Names are matched as
+// given by the client; they aren't resolved first.
+type Allowlist []string
+
+// Allowed reports whether hostport matches an entry in the list.
+func (a Allowlist) Allowed(hostport string) bool {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return false
+	}
+
+	for _, entry := range a {
+		h, p := splitEntry(entry)
+		if p != "*" && p != port {
+			continue
+		}
+		if matchHost(h, host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitEntry separates an entry into its host and port, defaulting the
+// port to "*". IPv6 addresses must be bracketed if a port is given.
+func splitEntry(entry string) (host, port string) {
+	if h, p, err := net.SplitHostPort(entry); err == nil {
+		return h, p
+	}
+
+	return strings.Trim(entry, "[]"), "*"
+}
+
+func matchHost(pattern, host string) bool {
+	if _, cidr, err := net.ParseCIDR(pattern); err == nil {
+		ip := net.ParseIP(host)
+		return ip != nil && cidr.Contains(ip)
+	}
+
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return len(host) > len(suffix)+1 &&
+			strings.HasSuffix(strings.ToLower(host), "."+strings.ToLower(suffix))
+	}
+
+	if ip := net.ParseIP(pattern); ip != nil {
+		return ip.Equal(net.ParseIP(host))
+	}
+
+	return strings.EqualFold(pattern, host)
+}
@@ -0,0 +1,251 @@
+// Package httpproxy implements an HTTP forward proxy. CONNECT requests
+// are tunneled by hijacking the client connection and splicing it to
+// the destination; plain HTTP requests are forwarded with their
+// hop-by-hop headers removed.
+package httpproxy
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"networks/sending_tcp_data/relay"
+	"networks/sending_tcp_data/socks5"
+	"networks/tcp_data_streams/tcpinfo"
+)
+
+// Credentials validates the username and password from a
+// Proxy-Authorization header. socks5.StaticCredentials satisfies it.
+type Credentials interface {
+	Valid(username, password string) bool
+}
+
+// Proxy is an http.Handler that acts as a forward proxy.
+type Proxy struct {
+	// Allowlist restricts the destinations clients may reach. A nil
+	// Allowlist allows every destination.
+	Allowlist Allowlist
+
+	// Credentials, if set, requires clients to send a Basic
+	// Proxy-Authorization header.
+	Credentials Credentials
+
+	// Dialer is used to reach destinations, both for CONNECT tunnels and
+	// forwarded requests. Defaults to a net.Dialer. A socks5.Dialer here
+	// chains this proxy through a SOCKS5 server.
+	Dialer socks5.ContextDialer
+
+	// Timeout limits how long the proxy waits to reach a destination.
+	Timeout time.Duration
+
+	// StatsInterval, if positive, logs TCP_INFO for the connection to
+	// each CONNECT target this often while the tunnel is up, and once as
+	// it closes, to tell a slow network from a slow client. Forwarded
+	// plain HTTP requests share pooled connections and aren't sampled.
+	// Linux only.
+	StatsInterval time.Duration
+
+	once      sync.Once
+	transport *http.Transport
+}
+
+// hopHeaders apply to a single connection and must not be forwarded
+// (RFC 9110, section 7.6.1).
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection", // Non-standard, but still sent by some clients
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !p.authorized(r) {
+		w.Header().Set("Proxy-Authenticate", `Basic realm="proxy"`)
+		http.Error(w, "Proxy authentication required", http.StatusProxyAuthRequired)
+		return
+	}
+
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r)
+		return
+	}
+
+	p.forward(w, r)
+}
+
+func (p *Proxy) authorized(r *http.Request) bool {
+	if p.Credentials == nil {
+		return true
+	}
+
+	scheme, encoded, ok := strings.Cut(r.Header.Get("Proxy-Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return false
+	}
+
+	username, password, ok := strings.Cut(string(decoded), ":")
+
+	return ok && p.Credentials.Valid(username, password)
+}
+
+func (p *Proxy) allowed(hostport string) bool {
+	return p.Allowlist == nil || p.Allowlist.Allowed(hostport)
+}
+
+func (p *Proxy) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	d := p.Dialer
+	if d == nil {
+		d = new(net.Dialer)
+	}
+
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	return d.DialContext(ctx, network, address)
+}
+
+// tunnel handles CONNECT host:port.
+func (p *Proxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	dst := r.Host
+	if _, _, err := net.SplitHostPort(dst); err != nil {
+		http.Error(w, "CONNECT requires host:port", http.StatusBadRequest)
+		return
+	}
+
+	if !p.allowed(dst) {
+		http.Error(w, "Destination not allowed", http.StatusForbidden)
+		return
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Tunneling not supported", http.StatusInternalServerError)
+		return
+	}
+
+	target, err := p.dial(r.Context(), "tcp", dst)
+	if err != nil {
+		log.Printf("[%s] connect %s: %v", r.RemoteAddr, dst, err)
+		http.Error(w, "Unable to reach destination", http.StatusBadGateway)
+		return
+	}
+	defer func() { _ = target.Close() }()
+
+	conn, buf, err := hj.Hijack()
+	if err != nil {
+		log.Printf("[%s] hijack: %v", r.RemoteAddr, err)
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	_ = conn.SetDeadline(time.Time{}) // Clear any server read/write timeouts
+
+	_, err = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	if err != nil {
+		return
+	}
+
+	// The client may have sent tunnel data right behind the request,
+	// before waiting for our response. Pass along whatever was buffered.
+	if n := buf.Reader.Buffered(); n > 0 {
+		b, _ := buf.Reader.Peek(n)
+		if _, err = target.Write(b); err != nil {
+			return
+		}
+	}
+
+	if p.StatsInterval > 0 {
+		target = tcpinfo.Watch(target, p.StatsInterval, func(i *tcpinfo.Info) {
+			log.Printf("[%s] %s: %s", r.RemoteAddr, dst, i)
+		})
+	}
+
+	err = relay.Proxy(conn, target)
+	if err != nil {
+		log.Printf("[%s] relay to %s: %v", r.RemoteAddr, dst, err)
+	}
+}
+
+// forward handles absolute-form requests such as GET http://host/path.
+func (p *Proxy) forward(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Host == "" || r.URL.Scheme != "http" {
+		http.Error(w, "Only absolute http:// requests are proxied", http.StatusBadRequest)
+		return
+	}
+
+	dst := r.URL.Host
+	if r.URL.Port() == "" {
+		dst = net.JoinHostPort(r.URL.Hostname(), "80")
+	}
+	if !p.allowed(dst) {
+		http.Error(w, "Destination not allowed", http.StatusForbidden)
+		return
+	}
+
+	out := r.Clone(r.Context())
+	out.RequestURI = "" // Must be empty for client requests
+	removeHopHeaders(out.Header)
+
+	resp, err := p.roundTripper().RoundTrip(out)
+	if err != nil {
+		log.Printf("[%s] forward %s: %v", r.RemoteAddr, r.URL, err)
+		http.Error(w, "Unable to reach destination", http.StatusBadGateway)
+		return
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	removeHopHeaders(resp.Header)
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	_, _ = io.Copy(w, resp.Body)
+}
+
+func (p *Proxy) roundTripper() http.RoundTripper {
+	p.once.Do(func() {
+		p.transport = &http.Transport{
+			DialContext:         p.dial,
+			MaxIdleConnsPerHost: 4,
+			IdleConnTimeout:     90 * time.Second,
+		}
+	})
+
+	return p.transport
+}
+
+// removeHopHeaders deletes the hop-by-hop headers, including any the
+// Connection header names.
+func removeHopHeaders(h http.Header) {
+	for _, v := range h.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
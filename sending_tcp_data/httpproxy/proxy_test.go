@@ -0,0 +1,246 @@
+package httpproxy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"networks/sending_tcp_data/socks5"
+)
+
+// echoTCP starts a TCP server that echoes everything it reads.
+func echoTCP(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer func() { _ = c.Close() }()
+				_, _ = io.Copy(c, c)
+			}(conn)
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func TestConnectTunnel(t *testing.T) {
+	target := echoTCP(t)
+	proxy := httptest.NewServer(new(Proxy))
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	// Send tunnel data right behind the CONNECT request to make sure the
+	// proxy relays bytes it buffered while reading the request.
+	_, err = conn.Write([]byte("CONNECT " + target + " HTTP/1.1\r\nHost: " + target + "\r\n\r\nping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d; actual status %d", http.StatusOK, resp.StatusCode)
+	}
+
+	buf := make([]byte, 4)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, []byte("ping")) {
+		t.Errorf("expected reply %q; actual reply %q", "ping", buf)
+	}
+}
+
+func TestConnectHTTPS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("secret"))
+		}))
+	defer ts.Close()
+
+	proxy := httptest.NewServer(&Proxy{
+		Allowlist:   Allowlist{"127.0.0.1"},
+		Credentials: socks5.StaticCredentials{"dev": "laptop"},
+	})
+	defer proxy.Close()
+
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		user *url.Userinfo
+		code int
+	}{
+		{url.UserPassword("dev", "laptop"), http.StatusOK},
+		{url.UserPassword("dev", "desktop"), http.StatusProxyAuthRequired},
+		{nil, http.StatusProxyAuthRequired},
+	}
+
+	for i, c := range testCases {
+		u := *proxyURL
+		u.User = c.user
+
+		transport := ts.Client().Transport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(&u)
+		client := &http.Client{Transport: transport, Timeout: 5 * time.Second}
+
+		resp, err := client.Get(ts.URL)
+		if c.code != http.StatusOK {
+			// The transport reports a failed CONNECT as an error.
+			if err == nil {
+				_ = resp.Body.Close()
+				t.Errorf("%d: expected CONNECT to fail", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+
+		b, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if string(b) != "secret" {
+			t.Errorf("%d: unexpected body %q", i, b)
+		}
+	}
+}
+
+func TestForwardRemovesHopHeaders(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			for _, h := range []string{"Proxy-Authorization", "X-Hop", "Keep-Alive"} {
+				if r.Header.Get(h) != "" {
+					t.Errorf("hop-by-hop header %s was forwarded", h)
+				}
+			}
+			if r.Header.Get("X-End-To-End") != "yes" {
+				t.Error("end-to-end header was not forwarded")
+			}
+
+			w.Header().Set("Connection", "X-Backend-Hop")
+			w.Header().Set("X-Backend-Hop", "1")
+			_, _ = w.Write([]byte("forwarded"))
+		}))
+	defer ts.Close()
+
+	proxy := httptest.NewServer(&Proxy{Allowlist: Allowlist{"127.0.0.1:*"}})
+	defer proxy.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Connection", "X-Hop")
+	req.Header.Set("X-Hop", "1")
+	req.Header.Set("Keep-Alive", "timeout=5")
+	req.Header.Set("X-End-To-End", "yes")
+
+	// Write the request in absolute form straight to the proxy so the
+	// client library doesn't strip any headers on its own.
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	err = req.WriteProxy(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.Header.Get("X-Backend-Hop") != "" {
+		t.Error("hop-by-hop response header was forwarded")
+	}
+
+	b, _ := io.ReadAll(resp.Body)
+	if string(b) != "forwarded" {
+		t.Errorf("unexpected body %q", b)
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	a := Allowlist{"example.com:443", "*.internal:*", "10.0.0.0/8:22", "[::1]:8080"}
+
+	testCases := []struct {
+		hostport string
+		allowed  bool
+	}{
+		{"example.com:443", true},
+		{"EXAMPLE.com:443", true},
+		{"example.com:80", false},
+		{"api.internal:9000", true},
+		{"internal:9000", false},
+		{"10.1.2.3:22", true},
+		{"10.1.2.3:23", false},
+		{"11.1.2.3:22", false},
+		{"[::1]:8080", true},
+		{"[::1]:8081", false},
+		{"not-a-hostport", false},
+	}
+
+	for i, c := range testCases {
+		if actual := a.Allowed(c.hostport); actual != c.allowed {
+			t.Errorf("%d: %s: expected %t; actual %t", i, c.hostport, c.allowed, actual)
+		}
+	}
+}
+
+func TestDisallowedDestination(t *testing.T) {
+	proxy := httptest.NewServer(&Proxy{Allowlist: Allowlist{"example.com:443"}})
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	_, err = conn.Write([]byte("CONNECT 127.0.0.1:22 HTTP/1.1\r\nHost: 127.0.0.1:22\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected status %d; actual status %d", http.StatusForbidden, resp.StatusCode)
+	}
+}
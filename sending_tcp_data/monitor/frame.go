@@ -0,0 +1,134 @@
+package monitor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Side identifies one end of a session.
+type Side uint8
+
+const (
+	Client Side = iota + 1
+	Server
+)
+
+func (s Side) String() string {
+	switch s {
+	case Client:
+		return "client"
+	case Server:
+		return "server"
+	default:
+		return fmt.Sprintf("Side(%d)", uint8(s))
+	}
+}
+
+// Peer returns the opposite end of the session.
+func (s Side) Peer() Side {
+	if s == Client {
+		return Server
+	}
+
+	return Client
+}
+
+// Frame is one chunk of data seen on a recorded connection.
+type Frame struct {
+	From   Side          // Which end sent the data
+	Offset time.Duration // Time since the session started
+	Data   []byte
+}
+
+// Recording file layout: the magic header, then frames of
+//
+//	1-byte sender | 8-byte offset (ns) | 4-byte length | payload
+//
+// with all integers big-endian, like the payloads in types.go.
+const (
+	magic = "NETREC\x00\x01"
+
+	frameHeaderSize = 1 + 8 + 4
+
+	MaxFrameSize uint32 = 10 << 20 // 10 mb
+)
+
+var (
+	ErrBadMagic     = errors.New("monitor: not a recording")
+	ErrMaxFrameSize = errors.New("monitor: maximum frame size exceeded")
+)
+
+// WriteHeader writes the magic bytes that start every recording.
+func WriteHeader(w io.Writer) error {
+	_, err := io.WriteString(w, magic)
+	return err
+}
+
+// MarshalBinary encodes the frame, header and payload.
+func (f Frame) MarshalBinary() ([]byte, error) {
+	if uint64(len(f.Data)) > uint64(MaxFrameSize) {
+		return nil, ErrMaxFrameSize
+	}
+
+	b := make([]byte, frameHeaderSize, frameHeaderSize+len(f.Data))
+	b[0] = byte(f.From)
+	binary.BigEndian.PutUint64(b[1:], uint64(f.Offset))
+	binary.BigEndian.PutUint32(b[9:], uint32(len(f.Data)))
+
+	return append(b, f.Data...), nil
+}
+
+// ReadFrame decodes the next frame from r.
+func ReadFrame(r io.Reader) (Frame, error) {
+	var hdr [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return Frame{}, err // io.EOF here means a clean end of recording
+	}
+
+	f := Frame{
+		From:   Side(hdr[0]),
+		Offset: time.Duration(binary.BigEndian.Uint64(hdr[1:])),
+	}
+	if f.From != Client && f.From != Server {
+		return Frame{}, fmt.Errorf("monitor: invalid sender %d", hdr[0])
+	}
+
+	size := binary.BigEndian.Uint32(hdr[9:])
+	if size > MaxFrameSize {
+		return Frame{}, ErrMaxFrameSize
+	}
+
+	f.Data = make([]byte, size)
+	if _, err := io.ReadFull(r, f.Data); err != nil {
+		return Frame{}, io.ErrUnexpectedEOF
+	}
+
+	return f, nil
+}
+
+// ReadRecording reads a whole recording: the header and every frame.
+func ReadRecording(r io.Reader) ([]Frame, error) {
+	br := bufio.NewReader(r)
+
+	hdr := make([]byte, len(magic))
+	if _, err := io.ReadFull(br, hdr); err != nil || !bytes.Equal(hdr, []byte(magic)) {
+		return nil, ErrBadMagic
+	}
+
+	var frames []Frame
+	for {
+		f, err := ReadFrame(br)
+		if err == io.EOF {
+			return frames, nil
+		}
+		if err != nil {
+			return frames, err
+		}
+		frames = append(frames, f)
+	}
+}
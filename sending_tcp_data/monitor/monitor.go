@@ -0,0 +1,26 @@
+// Package monitor captures the traffic on a net.Conn. Monitor dumps it
+// to a log.Logger for eyeballing; Recorder writes timestamped,
+// direction-tagged frames to a file that a Replayer can later play back
+// as either end of the session.
+package monitor
+
+import (
+	"log"
+)
+
+// Monitor embeds a log.Logger and implements io.Writer so it can be
+// used with io.TeeReader and io.MultiWriter to log network traffic.
+type Monitor struct {
+	*log.Logger
+}
+
+// Write implements the io.Writer interface. Logging errors are reported
+// but never interrupt the traffic being monitored.
+func (m *Monitor) Write(p []byte) (int, error) {
+	err := m.Output(2, string(p))
+	if err != nil {
+		log.Println(err)
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,310 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func ExampleMonitor() {
+	monitor := &Monitor{Logger: log.New(os.Stdout, "monitor: ", 0)}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		monitor.Fatal(err)
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		b := make([]byte, 1024)
+		r := io.TeeReader(conn, monitor)
+
+		n, err := r.Read(b)
+		if err != nil && err != io.EOF {
+			monitor.Println(err)
+			return
+		}
+
+		w := io.MultiWriter(conn, monitor)
+
+		_, err = w.Write(b[:n]) // Echo the message
+		if err != nil && err != io.EOF {
+			monitor.Println(err)
+			return
+		}
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		monitor.Fatal(err)
+	}
+
+	_, err = conn.Write([]byte("Test\n"))
+	if err != nil {
+		monitor.Fatal(err)
+	}
+
+	_ = conn.Close()
+	<-done
+
+	// Output:
+	// monitor: Test
+	// monitor: Test
+}
+
+// echoServer answers each message with "echo: " and the message.
+func echoServer(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer func() { _ = c.Close() }()
+
+				buf := make([]byte, 1024)
+				for {
+					n, err := c.Read(buf)
+					if err != nil {
+						return
+					}
+					_, err = c.Write(append([]byte("echo: "), buf[:n]...))
+					if err != nil {
+						return
+					}
+				}
+			}(conn)
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+// recordSession records a short client session against the echo server.
+func recordSession(t *testing.T, addr string) []Frame {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := new(bytes.Buffer)
+	r, err := NewRecorder(conn, rec, Client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1024)
+	for _, msg := range []string{"hello", "world"} {
+		_, err = r.Write([]byte(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.ReadFull(r, buf[:len("echo: ")+len(msg)])
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond) // Think time, to be reproduced
+	}
+	_ = r.Close()
+
+	if err = r.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	frames, err := ReadRecording(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return frames
+}
+
+func TestRecorder(t *testing.T) {
+	frames := recordSession(t, echoServer(t))
+
+	// TCP might coalesce the echoes, so only check the direction of the
+	// first frame and the total traffic each way.
+	if len(frames) < 2 || frames[0].From != Client {
+		t.Fatalf("unexpected frames %+v", frames)
+	}
+
+	sent, received := new(bytes.Buffer), new(bytes.Buffer)
+	var last time.Duration
+	for _, f := range frames {
+		if f.Offset < last {
+			t.Errorf("frame offsets go backwards: %s after %s", f.Offset, last)
+		}
+		last = f.Offset
+
+		if f.From == Client {
+			sent.Write(f.Data)
+		} else {
+			received.Write(f.Data)
+		}
+	}
+
+	if sent.String() != "helloworld" {
+		t.Errorf("unexpected client traffic %q", sent)
+	}
+	if received.String() != "echo: helloecho: world" {
+		t.Errorf("unexpected server traffic %q", received)
+	}
+}
+
+func TestReplayAsClient(t *testing.T) {
+	addr := echoServer(t)
+	frames := recordSession(t, addr)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	r := &Replayer{Frames: frames, Side: Client, Speed: 1, Strict: true, Timeout: time.Second}
+
+	start := time.Now()
+	err = r.Replay(context.Background(), conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second message went out after 50ms of think time.
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("replay ignored the original timing; took %s", elapsed)
+	}
+}
+
+func TestReplayAsServer(t *testing.T) {
+	frames := recordSession(t, echoServer(t))
+
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+
+	errs := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		r := &Replayer{Frames: frames, Side: Server, Strict: true, Timeout: time.Second}
+		errs <- r.Replay(context.Background(), conn)
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	buf := make([]byte, 1024)
+	for _, msg := range []string{"hello", "world"} {
+		_, err = conn.Write([]byte(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := "echo: " + msg
+		_, err = io.ReadFull(conn, buf[:len(expected)])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := string(buf[:len(expected)]); actual != expected {
+			t.Errorf("expected %q; actual %q", expected, actual)
+		}
+	}
+
+	if err = <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+
+	frames := []Frame{
+		{From: Client, Data: []byte("ping")},
+		{From: Server, Data: []byte("pong")},
+	}
+
+	go func() {
+		defer func() { _ = server.Close() }()
+		buf := make([]byte, 4)
+		_, _ = io.ReadFull(server, buf)
+		_, _ = server.Write([]byte("PONG"))
+	}()
+
+	r := &Replayer{Frames: frames, Side: Client, Strict: true}
+	err := r.Replay(context.Background(), client)
+
+	var mErr *MismatchError
+	if !errors.As(err, &mErr) {
+		t.Fatalf("expected a mismatch; actual %v", err)
+	}
+	if mErr.Frame != 1 || string(mErr.Actual) != "PONG" {
+		t.Errorf("unexpected mismatch %v", mErr)
+	}
+}
+
+// TestReplayCancel checks canceling a replay unblocks it, and leaves conn
+// usable afterward.
+func TestReplayCancel(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	r := &Replayer{Frames: []Frame{{From: Server, Data: []byte("never")}}, Side: Client}
+	if err := r.Replay(ctx, client); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v; actual %v", context.DeadlineExceeded, err)
+	}
+
+	go func() { _, _ = server.Write([]byte("later")) }()
+
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatalf("conn unusable after a canceled replay: %v", err)
+	}
+}
+
+func TestReadRecordingBadMagic(t *testing.T) {
+	_, err := ReadRecording(bytes.NewReader([]byte("not a recording")))
+	if err != ErrBadMagic {
+		t.Fatalf("expected %v; actual %v", ErrBadMagic, err)
+	}
+}
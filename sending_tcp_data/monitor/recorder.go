@@ -0,0 +1,86 @@
+package monitor
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Recorder is a net.Conn that writes every chunk it reads or writes to
+// a recording. Like Monitor, recording never interrupts the traffic: if
+// writing the recording fails, the connection carries on and the first
+// recording error is available from Err.
+type Recorder struct {
+	net.Conn
+
+	side  Side // The end of the session conn belongs to
+	start time.Time
+
+	mu  sync.Mutex // Serializes frames from concurrent reads and writes
+	w   io.Writer
+	err error
+}
+
+// NewRecorder writes the recording header to w and returns conn wrapped
+// in a Recorder. side says which end of the session conn is: Client for
+// a dialed connection, Server for an accepted one.
+func NewRecorder(conn net.Conn, w io.Writer, side Side) (*Recorder, error) {
+	if err := WriteHeader(w); err != nil {
+		return nil, err
+	}
+
+	return &Recorder{Conn: conn, side: side, start: time.Now(), w: w}, nil
+}
+
+func (r *Recorder) Read(p []byte) (int, error) {
+	n, err := r.Conn.Read(p)
+	if n > 0 {
+		r.record(r.side.Peer(), p[:n]) // Data we read came from the other end
+	}
+
+	return n, err
+}
+
+func (r *Recorder) Write(p []byte) (int, error) {
+	n, err := r.Conn.Write(p)
+	if n > 0 {
+		r.record(r.side, p[:n])
+	}
+
+	return n, err
+}
+
+// Err returns the first error encountered writing the recording.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.err
+}
+
+func (r *Recorder) record(from Side, p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return // The recording is already broken; don't add a gap to it
+	}
+
+	// Taking the offset under the lock keeps frames in time order.
+	b, err := Frame{From: from, Offset: time.Since(r.start), Data: p}.MarshalBinary()
+	if err == nil {
+		_, err = r.w.Write(b)
+	}
+	r.err = err
+}
+
+// CloseWrite half-closes the underlying connection if it supports it.
+func (r *Recorder) CloseWrite() error {
+	if cw, ok := r.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+
+	return errors.New("monitor: underlying connection can't half-close")
+}
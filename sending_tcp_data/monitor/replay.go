@@ -0,0 +1,116 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Replayer plays back one end of a recorded session over a connection.
+// Frames sent by the played side are written at their original offsets;
+// frames sent by the other side are read from the connection and,
+// in strict mode, compared against the recording.
+//
+// Frames are handled in recorded order, which suits request/response
+// protocols. TCP may split or merge chunks differently than during the
+// recording, so received data is compared as a stream, not per Read.
+type Replayer struct {
+	Frames []Frame
+	Side   Side // The end of the session to play
+
+	// Speed scales the original timing: 1 replays in real time, 2 twice
+	// as fast. Zero sends every frame as soon as possible.
+	Speed float64
+
+	// Strict makes Replay fail with a *MismatchError when the peer sends
+	// something other than what was recorded.
+	Strict bool
+
+	// Timeout limits how long to wait for each expected frame. Zero
+	// means no limit.
+	Timeout time.Duration
+}
+
+// MismatchError describes where a peer diverged from the recording.
+type MismatchError struct {
+	Frame    int // Index into Replayer.Frames
+	Expected []byte
+	Actual   []byte
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("monitor: frame %d: expected %q; actual %q", e.Frame, e.Expected, e.Actual)
+}
+
+// Replay plays r.Frames over conn. It returns when every frame has been
+// sent or received, when ctx is done, or on the first error.
+func (r *Replayer) Replay(ctx context.Context, conn net.Conn) (err error) {
+	canceled := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Unix(1, 0)) // Unblock pending I/O
+		close(canceled)
+	})
+	defer func() {
+		if !stop() {
+			<-canceled // Don't let the past deadline land after the reset
+			_ = conn.SetDeadline(time.Time{})
+			err = ctx.Err()
+		} else if r.Timeout > 0 {
+			_ = conn.SetReadDeadline(time.Time{})
+		}
+	}()
+
+	start := time.Now()
+
+	for i, f := range r.Frames {
+		if f.From == r.Side {
+			if err = r.sleepUntil(ctx, start, f.Offset); err != nil {
+				return err
+			}
+			if _, err = conn.Write(f.Data); err != nil {
+				return fmt.Errorf("monitor: frame %d: %w", i, err)
+			}
+			continue
+		}
+
+		if r.Timeout > 0 {
+			_ = conn.SetReadDeadline(time.Now().Add(r.Timeout))
+		}
+
+		actual := make([]byte, len(f.Data))
+		n, err := io.ReadFull(conn, actual)
+		if err != nil {
+			return fmt.Errorf("monitor: frame %d: read %d of %d bytes: %w", i, n, len(actual), err)
+		}
+
+		if r.Strict && !bytes.Equal(f.Data, actual) {
+			return &MismatchError{Frame: i, Expected: f.Data, Actual: actual}
+		}
+	}
+
+	return nil
+}
+
+func (r *Replayer) sleepUntil(ctx context.Context, start time.Time, offset time.Duration) error {
+	if r.Speed <= 0 {
+		return nil
+	}
+
+	wait := time.Until(start.Add(time.Duration(float64(offset) / r.Speed)))
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
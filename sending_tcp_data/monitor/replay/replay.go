@@ -0,0 +1,162 @@
+// replay records TCP sessions and plays them back.
+//
+// Record by proxying clients to a real server:
+//
+//	replay -mode record -a 127.0.0.1:9000 -u 127.0.0.1:8000 -f session.rec
+//
+// Then stand in for the server, or for the client, using the recording:
+//
+//	replay -mode server -a 127.0.0.1:9000 -f session.rec
+//	replay -mode client -a 127.0.0.1:8000 -f session.rec
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"time"
+
+	"networks/sending_tcp_data/monitor"
+	"networks/sending_tcp_data/relay"
+)
+
+var (
+	mode     = flag.String("mode", "client", "record, client or server")
+	address  = flag.String("a", "127.0.0.1:9000", "address to listen on (record, server) or dial (client)")
+	upstream = flag.String("u", "", "server to proxy to while recording")
+	file     = flag.String("f", "session.rec", "recording file")
+	speed    = flag.Float64("speed", 1, "timing multiplier; 0 sends without delays")
+	strict   = flag.Bool("strict", true, "fail when the peer deviates from the recording")
+	timeout  = flag.Duration("timeout", 10*time.Second, "how long to wait for each expected frame")
+)
+
+func main() {
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var err error
+
+	switch *mode {
+	case "record":
+		err = record(ctx)
+	case "client", "server":
+		err = replay(ctx)
+	default:
+		log.Fatalf("unknown mode %q", *mode)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// record proxies a single client session to the upstream server and
+// records it from the server's point of view.
+func record(ctx context.Context) (err error) {
+	if *upstream == "" {
+		log.Fatal("-u is required when recording")
+	}
+
+	conn, err := acceptOne(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	to, err := net.Dial("tcp", *upstream)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = to.Close() }()
+
+	f, err := os.Create(*file)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	defer func() {
+		// A recording missing its tail is as bad as a failed session.
+		if fErr := w.Flush(); err == nil {
+			err = fErr
+		}
+		if cErr := f.Close(); err == nil {
+			err = cErr
+		}
+	}()
+
+	rec, err := monitor.NewRecorder(conn, w, monitor.Server)
+	if err != nil {
+		return err
+	}
+
+	err = relay.Proxy(rec, to)
+	if rErr := rec.Err(); rErr != nil {
+		log.Printf("recording incomplete: %v", rErr)
+	}
+	log.Printf("recorded session with %s to %s", conn.RemoteAddr(), *file)
+
+	return err
+}
+
+func replay(ctx context.Context) error {
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	frames, err := monitor.ReadRecording(f)
+	_ = f.Close()
+	if err != nil {
+		return err
+	}
+
+	r := &monitor.Replayer{
+		Frames:  frames,
+		Side:    monitor.Client,
+		Speed:   *speed,
+		Strict:  *strict,
+		Timeout: *timeout,
+	}
+
+	var conn net.Conn
+	if *mode == "server" {
+		r.Side = monitor.Server
+		conn, err = acceptOne(ctx)
+	} else {
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, "tcp", *address)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	err = r.Replay(ctx, conn)
+	if err == nil {
+		log.Printf("replayed %d frames as the %s", len(frames), r.Side)
+	}
+
+	return err
+}
+
+func acceptOne(ctx context.Context) (net.Conn, error) {
+	l, err := net.Listen("tcp", *address)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = l.Close() }()
+
+	go func() {
+		<-ctx.Done()
+		_ = l.Close()
+	}()
+
+	log.Printf("Listening on %s ...\n", l.Addr())
+
+	return l.Accept()
+}
@@ -0,0 +1,52 @@
+// Package relay splices two network connections together so that
+// anything one side sends is copied to the other, in both directions.
+package relay
+
+import (
+	"errors"
+	"io"
+	"net"
+)
+
+// closeWriter is implemented by connections that support half-closing,
+// such as *net.TCPConn and *net.UnixConn.
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// Proxy copies data from one connection to the other in both directions
+// and returns once both directions are finished.
+//
+// When one side stops sending, its EOF is passed along with CloseWrite
+// (if the destination supports it) so the other side can still send its
+// reply. If a half-close isn't possible, both connections are closed.
+// The first error other than a closed connection is returned.
+func Proxy(from, to net.Conn) error {
+	errs := make(chan error, 2)
+
+	pipe := func(dst, src net.Conn) {
+		_, err := io.Copy(dst, src)
+
+		cw, ok := dst.(closeWriter)
+		if err != nil || !ok || cw.CloseWrite() != nil {
+			// Can't half-close, so tear down both sides to unblock the
+			// goroutine copying the other direction.
+			_ = dst.Close()
+			_ = src.Close()
+		}
+
+		errs <- err
+	}
+
+	go pipe(to, from) // from messages to to
+	go pipe(from, to) // to replies to from
+
+	var err error
+	for i := 0; i < 2; i++ {
+		if e := <-errs; err == nil && !errors.Is(e, net.ErrClosed) {
+			err = e
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,80 @@
+package relay
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+func TestProxyHalfClose(t *testing.T) {
+	// Server reads until the client is done sending, then replies.
+	server, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = server.Close() }()
+
+	go func() {
+		conn, err := server.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		b, err := io.ReadAll(conn) // Blocks until the client's EOF is relayed
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		_, _ = conn.Write(append([]byte("got "), b...))
+	}()
+
+	proxyServer, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = proxyServer.Close() }()
+
+	done := make(chan error, 1)
+	go func() {
+		from, err := proxyServer.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer func() { _ = from.Close() }()
+
+		to, err := net.Dial("tcp", server.Addr().String())
+		if err != nil {
+			done <- err
+			return
+		}
+		defer func() { _ = to.Close() }()
+
+		done <- Proxy(from, to)
+	}()
+
+	conn, err := net.Dial("tcp", proxyServer.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	_, err = conn.Write([]byte("ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.(*net.TCPConn).CloseWrite() // Done sending; still expecting a reply
+
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := string(reply); actual != "got ping" {
+		t.Errorf("expected reply %q; actual reply %q", "got ping", actual)
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("proxy: %v", err)
+	}
+}
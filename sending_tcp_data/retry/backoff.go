@@ -0,0 +1,109 @@
+// Package retry retries writes that fail with transient network errors,
+// backing off exponentially with jitter between attempts.
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Backoff computes exponentially growing delays with random jitter.
+// The zero value is usable and means 100ms doubling up to 10s, with
+// up to 20% jitter either way.
+type Backoff struct {
+	Initial    time.Duration // Delay before the first retry
+	Max        time.Duration // Upper bound on any delay
+	Multiplier float64       // Growth factor per attempt
+	Jitter     float64       // Fraction of the delay to randomize, 0 to 1
+}
+
+// Delay returns how long to wait before retry number attempt, counting
+// from zero.
+func (b Backoff) Delay(attempt int) time.Duration {
+	initial, maxDelay, mult, jitter := b.Initial, b.Max, b.Multiplier, b.Jitter
+	if initial <= 0 {
+		initial = 100 * time.Millisecond
+	}
+	if maxDelay <= 0 {
+		maxDelay = 10 * time.Second
+	}
+	if mult < 1 {
+		mult = 2
+	}
+	if jitter <= 0 || jitter > 1 {
+		jitter = 0.2
+	}
+
+	d := float64(initial)
+	for i := 0; i < attempt && d < float64(maxDelay); i++ {
+		d *= mult
+	}
+	d = min(d, float64(maxDelay))
+
+	// Spread the delay over [d-jitter*d, d+jitter*d] so many clients
+	// failing at once don't retry in lockstep.
+	d += (rand.Float64()*2 - 1) * jitter * d
+
+	return time.Duration(min(d, float64(maxDelay)))
+}
+
+// Sleep waits for the delay before retry number attempt, or until ctx
+// is done.
+func (b Backoff) Sleep(ctx context.Context, attempt int) error {
+	return sleep(ctx, b.Delay(attempt))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Classifier reports whether err is transient, meaning the operation
+// that failed is worth retrying.
+type Classifier func(err error) bool
+
+// Transient is the default Classifier. It treats EAGAIN/EWOULDBLOCK,
+// EINTR and ENOBUFS as transient: the kernel is busy, and the same write
+// may go through in a moment. It replaces the deprecated
+// net.Error.Temporary method.
+//
+// Other failures need more than waiting. A timeout repeats until someone
+// moves the deadline, as RetryingConn does with AttemptTimeout, and a
+// reset connection stays reset unless the writer reconnects underneath,
+// like a reconnect.ReconnectingConn. Classify those with Timeout and
+// Reset where they apply.
+func Transient(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, syscall.EWOULDBLOCK) ||
+		errors.Is(err, syscall.EINTR) ||
+		errors.Is(err, syscall.ENOBUFS)
+}
+
+// Timeout reports whether err is a timeout, including an expired
+// deadline.
+func Timeout(err error) bool {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+
+	var nErr net.Error
+
+	return errors.As(err, &nErr) && nErr.Timeout()
+}
+
+// Reset reports whether the peer reset the connection (ECONNRESET).
+func Reset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET)
+}
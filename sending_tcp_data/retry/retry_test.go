@@ -0,0 +1,245 @@
+package retry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// flakyWriter fails the first len(errs) writes with the given errors,
+// writing partial bytes of each failed attempt.
+type flakyWriter struct {
+	buf     bytes.Buffer
+	errs    []error
+	partial int // Bytes accepted by each failing write
+}
+
+func (f *flakyWriter) Write(p []byte) (int, error) {
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		n := min(f.partial, len(p))
+		f.buf.Write(p[:n])
+		return n, err
+	}
+
+	return f.buf.Write(p)
+}
+
+var fast = Backoff{Initial: time.Millisecond, Max: 5 * time.Millisecond}
+
+func TestRetryWriterTransient(t *testing.T) {
+	fw := &flakyWriter{errs: []error{syscall.EAGAIN, syscall.EINTR}}
+	m := new(Metrics)
+
+	var retries []int
+	w := &RetryWriter{W: fw, MaxRetries: -1, Backoff: fast, Metrics: m,
+		OnRetry: func(attempt int, _ error, _ time.Duration) {
+			retries = append(retries, attempt)
+		},
+	}
+
+	n, err := w.Write([]byte("Hello world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 11 || fw.buf.String() != "Hello world" {
+		t.Errorf("unexpected write of %d bytes: %q", n, fw.buf.String())
+	}
+	if len(retries) != 2 || retries[0] != 1 || retries[1] != 2 {
+		t.Errorf("unexpected retries %v", retries)
+	}
+
+	s := m.Stats()
+	if s.Writes != 1 || s.Attempts != 3 || s.Retries != 2 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestRetryWriterResumesPartialWrite(t *testing.T) {
+	fw := &flakyWriter{errs: []error{syscall.EAGAIN, syscall.EAGAIN}, partial: 4}
+	m := new(Metrics)
+	w := &RetryWriter{W: fw, MaxRetries: 3, Backoff: fast, Metrics: m}
+
+	n, err := w.Write([]byte("Hello world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is sent twice.
+	if n != 11 || fw.buf.String() != "Hello world" {
+		t.Errorf("unexpected write of %d bytes: %q", n, fw.buf.String())
+	}
+	if s := m.Stats(); s.Resumed != 4+4 {
+		t.Errorf("expected 8 resumed bytes; actual %d", s.Resumed)
+	}
+}
+
+func TestRetryWriterExhausted(t *testing.T) {
+	errs := make([]error, 10)
+	for i := range errs {
+		errs[i] = syscall.ENOBUFS
+	}
+	fw := &flakyWriter{errs: errs}
+	m := new(Metrics)
+	w := &RetryWriter{W: fw, MaxRetries: 3, Backoff: fast, Metrics: m}
+
+	_, err := w.Write([]byte("Hello world"))
+	if !errors.Is(err, ErrRetriesExhausted) {
+		t.Fatalf("expected %v; actual %v", ErrRetriesExhausted, err)
+	}
+	if !errors.Is(err, syscall.ENOBUFS) {
+		t.Errorf("expected the last error to be wrapped; actual %v", err)
+	}
+
+	if s := m.Stats(); s.Attempts != 4 || s.Exhausted != 1 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestRetryWriterNoRetries(t *testing.T) {
+	fw := &flakyWriter{errs: []error{syscall.EAGAIN}}
+	m := new(Metrics)
+	w := &RetryWriter{W: fw, Metrics: m}
+
+	_, err := w.Write([]byte("Hello world"))
+	if !errors.Is(err, ErrRetriesExhausted) || !errors.Is(err, syscall.EAGAIN) {
+		t.Fatalf("expected %v; actual %v", ErrRetriesExhausted, err)
+	}
+	if s := m.Stats(); s.Attempts != 1 || s.Retries != 0 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestRetryWriterPermanent(t *testing.T) {
+	fw := &flakyWriter{errs: []error{syscall.EPIPE}}
+	m := new(Metrics)
+	w := &RetryWriter{W: fw, Backoff: fast, Metrics: m}
+
+	_, err := w.Write([]byte("Hello world"))
+	if !errors.Is(err, syscall.EPIPE) || errors.Is(err, ErrRetriesExhausted) {
+		t.Fatalf("expected %v; actual %v", syscall.EPIPE, err)
+	}
+	if s := m.Stats(); s.Attempts != 1 || s.Permanent != 1 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestRetryWriterContext(t *testing.T) {
+	fw := &flakyWriter{errs: []error{syscall.EAGAIN}}
+	m := new(Metrics)
+	w := &RetryWriter{W: fw, MaxRetries: 1, Backoff: Backoff{Initial: time.Minute}, Metrics: m}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := w.WriteContext(ctx, []byte("Hello world"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v; actual %v", context.DeadlineExceeded, err)
+	}
+	if s := m.Stats(); s.Cancelled != 1 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2, Jitter: 0.1}
+
+	for attempt, expected := range []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	} {
+		for range 100 {
+			d := b.Delay(attempt)
+			if d < expected*9/10 || d > min(expected*11/10, b.Max) {
+				t.Fatalf("attempt %d: delay %s out of range around %s", attempt, d, expected)
+			}
+		}
+	}
+}
+
+func TestTransient(t *testing.T) {
+	for _, c := range []struct {
+		err       error
+		transient bool
+	}{
+		{syscall.EAGAIN, true},
+		{syscall.ECONNRESET, false},
+		{&net.OpError{Op: "write", Err: os.ErrDeadlineExceeded}, false},
+		{&net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.ENOBUFS)}, true},
+		{syscall.EPIPE, false},
+		{io.EOF, false},
+		{net.ErrClosed, false},
+		{nil, false},
+	} {
+		if actual := Transient(c.err); actual != c.transient {
+			t.Errorf("%v: expected transient %t; actual %t", c.err, c.transient, actual)
+		}
+	}
+
+	deadline := &net.OpError{Op: "write", Err: os.ErrDeadlineExceeded}
+	if !Timeout(deadline) || Timeout(syscall.EPIPE) {
+		t.Error("Timeout misclassified")
+	}
+	if !Reset(&net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.ECONNRESET)}) || Reset(deadline) {
+		t.Error("Reset misclassified")
+	}
+}
+
+// TestRetryingConnDeadline checks a deadline the caller set isn't retried:
+// nothing moves it, so every attempt would fail the same way.
+func TestRetryingConnDeadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() { _ = server.Close() }()
+
+	m := new(Metrics)
+	c := NewRetryingConn(client, RetryWriter{MaxRetries: 50, Backoff: fast, Metrics: m})
+	defer func() { _ = c.Close() }()
+
+	_ = c.SetWriteDeadline(time.Now())
+	if _, err := c.Write([]byte("Hello world")); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected %v; actual %v", os.ErrDeadlineExceeded, err)
+	}
+	if s := m.Stats(); s.Attempts != 1 || s.Permanent != 1 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestRetryingConnAttemptTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() { _ = server.Close() }()
+
+	m := new(Metrics)
+	c := NewRetryingConn(client, RetryWriter{MaxRetries: 50, Backoff: fast, Metrics: m})
+	c.AttemptTimeout = 20 * time.Millisecond
+	defer func() { _ = c.Close() }()
+
+	// The peer stalls long enough for the first attempts to time out.
+	done := make(chan []byte)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		b, _ := io.ReadAll(io.LimitReader(server, 11))
+		done <- b
+	}()
+
+	_, err := c.Write([]byte("Hello world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := <-done; string(b) != "Hello world" {
+		t.Errorf("unexpected data %q", b)
+	}
+	if s := m.Stats(); s.Retries == 0 {
+		t.Errorf("expected retries; stats %+v", s)
+	}
+}
@@ -0,0 +1,190 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const defaultMaxRetries = 7
+
+// ErrRetriesExhausted is wrapped by the error returned when a write
+// still fails after the last retry.
+var ErrRetriesExhausted = errors.New("retry: retries exhausted")
+
+// Metrics counts retry activity. It's safe for concurrent use, and one
+// Metrics may be shared by many writers.
+type Metrics struct {
+	writes    atomic.Int64
+	attempts  atomic.Int64
+	retries   atomic.Int64
+	exhausted atomic.Int64
+	permanent atomic.Int64
+	cancelled atomic.Int64
+	resumed   atomic.Int64
+}
+
+// Stats is a snapshot of Metrics.
+type Stats struct {
+	Writes    int64 // Calls to Write
+	Attempts  int64 // Writes to the underlying writer, including retries
+	Retries   int64 // Attempts after the first
+	Exhausted int64 // Writes that failed after the last retry
+	Permanent int64 // Writes that failed with a non-transient error
+	Cancelled int64 // Writes abandoned because the context ended
+	Resumed   int64 // Bytes written by failed attempts, and so not resent
+}
+
+func (m *Metrics) Stats() Stats {
+	return Stats{
+		Writes:    m.writes.Load(),
+		Attempts:  m.attempts.Load(),
+		Retries:   m.retries.Load(),
+		Exhausted: m.exhausted.Load(),
+		Permanent: m.permanent.Load(),
+		Cancelled: m.cancelled.Load(),
+		Resumed:   m.resumed.Load(),
+	}
+}
+
+// RetryWriter retries writes to W that fail with transient errors. When
+// a write is partially done before failing, the retry resumes with the
+// unwritten bytes rather than sending the whole buffer again.
+type RetryWriter struct {
+	W io.Writer
+
+	// MaxRetries is how many times to retry after the first attempt. Zero
+	// turns retrying off; a negative value uses the default of 7.
+	MaxRetries int
+
+	Backoff  Backoff    // Delay between attempts
+	Classify Classifier // Defaults to Transient
+
+	// Metrics, if set, accumulates counters for every write.
+	Metrics *Metrics
+
+	// OnRetry, if set, is called before sleeping ahead of each retry.
+	OnRetry func(attempt int, err error, delay time.Duration)
+
+	// beforeAttempt lets RetryingConn refresh the write deadline.
+	beforeAttempt func()
+}
+
+func (w *RetryWriter) Write(p []byte) (int, error) {
+	return w.WriteContext(context.Background(), p)
+}
+
+// WriteContext writes p, retrying transient failures until it succeeds,
+// fails permanently, runs out of retries or ctx is done. It returns the
+// number of bytes of p written, across all attempts.
+func (w *RetryWriter) WriteContext(ctx context.Context, p []byte) (int, error) {
+	return w.write(ctx, p, false)
+}
+
+// write is WriteContext, also retrying timeouts by default if each
+// attempt gets a fresh deadline.
+func (w *RetryWriter) write(ctx context.Context, p []byte, timeouts bool) (int, error) {
+	m := w.Metrics
+	if m == nil {
+		m = new(Metrics) // Counted but discarded
+	}
+	m.writes.Add(1)
+
+	maxRetries := w.MaxRetries
+	if maxRetries < 0 {
+		maxRetries = defaultMaxRetries
+	}
+	classify := w.Classify
+	if classify == nil {
+		classify = Transient
+		if timeouts {
+			classify = func(err error) bool { return Transient(err) || Timeout(err) }
+		}
+	}
+
+	var written, resumed int
+
+	for attempt := 0; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			m.cancelled.Add(1)
+			return written, err
+		}
+		if attempt > 0 {
+			m.retries.Add(1)
+			m.resumed.Add(int64(written - resumed)) // Only what the last attempt wrote
+			resumed = written
+		}
+		m.attempts.Add(1)
+
+		if w.beforeAttempt != nil {
+			w.beforeAttempt()
+		}
+
+		n, err := w.W.Write(p[written:])
+		written += n
+		if err == nil && written == len(p) {
+			return written, nil
+		}
+		if err == nil {
+			err = io.ErrShortWrite // A misbehaving writer; try the rest again
+		}
+
+		if !classify(err) {
+			m.permanent.Add(1)
+			return written, err
+		}
+
+		if attempt >= maxRetries {
+			m.exhausted.Add(1)
+			return written, fmt.Errorf("%w after %d attempts: %w", ErrRetriesExhausted, attempt+1, err)
+		}
+
+		delay := w.Backoff.Delay(attempt)
+		if w.OnRetry != nil {
+			w.OnRetry(attempt+1, err, delay)
+		}
+
+		if sErr := sleep(ctx, delay); sErr != nil {
+			m.cancelled.Add(1)
+			return written, sErr
+		}
+	}
+}
+
+// RetryingConn is a net.Conn whose writes are retried by a RetryWriter.
+// Reads pass straight through.
+type RetryingConn struct {
+	net.Conn
+	RetryWriter
+
+	// AttemptTimeout, if set, gives each write attempt its own write
+	// deadline, so a stalled peer turns into a timeout, which the default
+	// Classifier then retries.
+	AttemptTimeout time.Duration
+}
+
+// NewRetryingConn wraps conn, retrying writes according to w. The W
+// field of w is replaced by conn.
+func NewRetryingConn(conn net.Conn, w RetryWriter) *RetryingConn {
+	c := &RetryingConn{Conn: conn, RetryWriter: w}
+	c.W = conn
+	c.beforeAttempt = func() {
+		if c.AttemptTimeout > 0 {
+			_ = c.Conn.SetWriteDeadline(time.Now().Add(c.AttemptTimeout))
+		}
+	}
+
+	return c
+}
+
+func (c *RetryingConn) Write(p []byte) (int, error) {
+	return c.WriteContext(context.Background(), p)
+}
+
+func (c *RetryingConn) WriteContext(ctx context.Context, p []byte) (int, error) {
+	return c.RetryWriter.write(ctx, p, c.AttemptTimeout > 0)
+}
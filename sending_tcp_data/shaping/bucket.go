@@ -0,0 +1,101 @@
+// Package shaping wraps net.Conn and net.Listener to throttle bandwidth
+// with token buckets and to inject fixed or jittered latency, so slow
+// links and slow clients can be reproduced on loopback.
+package shaping
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket that refills at a fixed number of bytes per
+// second up to its burst size. It's safe for concurrent use, so a single
+// Bucket can cap the combined rate of a group of connections.
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64 // Tokens (bytes) added per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket returns a full bucket that allows rate bytes per second
+// with bursts of up to burst bytes. A burst below 1 is set to rate/10,
+// or 1 if that's smaller. It panics if rate isn't positive; a bucket
+// that never refills would block its first caller forever.
+func NewBucket(rate, burst int) *Bucket {
+	if rate <= 0 {
+		panic("shaping: non-positive rate for NewBucket")
+	}
+	if burst < 1 {
+		burst = max(rate/10, 1)
+	}
+
+	return &Bucket{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Burst returns the bucket size in bytes.
+func (b *Bucket) Burst() int {
+	return int(b.burst)
+}
+
+// WaitN blocks until n bytes worth of tokens are available and takes
+// them. If ctx ends first, the tokens are returned and ctx.Err() is.
+// Requests larger than the burst are allowed; they simply wait longer.
+func (b *Bucket) WaitN(ctx context.Context, n int) error {
+	if n <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	// Take the tokens now, even if that leaves the bucket in debt. Later
+	// callers queue up behind us because they see the debt.
+	b.tokens -= float64(n)
+	wait := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		b.tokens += float64(n) // Give back what we didn't use
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Latency is a delay applied to every read or write on a connection.
+// Each operation waits Delay plus a uniformly random offset in
+// [-Jitter, +Jitter], but never less than zero.
+type Latency struct {
+	Delay  time.Duration
+	Jitter time.Duration
+}
+
+func (l Latency) next() time.Duration {
+	d := l.Delay
+	if l.Jitter > 0 {
+		d += time.Duration(rand.Int64N(int64(2*l.Jitter+1))) - l.Jitter
+	}
+
+	return max(d, 0)
+}
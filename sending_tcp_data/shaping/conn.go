@@ -0,0 +1,271 @@
+package shaping
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Config describes how to shape a connection. The zero value leaves the
+// connection untouched.
+type Config struct {
+	// ReadRate and WriteRate limit each connection to this many bytes
+	// per second. Zero means unlimited.
+	ReadRate  int
+	WriteRate int
+
+	// ReadGroup and WriteGroup are shared limits applied on top of the
+	// per-connection rates. Give the same Bucket to several connections
+	// to cap their combined throughput.
+	ReadGroup  *Bucket
+	WriteGroup *Bucket
+
+	// ReadLatency delays each Read after data arrives; WriteLatency
+	// delays each Write before data is sent.
+	ReadLatency  Latency
+	WriteLatency Latency
+}
+
+// Conn is a shaped net.Conn. Deadlines apply to time spent waiting on
+// rate limits and latency as well as to the underlying I/O.
+type Conn struct {
+	net.Conn
+
+	read  direction
+	write direction
+
+	mu            sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+// direction is the shaping applied to one side of a connection.
+type direction struct {
+	limit   *Bucket // Per-connection
+	group   *Bucket // Shared
+	latency Latency
+}
+
+// chunk returns the most bytes worth handling in one step, so a single
+// large Read or Write can't hog a shared bucket.
+func (d direction) chunk(n int) int {
+	for _, b := range []*Bucket{d.limit, d.group} {
+		if b != nil {
+			n = min(n, b.Burst())
+		}
+	}
+
+	return max(n, 1)
+}
+
+func (d direction) wait(ctx context.Context, n int) error {
+	for _, b := range []*Bucket{d.limit, d.group} {
+		if b == nil {
+			continue
+		}
+		if err := b.WaitN(ctx, n); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d direction) delay(ctx context.Context) error {
+	wait := d.latency.next()
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// NewConn wraps conn with the shaping described by cfg.
+func NewConn(conn net.Conn, cfg Config) *Conn {
+	c := &Conn{
+		Conn: conn,
+		read: direction{
+			group:   cfg.ReadGroup,
+			latency: cfg.ReadLatency,
+		},
+		write: direction{
+			group:   cfg.WriteGroup,
+			latency: cfg.WriteLatency,
+		},
+	}
+
+	if cfg.ReadRate > 0 {
+		c.read.limit = NewBucket(cfg.ReadRate, 0)
+	}
+	if cfg.WriteRate > 0 {
+		c.write.limit = NewBucket(cfg.WriteRate, 0)
+	}
+
+	return c
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	ctx, cancel := c.context(&c.readDeadline)
+	defer cancel()
+
+	n, err := c.Conn.Read(p[:c.read.chunk(len(p))])
+	if n == 0 {
+		return n, err
+	}
+
+	// Data has arrived; hold it back until the limits and latency allow.
+	// If the deadline passes meanwhile, the data is still returned along
+	// with the timeout.
+	wErr := c.read.wait(ctx, n)
+	if wErr == nil {
+		wErr = c.read.delay(ctx)
+	}
+	if wErr != nil && err == nil {
+		err = timeoutErr(wErr)
+	}
+
+	return n, err
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	ctx, cancel := c.context(&c.writeDeadline)
+	defer cancel()
+
+	if err := c.write.delay(ctx); err != nil {
+		return 0, timeoutErr(err)
+	}
+
+	var written int
+	for written < len(p) {
+		chunk := p[written:]
+		chunk = chunk[:c.write.chunk(len(chunk))]
+
+		if err := c.write.wait(ctx, len(chunk)); err != nil {
+			return written, timeoutErr(err)
+		}
+
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// CloseWrite half-closes the underlying connection if it supports it.
+func (c *Conn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+
+	return errors.New("shaping: underlying connection can't half-close")
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline, c.writeDeadline = t, t
+	c.mu.Unlock()
+
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	c.mu.Unlock()
+
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// context returns a context that ends at the given deadline, if set.
+func (c *Conn) context(deadline *time.Time) (context.Context, context.CancelFunc) {
+	c.mu.Lock()
+	t := *deadline
+	c.mu.Unlock()
+
+	if t.IsZero() {
+		return context.Background(), func() {}
+	}
+
+	return context.WithDeadline(context.Background(), t)
+}
+
+// timeoutErr converts an expired wait into the same error a deadline on
+// the underlying connection produces.
+func timeoutErr(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return os.ErrDeadlineExceeded
+	}
+
+	return err
+}
+
+// Listener shapes every connection it accepts.
+type Listener struct {
+	net.Listener
+	Config Config
+}
+
+// NewListener wraps l so each accepted connection is shaped by cfg.
+// Per-connection rates apply to each connection separately; group
+// buckets in cfg are shared by all of them.
+func NewListener(l net.Listener, cfg Config) *Listener {
+	return &Listener{Listener: l, Config: cfg}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewConn(conn, l.Config), nil
+}
+
+// ContextDialer dials with a context, like *net.Dialer, or a
+// *socks5.Dialer to shape traffic through a proxy.
+type ContextDialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Dialer shapes every connection it dials. Use it as the Dialer of an
+// httpproxy.Proxy or socks5.Server to shape traffic to destinations.
+type Dialer struct {
+	Dialer ContextDialer // Defaults to a net.Dialer
+	Config Config
+}
+
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := d.Dialer
+	if dialer == nil {
+		dialer = new(net.Dialer)
+	}
+
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewConn(conn, d.Config), nil
+}
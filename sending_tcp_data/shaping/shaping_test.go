@@ -0,0 +1,201 @@
+package shaping
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBucketRate(t *testing.T) {
+	b := NewBucket(100_000, 10_000) // 100 KB/s, 10 KB burst
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		err := b.WaitN(context.Background(), 10_000)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first 10 KB is the burst; the next 30 KB take 300ms.
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Fatalf("40 KB at 100 KB/s took only %s", elapsed)
+	}
+}
+
+func TestBucketCancel(t *testing.T) {
+	b := NewBucket(1000, 1000)
+	_ = b.WaitN(context.Background(), 1000) // Drain the burst
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := b.WaitN(ctx, 1000) // Would take a full second
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded; actual %v", err)
+	}
+}
+
+func TestBucketRateZero(t *testing.T) {
+	for _, rate := range []int{0, -1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected NewBucket(%d, 0) to panic", rate)
+				}
+			}()
+			NewBucket(rate, 0)
+		}()
+	}
+}
+
+func TestConnWriteRate(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+
+	payload := bytes.Repeat([]byte("x"), 20_000)
+	go func() {
+		defer func() { _ = server.Close() }()
+
+		shaped := NewConn(server, Config{WriteRate: 50_000}) // 5 KB burst
+		_, _ = shaped.Write(payload)
+	}()
+
+	start := time.Now()
+	b, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+
+	if !bytes.Equal(payload, b) {
+		t.Fatal("payload corrupted")
+	}
+
+	// 15 KB beyond the burst at 50 KB/s is 300ms.
+	if elapsed < 250*time.Millisecond {
+		t.Fatalf("20 KB at 50 KB/s took only %s", elapsed)
+	}
+}
+
+func TestConnGroupRate(t *testing.T) {
+	group := NewBucket(50_000, 5_000)
+	payload := bytes.Repeat([]byte("x"), 10_000)
+
+	var wg sync.WaitGroup
+	start := time.Now()
+
+	// Two connections sharing a 50 KB/s budget move 20 KB together.
+	for i := 0; i < 2; i++ {
+		client, server := net.Pipe()
+		shaped := NewConn(server, Config{WriteGroup: group})
+
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			defer func() { _ = shaped.Close() }()
+			_, _ = shaped.Write(payload)
+		}()
+		go func() {
+			defer wg.Done()
+			_, _ = io.Copy(io.Discard, client)
+		}()
+	}
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Fatalf("20 KB at a shared 50 KB/s took only %s", elapsed)
+	}
+}
+
+func TestConnLatency(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+
+	shaped := NewConn(server, Config{
+		WriteLatency: Latency{Delay: 100 * time.Millisecond, Jitter: 20 * time.Millisecond},
+	})
+	defer func() { _ = shaped.Close() }()
+
+	go func() {
+		for i := 0; i < 3; i++ {
+			_, _ = shaped.Write([]byte("ping"))
+		}
+	}()
+
+	buf := make([]byte, 4)
+	for i := 0; i < 3; i++ {
+		start := time.Now()
+		_, err := io.ReadFull(client, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+			t.Errorf("%d: ping arrived after %s; expected at least 80ms", i, elapsed)
+		}
+	}
+}
+
+func TestConnDeadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+
+	shaped := NewConn(server, Config{WriteRate: 10, WriteLatency: Latency{Delay: time.Second}})
+	defer func() { _ = shaped.Close() }()
+
+	go func() { _, _ = io.Copy(io.Discard, client) }()
+
+	err := shaped.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = shaped.Write([]byte("ping"))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded; actual %v", err)
+	}
+
+	var nErr net.Error
+	if !errors.As(err, &nErr) || !nErr.Timeout() {
+		t.Fatalf("expected a net.Error timeout; actual %v", err)
+	}
+}
+
+// TestSlowClient shapes an HTTP server's listener so every response
+// trickles out, the way a client on a slow link would see it.
+func TestSlowClient(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 16_000)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(body)
+		}))
+	ts.Listener = NewListener(ts.Listener, Config{WriteRate: 40_000})
+	ts.Start()
+	defer ts.Close()
+
+	start := time.Now()
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(b) != len(body) {
+		t.Fatalf("expected %d bytes; actual %d", len(body), len(b))
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Fatalf("16 KB at 40 KB/s took only %s", elapsed)
+	}
+}
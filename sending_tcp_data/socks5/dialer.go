@@ -0,0 +1,287 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// Dialer connects to addresses through a SOCKS5 proxy. It implements
+// ContextDialer, so it can be plugged into http.Transport.DialContext
+// or anywhere a *net.Dialer is used.
+type Dialer struct {
+	ProxyAddress string // host:port of the SOCKS5 server
+
+	// Username and Password are sent if the server asks for RFC 1929
+	// authentication.
+	Username string
+	Password string
+
+	// Forward is used to reach the proxy itself. Defaults to a net.Dialer.
+	Forward ContextDialer
+}
+
+func (d *Dialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to address through the proxy. Networks "tcp",
+// "tcp4" and "tcp6" use the CONNECT command; "udp", "udp4" and "udp6"
+// use UDP ASSOCIATE and return a connection that relays datagrams.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	var cmd byte
+
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		cmd = CmdConnect
+	case "udp", "udp4", "udp6":
+		cmd = CmdUDPAssociate
+	default:
+		return nil, fmt.Errorf("socks5: unsupported network %q", network)
+	}
+
+	forward := d.Forward
+	if forward == nil {
+		forward = new(net.Dialer)
+	}
+
+	conn, err := forward.DialContext(ctx, "tcp", d.ProxyAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	bound, err := d.handshake(ctx, conn, cmd, address)
+	if err != nil {
+		_ = conn.Close()
+		return nil, &net.OpError{Op: "socks5", Net: network, Addr: proxyAddr(d.ProxyAddress), Err: err}
+	}
+
+	if cmd == CmdConnect {
+		return conn, nil
+	}
+
+	uc, err := newUDPConn(conn, bound, address)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return uc, nil
+}
+
+// handshake authenticates and sends the request, honoring ctx for
+// both its deadline and its cancellation. It returns BND.ADDR:BND.PORT.
+func (d *Dialer) handshake(ctx context.Context, conn net.Conn, cmd byte, address string) (bound string, err error) {
+	deadline, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Unix(1, 0)) // Unblock any pending I/O
+		case <-done:
+		}
+	}()
+
+	defer func() {
+		close(done)
+		<-stopped // Make sure the goroutine can't touch the deadline anymore
+
+		// Report why the handshake was cut short. The conn's deadline is
+		// ctx's, and may fire a moment before ctx notices.
+		if err != nil && ctx.Err() != nil {
+			err = ctx.Err()
+		} else if hasDeadline && errors.Is(err, os.ErrDeadlineExceeded) {
+			err = context.DeadlineExceeded
+		}
+		_ = conn.SetDeadline(time.Time{})
+	}()
+
+	methods := []byte{Version, 1, MethodNoAuth}
+	if d.Username != "" {
+		methods = []byte{Version, 2, MethodNoAuth, MethodUserPass}
+	}
+	if _, err = conn.Write(methods); err != nil {
+		return "", err
+	}
+
+	var sel [2]byte
+	if _, err = io.ReadFull(conn, sel[:]); err != nil {
+		return "", err
+	}
+	if sel[0] != Version {
+		return "", ErrVersion
+	}
+
+	switch sel[1] {
+	case MethodNoAuth:
+	case MethodUserPass:
+		if err = d.authenticate(conn); err != nil {
+			return "", err
+		}
+	default:
+		return "", ErrNoAcceptable
+	}
+
+	dst := address
+	if cmd == CmdUDPAssociate {
+		dst = "0.0.0.0:0" // We don't know which source address we'll send from
+	}
+
+	req, err := appendAddr([]byte{Version, cmd, 0}, dst)
+	if err != nil {
+		return "", err
+	}
+	if _, err = conn.Write(req); err != nil {
+		return "", err
+	}
+
+	// Reply: VER REP RSV ATYP BND.ADDR BND.PORT
+	var rep [3]byte
+	if _, err = io.ReadFull(conn, rep[:]); err != nil {
+		return "", err
+	}
+	if rep[0] != Version {
+		return "", ErrVersion
+	}
+	if rep[1] != byte(ReplySucceeded) {
+		return "", ReplyCode(rep[1])
+	}
+
+	return readAddr(conn)
+}
+
+func (d *Dialer) authenticate(conn net.Conn) error {
+	if len(d.Username) > 255 || len(d.Password) > 255 {
+		return errors.New("socks5: username or password longer than 255 bytes")
+	}
+
+	b := []byte{userPassVersion, byte(len(d.Username))}
+	b = append(b, d.Username...)
+	b = append(b, byte(len(d.Password)))
+	b = append(b, d.Password...)
+
+	if _, err := conn.Write(b); err != nil {
+		return err
+	}
+
+	var status [2]byte
+	if _, err := io.ReadFull(conn, status[:]); err != nil {
+		return err
+	}
+	if status[1] != 0x00 {
+		return ErrAuthFailed
+	}
+
+	return nil
+}
+
+type proxyAddr string
+
+func (a proxyAddr) Network() string { return "tcp" }
+func (a proxyAddr) String() string  { return string(a) }
+
+// udpConn is a net.Conn that sends datagrams to a single destination
+// through a SOCKS5 UDP relay. The control connection is held open for
+// the life of the association.
+type udpConn struct {
+	pc    net.PacketConn
+	ctrl  net.Conn
+	relay *net.UDPAddr
+	dst   net.Addr
+	hdr   []byte // Relay header prepended to every datagram we send
+}
+
+func newUDPConn(ctrl net.Conn, bound, address string) (*udpConn, error) {
+	relay, err := net.ResolveUDPAddr("udp", bound)
+	if err != nil {
+		return nil, err
+	}
+	if relay.IP.IsUnspecified() {
+		// The server bound to all interfaces; use the address we reached it on.
+		host, _, err := net.SplitHostPort(ctrl.RemoteAddr().String())
+		if err != nil {
+			return nil, err
+		}
+		relay.IP = net.ParseIP(host)
+	}
+
+	dst, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	hdr, err := appendUDPHeader(nil, address)
+	if err != nil {
+		return nil, err
+	}
+
+	// Send from the same local IP as the control connection, since the
+	// server only accepts datagrams from the client's host.
+	host, _, err := net.SplitHostPort(ctrl.LocalAddr().String())
+	if err != nil {
+		return nil, err
+	}
+
+	pc, err := net.ListenPacket("udp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &udpConn{pc: pc, ctrl: ctrl, relay: relay, dst: dst, hdr: hdr}, nil
+}
+
+func (c *udpConn) Read(p []byte) (int, error) {
+	buf := make([]byte, len(p)+262) // Room for the largest relay header
+
+	for {
+		n, addr, err := c.pc.ReadFrom(buf)
+		if err != nil {
+			return 0, err
+		}
+		if addr.String() != c.relay.String() {
+			continue // Only the relay may send to us
+		}
+
+		_, payload, err := parseUDPHeader(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		return copy(p, payload), nil
+	}
+}
+
+func (c *udpConn) Write(p []byte) (int, error) {
+	b := append(append(make([]byte, 0, len(c.hdr)+len(p)), c.hdr...), p...)
+
+	if _, err := c.pc.WriteTo(b, c.relay); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (c *udpConn) Close() error {
+	err := c.pc.Close()
+	if cErr := c.ctrl.Close(); err == nil {
+		err = cErr
+	}
+
+	return err
+}
+
+func (c *udpConn) LocalAddr() net.Addr                { return c.pc.LocalAddr() }
+func (c *udpConn) RemoteAddr() net.Addr               { return c.dst }
+func (c *udpConn) SetDeadline(t time.Time) error      { return c.pc.SetDeadline(t) }
+func (c *udpConn) SetReadDeadline(t time.Time) error  { return c.pc.SetReadDeadline(t) }
+func (c *udpConn) SetWriteDeadline(t time.Time) error { return c.pc.SetWriteDeadline(t) }
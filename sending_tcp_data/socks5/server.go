@@ -0,0 +1,304 @@
+package socks5
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"syscall"
+	"time"
+
+	"networks/sending_tcp_data/relay"
+	"networks/tcp_data_streams/tcpinfo"
+)
+
+// Credentials validates RFC 1929 username/password pairs.
+type Credentials interface {
+	Valid(username, password string) bool
+}
+
+// StaticCredentials maps usernames to passwords.
+type StaticCredentials map[string]string
+
+// Valid compares against every pair in constant time, so how long it
+// takes doesn't give away how close a guess came.
+func (s StaticCredentials) Valid(username, password string) bool {
+	var match int
+	for u, p := range s {
+		match |= subtle.ConstantTimeCompare([]byte(u), []byte(username)) &
+			subtle.ConstantTimeCompare([]byte(p), []byte(password))
+	}
+
+	return match == 1
+}
+
+// Server is a SOCKS5 proxy server supporting the CONNECT and
+// UDP ASSOCIATE commands.
+type Server struct {
+	// Credentials, if set, requires clients to authenticate with a
+	// username and password. Otherwise clients need no authentication.
+	Credentials Credentials
+
+	// Dialer is used for outgoing CONNECT requests. Defaults to a
+	// net.Dialer.
+	Dialer ContextDialer
+
+	// Timeout limits how long a client may take to complete the
+	// handshake and how long the server waits to reach a destination.
+	Timeout time.Duration
+
+	// StatsInterval, if positive, logs the kernel's view of each
+	// tunnel's destination connection this often, and once more when the
+	// tunnel closes: round-trip time, congestion window, retransmissions
+	// and delivery rate. Linux only.
+	StatsInterval time.Duration
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = l.Close() }()
+
+	log.Printf("Listening on %s ...\n", l.Addr())
+
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l and handles each in its own goroutine.
+// It returns when l.Accept fails, for example because l was closed.
+func (s *Server) Serve(l net.Listener) error {
+	if l == nil {
+		return errors.New("nil listener")
+	}
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go s.handle(conn)
+	}
+}
+
+// dialer and timeout return the configured values or their defaults,
+// leaving s untouched, since it may serve several listeners at once.
+func (s *Server) dialer() ContextDialer {
+	if s.Dialer == nil {
+		return new(net.Dialer)
+	}
+
+	return s.Dialer
+}
+
+func (s *Server) timeout() time.Duration {
+	if s.Timeout == 0 {
+		return 30 * time.Second
+	}
+
+	return s.Timeout
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+
+	client := conn.RemoteAddr().String()
+
+	// The whole handshake must finish before the deadline.
+	_ = conn.SetDeadline(time.Now().Add(s.timeout()))
+
+	err := s.authenticate(conn)
+	if err != nil {
+		log.Printf("[%s] authentication: %v", client, err)
+		return
+	}
+
+	// Request: VER CMD RSV ATYP DST.ADDR DST.PORT
+	var hdr [3]byte
+	if _, err = io.ReadFull(conn, hdr[:]); err != nil {
+		log.Printf("[%s] reading request: %v", client, err)
+		return
+	}
+	if hdr[0] != Version {
+		log.Printf("[%s] %v", client, ErrVersion)
+		return
+	}
+	if hdr[2] != 0 {
+		_ = writeReply(conn, ReplyGeneralFailure, nil)
+		log.Printf("[%s] %v", client, ErrReserved)
+		return
+	}
+
+	dst, err := readAddr(conn)
+	if err != nil {
+		if errors.Is(err, ErrAddressType) {
+			_ = writeReply(conn, ReplyAddressNotSupported, nil)
+		}
+		log.Printf("[%s] reading destination: %v", client, err)
+		return
+	}
+
+	switch hdr[1] {
+	case CmdConnect:
+		s.connect(conn, dst)
+	case CmdUDPAssociate:
+		s.udpAssociate(conn, dst)
+	default:
+		_ = writeReply(conn, ReplyCommandNotSupported, nil)
+		log.Printf("[%s] unsupported command %#02x", client, hdr[1])
+	}
+}
+
+// authenticate negotiates the authentication method and, if required,
+// performs the username/password sub-negotiation.
+func (s *Server) authenticate(conn net.Conn) error {
+	// Method selection: VER NMETHODS METHODS
+	var hdr [2]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return err
+	}
+	if hdr[0] != Version {
+		return ErrVersion
+	}
+
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return err
+	}
+
+	want := MethodNoAuth
+	if s.Credentials != nil {
+		want = MethodUserPass
+	}
+
+	offered := false
+	for _, m := range methods {
+		if m == want {
+			offered = true
+			break
+		}
+	}
+	if !offered {
+		_, _ = conn.Write([]byte{Version, MethodNoAcceptable})
+		return ErrNoAcceptable
+	}
+
+	if _, err := conn.Write([]byte{Version, want}); err != nil {
+		return err
+	}
+	if want == MethodNoAuth {
+		return nil
+	}
+
+	// RFC 1929: VER ULEN UNAME PLEN PASSWD
+	var ver [1]byte
+	if _, err := io.ReadFull(conn, ver[:]); err != nil {
+		return err
+	}
+	if ver[0] != userPassVersion {
+		return ErrVersion
+	}
+
+	username, err := readString(conn)
+	if err != nil {
+		return err
+	}
+	password, err := readString(conn)
+	if err != nil {
+		return err
+	}
+
+	if !s.Credentials.Valid(username, password) {
+		_, _ = conn.Write([]byte{userPassVersion, 0x01}) // Any non-zero status is a failure
+		return ErrAuthFailed
+	}
+
+	_, err = conn.Write([]byte{userPassVersion, 0x00})
+	return err
+}
+
+func (s *Server) connect(conn net.Conn, dst string) {
+	client := conn.RemoteAddr().String()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout())
+	target, err := s.dialer().DialContext(ctx, "tcp", dst)
+	cancel()
+	if err != nil {
+		_ = writeReply(conn, replyFor(err), nil)
+		log.Printf("[%s] connect %s: %v", client, dst, err)
+		return
+	}
+	defer func() { _ = target.Close() }()
+
+	if err = writeReply(conn, ReplySucceeded, target.LocalAddr()); err != nil {
+		log.Printf("[%s] writing reply: %v", client, err)
+		return
+	}
+
+	_ = conn.SetDeadline(time.Time{}) // Handshake over; the tunnel has no deadline
+
+	if s.StatsInterval > 0 {
+		target = tcpinfo.Watch(target, s.StatsInterval, func(i *tcpinfo.Info) {
+			log.Printf("[%s] %s: %s", client, dst, i)
+		})
+	}
+
+	err = relay.Proxy(conn, target)
+	if err != nil {
+		log.Printf("[%s] relay to %s: %v", client, dst, err)
+	}
+}
+
+// writeReply writes VER REP RSV ATYP BND.ADDR BND.PORT. A nil bound
+// address is sent as 0.0.0.0:0.
+func writeReply(w io.Writer, rep ReplyCode, bound net.Addr) error {
+	addr := "0.0.0.0:0"
+	if bound != nil {
+		addr = bound.String()
+	}
+
+	b, err := appendAddr([]byte{Version, byte(rep), 0}, addr)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(b)
+	return err
+}
+
+// replyFor maps a dial error to the closest SOCKS reply code.
+func replyFor(err error) ReplyCode {
+	var nErr net.Error
+
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ReplyConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return ReplyNetworkUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &nErr) && nErr.Timeout():
+		return ReplyHostUnreachable
+	default:
+		return ReplyGeneralFailure
+	}
+}
+
+// readString reads a single length-prefixed string.
+func readString(r io.Reader) (string, error) {
+	var l [1]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return "", err
+	}
+
+	b := make([]byte, l[0])
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
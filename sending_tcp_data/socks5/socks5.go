@@ -0,0 +1,216 @@
+// Package socks5 implements a SOCKS version 5 proxy server (RFC 1928)
+// with username/password authentication (RFC 1929), and a client-side
+// dialer that tunnels TCP and UDP traffic through such a server.
+package socks5
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+const (
+	Version = 0x05
+
+	userPassVersion = 0x01 // RFC 1929 sub-negotiation version
+)
+
+// Authentication methods
+const (
+	MethodNoAuth       byte = 0x00
+	MethodUserPass     byte = 0x02
+	MethodNoAcceptable byte = 0xff
+)
+
+// Request commands
+const (
+	CmdConnect      byte = 0x01
+	CmdBind         byte = 0x02 // Not supported
+	CmdUDPAssociate byte = 0x03
+)
+
+// Address types
+const (
+	atypIPv4   byte = 0x01
+	atypDomain byte = 0x03
+	atypIPv6   byte = 0x04
+)
+
+// Reply codes
+const (
+	ReplySucceeded ReplyCode = iota
+	ReplyGeneralFailure
+	ReplyNotAllowed
+	ReplyNetworkUnreachable
+	ReplyHostUnreachable
+	ReplyConnectionRefused
+	ReplyTTLExpired
+	ReplyCommandNotSupported
+	ReplyAddressNotSupported
+)
+
+// ReplyCode is the REP field of a server reply. A non-zero ReplyCode
+// is returned by the Dialer as an error.
+type ReplyCode byte
+
+func (c ReplyCode) Error() string {
+	switch c {
+	case ReplySucceeded:
+		return "succeeded"
+	case ReplyGeneralFailure:
+		return "general SOCKS server failure"
+	case ReplyNotAllowed:
+		return "connection not allowed by ruleset"
+	case ReplyNetworkUnreachable:
+		return "network unreachable"
+	case ReplyHostUnreachable:
+		return "host unreachable"
+	case ReplyConnectionRefused:
+		return "connection refused"
+	case ReplyTTLExpired:
+		return "TTL expired"
+	case ReplyCommandNotSupported:
+		return "command not supported"
+	case ReplyAddressNotSupported:
+		return "address type not supported"
+	default:
+		return fmt.Sprintf("unknown reply code %#02x", byte(c))
+	}
+}
+
+var (
+	ErrVersion        = errors.New("socks5: unsupported version")
+	ErrNoAcceptable   = errors.New("socks5: no acceptable authentication method")
+	ErrAuthFailed     = errors.New("socks5: authentication failed")
+	ErrAddressType    = errors.New("socks5: unsupported address type")
+	ErrDomainTooLong  = errors.New("socks5: domain name longer than 255 bytes")
+	ErrFragmentedUDP  = errors.New("socks5: fragmented UDP datagrams not supported")
+	ErrShortUDPHeader = errors.New("socks5: short UDP request header")
+	ErrReserved       = errors.New("socks5: non-zero reserved field")
+)
+
+// ContextDialer dials with a context. *net.Dialer and *socks5.Dialer
+// both satisfy it, and its DialContext method has the signature that
+// http.Transport.DialContext expects.
+type ContextDialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// appendAddr encodes a "host:port" string as ATYP, DST.ADDR and DST.PORT.
+func appendAddr(b []byte, address string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("socks5: invalid port %q", portStr)
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(b, atypIPv4)
+			b = append(b, ip4...)
+		} else {
+			b = append(b, atypIPv6)
+			b = append(b, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, ErrDomainTooLong
+		}
+		b = append(b, atypDomain, byte(len(host)))
+		b = append(b, host...)
+	}
+
+	return binary.BigEndian.AppendUint16(b, uint16(port)), nil
+}
+
+// readAddr reads ATYP, DST.ADDR and DST.PORT and returns them as "host:port".
+func readAddr(r io.Reader) (string, error) {
+	var atyp [1]byte
+	if _, err := io.ReadFull(r, atyp[:]); err != nil {
+		return "", err
+	}
+
+	var host string
+
+	switch atyp[0] {
+	case atypIPv4, atypIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if atyp[0] == atypIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case atypDomain:
+		var l [1]byte
+		if _, err := io.ReadFull(r, l[:]); err != nil {
+			return "", err
+		}
+		name := make([]byte, l[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", ErrAddressType
+	}
+
+	var port uint16
+	if err := binary.Read(r, binary.BigEndian, &port); err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), nil
+}
+
+// parseUDPHeader strips the RSV, FRAG, ATYP, DST.ADDR and DST.PORT fields
+// from a UDP relay datagram and returns the address and the payload.
+func parseUDPHeader(p []byte) (string, []byte, error) {
+	if len(p) < 4 {
+		return "", nil, ErrShortUDPHeader
+	}
+	if p[0] != 0 || p[1] != 0 {
+		return "", nil, ErrReserved
+	}
+	if p[2] != 0 {
+		return "", nil, ErrFragmentedUDP
+	}
+
+	r := &countingReader{b: p[3:]}
+	addr, err := readAddr(r)
+	if err != nil {
+		return "", nil, ErrShortUDPHeader
+	}
+
+	return addr, p[3+r.n:], nil
+}
+
+// appendUDPHeader prepends the UDP relay header for address to b.
+func appendUDPHeader(b []byte, address string) ([]byte, error) {
+	return appendAddr(append(b, 0, 0, 0), address) // RSV, RSV, FRAG
+}
+
+// countingReader reads from a byte slice and tracks how much was consumed.
+type countingReader struct {
+	b []byte
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	if r.n >= len(r.b) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.b[r.n:])
+	r.n += n
+
+	return n, nil
+}
@@ -0,0 +1,427 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startServer runs s on a loopback listener and returns its address.
+func startServer(t *testing.T, s *Server) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() { _ = s.Serve(l) }()
+
+	return l.Addr().String()
+}
+
+// echoTCP starts a TCP server that echoes everything it reads.
+func echoTCP(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer func() { _ = c.Close() }()
+				_, _ = io.Copy(c, c)
+			}(conn)
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func TestConnect(t *testing.T) {
+	proxy := startServer(t, new(Server))
+	target := echoTCP(t)
+
+	d := &Dialer{ProxyAddress: proxy}
+	conn, err := d.Dial("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	msg := []byte("ping")
+	_, err = conn.Write(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, len(msg))
+	_, err = io.ReadFull(conn, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg, buf) {
+		t.Errorf("expected reply %q; actual reply %q", msg, buf)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe to log to from several goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+// TestConnectStats checks a tunnel logs the target connection's TCP_INFO
+// as it closes.
+func TestConnectStats(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("TCP_INFO is Linux only")
+	}
+
+	logs := new(syncBuffer)
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+
+	proxy := startServer(t, &Server{StatsInterval: time.Hour})
+	target := echoTCP(t)
+
+	conn, err := (&Dialer{ProxyAddress: proxy}).Dial("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Write([]byte("ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.ReadFull(conn, make([]byte, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+
+	for start := time.Now(); !strings.Contains(logs.String(), "rtt="); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 2*time.Second {
+			t.Fatalf("no final snapshot logged; log:\n%s", logs)
+		}
+	}
+}
+
+func TestConnectUserPass(t *testing.T) {
+	proxy := startServer(t, &Server{
+		Credentials: StaticCredentials{"ci": "hunter2"},
+	})
+	target := echoTCP(t)
+
+	testCases := []struct {
+		username string
+		password string
+		err      error
+	}{
+		{"ci", "hunter2", nil},
+		{"ci", "wrong", ErrAuthFailed},
+		{"", "", ErrNoAcceptable},
+	}
+
+	for i, c := range testCases {
+		d := &Dialer{ProxyAddress: proxy, Username: c.username, Password: c.password}
+		conn, err := d.Dial("tcp", target)
+		if conn != nil {
+			_ = conn.Close()
+		}
+
+		if c.err == nil && err != nil {
+			t.Errorf("%d: unexpected error: %v", i, err)
+		}
+		if c.err != nil && !errors.Is(err, c.err) {
+			t.Errorf("%d: expected %v; actual %v", i, c.err, err)
+		}
+	}
+}
+
+func TestStaticCredentials(t *testing.T) {
+	creds := StaticCredentials{"ci": "hunter2", "ops": "swordfish"}
+
+	testCases := []struct {
+		username, password string
+		valid              bool
+	}{
+		{"ci", "hunter2", true},
+		{"ops", "swordfish", true},
+		{"ci", "swordfish", false},
+		{"ci", "hunter", false},
+		{"nobody", "hunter2", false},
+		{"", "", false},
+	}
+
+	for _, c := range testCases {
+		if actual := creds.Valid(c.username, c.password); actual != c.valid {
+			t.Errorf("%s/%s: expected %t; actual %t", c.username, c.password, c.valid, actual)
+		}
+	}
+}
+
+// TestReservedField checks the server rejects requests whose RSV byte
+// isn't zero, as RFC 1928 requires.
+func TestReservedField(t *testing.T) {
+	proxy := startServer(t, new(Server))
+
+	conn, err := net.Dial("tcp", proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte{Version, 1, MethodNoAuth})
+	if err != nil {
+		t.Fatal(err)
+	}
+	method := make([]byte, 2)
+	if _, err = io.ReadFull(conn, method); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := appendAddr([]byte{Version, CmdConnect, 0x01}, "127.0.0.1:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Write(req); err != nil {
+		t.Fatal(err)
+	}
+
+	reply := make([]byte, 2)
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+	if rep := ReplyCode(reply[1]); rep != ReplyGeneralFailure {
+		t.Errorf("expected %v; actual %v", ReplyGeneralFailure, rep)
+	}
+
+	_, _, err = parseUDPHeader([]byte{0, 1, 0, atypIPv4, 127, 0, 0, 1, 0, 80})
+	if !errors.Is(err, ErrReserved) {
+		t.Errorf("expected %v; actual %v", ErrReserved, err)
+	}
+}
+
+func TestConnectRefused(t *testing.T) {
+	proxy := startServer(t, new(Server))
+
+	// Grab a free port and release it so nothing is listening there.
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := l.Addr().String()
+	_ = l.Close()
+
+	d := &Dialer{ProxyAddress: proxy}
+	_, err = d.Dial("tcp", target)
+	if !errors.Is(err, ReplyConnectionRefused) {
+		t.Fatalf("expected %v; actual %v", ReplyConnectionRefused, err)
+	}
+}
+
+func TestDialContextCancel(t *testing.T) {
+	// A "proxy" that accepts but never answers the handshake.
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	d := &Dialer{ProxyAddress: l.Addr().String()}
+	_, err = d.DialContext(ctx, "tcp", "127.0.0.1:80")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded; actual %v", err)
+	}
+}
+
+func TestUDPAssociate(t *testing.T) {
+	proxy := startServer(t, &Server{
+		Credentials: StaticCredentials{"ci": "hunter2"},
+	})
+
+	// UDP echo server
+	s, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Close() }()
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := s.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = s.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	d := &Dialer{ProxyAddress: proxy, Username: "ci", Password: "hunter2"}
+	conn, err := d.Dial("udp", s.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	buf := make([]byte, 1024)
+	for i := 0; i < 3; i++ {
+		msg := []byte(fmt.Sprintf("ping %d", i))
+		_, err = conn.Write(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(msg, buf[:n]) {
+			t.Errorf("expected reply %q; actual reply %q", msg, buf[:n])
+		}
+	}
+}
+
+// TestUDPStranger checks the relay drops datagrams from hosts the client
+// hasn't sent to.
+func TestUDPStranger(t *testing.T) {
+	proxy := startServer(t, new(Server))
+
+	s, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Close() }()
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := s.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = s.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	d := &Dialer{ProxyAddress: proxy}
+	conn, err := d.Dial("udp", s.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	buf := make([]byte, 1024)
+	if _, err = conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// The relay now knows the client's address. Anyone could send to it.
+	stranger, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = stranger.Close() }()
+
+	if _, err = stranger.WriteTo([]byte("spam"), conn.(*udpConn).relay); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if _, err = conn.Write([]byte("pong")); err != nil {
+		t.Fatal(err)
+	}
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "pong" {
+		t.Errorf("expected reply %q; actual reply %q", "pong", buf[:n])
+	}
+}
+
+func TestHTTPClientThroughProxy(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("Hello, proxied client!"))
+		}))
+	defer ts.Close()
+
+	proxy := startServer(t, new(Server))
+	d := &Dialer{ProxyAddress: proxy}
+
+	client := &http.Client{
+		Transport: &http.Transport{DialContext: d.DialContext},
+		Timeout:   5 * time.Second,
+	}
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := string(b); actual != "Hello, proxied client!" {
+		t.Errorf("unexpected response body %q", actual)
+	}
+}
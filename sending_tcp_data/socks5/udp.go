@@ -0,0 +1,134 @@
+package socks5
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// udpAssociate binds a UDP relay for the client and forwards datagrams
+// until the client closes its control connection.
+func (s *Server) udpAssociate(conn net.Conn, expected string) {
+	client := conn.RemoteAddr().String()
+
+	// Bind the relay to the same local IP the client reached us on, so
+	// the address we send back is one the client can use.
+	host, _, err := net.SplitHostPort(conn.LocalAddr().String())
+	if err != nil {
+		_ = writeReply(conn, ReplyGeneralFailure, nil)
+		return
+	}
+
+	pc, err := net.ListenPacket("udp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		_ = writeReply(conn, ReplyGeneralFailure, nil)
+		log.Printf("[%s] udp associate: %v", client, err)
+		return
+	}
+	defer func() { _ = pc.Close() }()
+
+	if err = writeReply(conn, ReplySucceeded, pc.LocalAddr()); err != nil {
+		log.Printf("[%s] writing reply: %v", client, err)
+		return
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+
+	// The association lasts exactly as long as the control connection.
+	go func() {
+		_, _ = io.Copy(io.Discard, conn)
+		_ = pc.Close()
+	}()
+
+	clientHost, _, _ := net.SplitHostPort(client)
+	_, port, _ := net.SplitHostPort(expected)
+	if port == "0" {
+		port = "" // The client didn't know its source port yet
+	}
+
+	relayUDP(pc, clientHost, port)
+}
+
+// maxPeers bounds how many destinations one association remembers.
+const maxPeers = 1024
+
+// relayUDP forwards datagrams between the client and remote hosts.
+// Datagrams from the client carry a relay header naming the destination;
+// datagrams from a destination the client has sent to are replies and get
+// a header naming their source before they're passed to the client.
+// Datagrams from anyone else are dropped, so the relay's port can't be
+// used to reach the client unasked.
+func relayUDP(pc net.PacketConn, clientHost, clientPort string) {
+	var clientAddr net.Addr
+	peers := make(map[netip.AddrPort]struct{})
+	buf := make([]byte, 64*1024)
+
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		if clientAddr == nil && isClient(addr, clientHost, clientPort) {
+			clientAddr = addr // The first datagram from the client fixes its address
+		}
+
+		if clientAddr == nil {
+			continue // Nobody to relay replies to yet
+		}
+
+		if addr.String() == clientAddr.String() {
+			dst, payload, err := parseUDPHeader(buf[:n])
+			if err != nil {
+				continue // Drop malformed and fragmented datagrams
+			}
+
+			dstAddr, err := net.ResolveUDPAddr("udp", dst)
+			if err != nil {
+				continue
+			}
+
+			peer := addrPort(dstAddr)
+			if _, ok := peers[peer]; !ok && len(peers) >= maxPeers {
+				clear(peers) // Replies from earlier destinations are dropped
+			}
+			peers[peer] = struct{}{}
+
+			_, _ = pc.WriteTo(payload, dstAddr)
+			continue
+		}
+
+		if _, ok := peers[addrPort(addr)]; !ok {
+			continue // Not a reply to anything the client sent
+		}
+
+		b, err := appendUDPHeader(make([]byte, 0, n+22), addr.String())
+		if err != nil {
+			continue
+		}
+		_, _ = pc.WriteTo(append(b, buf[:n]...), clientAddr)
+	}
+}
+
+func isClient(addr net.Addr, host, port string) bool {
+	h, p, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return false
+	}
+
+	return h == host && (port == "" || p == port)
+}
+
+// addrPort keys a UDP address, so an IPv4-mapped IPv6 source matches the
+// IPv4 destination it was sent to.
+func addrPort(addr net.Addr) netip.AddrPort {
+	ua, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return netip.AddrPort{}
+	}
+	ap := ua.AddrPort()
+
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+}
@@ -0,0 +1,321 @@
+// Package deadline manages connection deadlines for servers, so handlers
+// needn't call SetDeadline by hand. It enforces an idle timeout, a total
+// session lifetime and a minimum transfer rate, the last of which stops
+// slow-loris clients that trickle in just enough bytes to stay idle-free.
+package deadline
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const defaultRateWindow = 10 * time.Second
+
+// Config sets the limits. Zero values disable them.
+type Config struct {
+	// IdleTimeout is how long each Read may wait for data. Every read
+	// that returns pushes the deadline forward again.
+	IdleTimeout time.Duration
+
+	// MaxLifetime limits the whole session, reads and writes alike.
+	MaxLifetime time.Duration
+
+	// MinRate is the fewest bytes per second, read and written together,
+	// a connection must average over each RateWindow.
+	MinRate int
+
+	// RateWindow is how often the rate is checked, and the grace period a
+	// new connection gets. Defaults to 10s.
+	RateWindow time.Duration
+}
+
+// IdleTimeoutError is returned when a Read waits longer than IdleTimeout.
+type IdleTimeoutError struct {
+	Idle time.Duration
+}
+
+func (e *IdleTimeoutError) Error() string {
+	return fmt.Sprintf("deadline: idle for %s", e.Idle)
+}
+
+// LifetimeError is returned once the session outlives MaxLifetime.
+type LifetimeError struct {
+	Lifetime time.Duration
+}
+
+func (e *LifetimeError) Error() string {
+	return fmt.Sprintf("deadline: session exceeded lifetime of %s", e.Lifetime)
+}
+
+// MinRateError is returned when the connection transfers too slowly.
+type MinRateError struct {
+	Rate    int // Bytes per second over the last window
+	MinRate int
+}
+
+func (e *MinRateError) Error() string {
+	return fmt.Sprintf("deadline: transfer rate %d B/s below minimum of %d B/s", e.Rate, e.MinRate)
+}
+
+// All three are timeouts, and match os.ErrDeadlineExceeded with errors.Is.
+
+func (e *IdleTimeoutError) Timeout() bool   { return true }
+func (e *IdleTimeoutError) Temporary() bool { return false }
+func (e *IdleTimeoutError) Unwrap() error   { return os.ErrDeadlineExceeded }
+
+func (e *LifetimeError) Timeout() bool   { return true }
+func (e *LifetimeError) Temporary() bool { return false }
+func (e *LifetimeError) Unwrap() error   { return os.ErrDeadlineExceeded }
+
+func (e *MinRateError) Timeout() bool   { return true }
+func (e *MinRateError) Temporary() bool { return false }
+func (e *MinRateError) Unwrap() error   { return os.ErrDeadlineExceeded }
+
+var (
+	_ net.Error = (*IdleTimeoutError)(nil)
+	_ net.Error = (*LifetimeError)(nil)
+	_ net.Error = (*MinRateError)(nil)
+)
+
+// Listener applies Config to every connection it accepts.
+type Listener struct {
+	net.Listener
+	Config
+}
+
+func NewListener(l net.Listener, cfg Config) *Listener {
+	return &Listener{Listener: l, Config: cfg}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewConn(conn, l.Config), nil
+}
+
+// which deadline a Read or Write ran into.
+type limit int
+
+const (
+	limitCaller limit = iota
+	limitIdle
+	limitLifetime
+	limitRate
+)
+
+// Conn enforces Config on a connection. The caller's own deadlines still
+// apply and produce ordinary timeouts. Once a limit is hit, every later
+// Read and Write returns the same error.
+type Conn struct {
+	net.Conn
+	cfg   Config
+	start time.Time
+
+	mu            sync.Mutex
+	readDeadline  time.Time // The caller's
+	writeDeadline time.Time
+	effective     [2]time.Time // Set on the conn for reads and writes
+	windowStart   time.Time
+	windowBytes   int
+	err           error
+}
+
+func NewConn(conn net.Conn, cfg Config) *Conn {
+	if cfg.RateWindow <= 0 {
+		cfg.RateWindow = defaultRateWindow
+	}
+	now := time.Now()
+
+	return &Conn{Conn: conn, cfg: cfg, start: now, windowStart: now}
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	for {
+		lim, err := c.deadline(true)
+		if err != nil {
+			return 0, err
+		}
+
+		n, err := c.Conn.Read(p)
+		if err = c.account(n, err, lim, true); err != errRateOK || n > 0 {
+			if err == errRateOK {
+				err = nil
+			}
+			return n, err
+		}
+		// The rate check passed with nothing read; keep waiting.
+	}
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	var written int
+
+	for {
+		lim, err := c.deadline(false)
+		if err != nil {
+			return written, err
+		}
+
+		n, err := c.Conn.Write(p[written:])
+		written += n
+		if err = c.account(n, err, lim, false); err != errRateOK {
+			return written, err
+		}
+		// The rate check passed partway through; write the rest.
+	}
+}
+
+// errRateOK means a Read or Write woke up for a rate check that passed.
+var errRateOK = errors.New("rate ok")
+
+// deadline sets the earliest of the caller's deadline and the limits on
+// the conn, and reports which one it is.
+func (c *Conn) deadline(read bool) (limit, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	deadline, lim := c.writeDeadline, limitCaller
+	if read {
+		deadline = c.readDeadline
+	}
+	earlier := func(t time.Time, l limit) {
+		if deadline.IsZero() || t.Before(deadline) {
+			deadline, lim = t, l
+		}
+	}
+
+	if read && c.cfg.IdleTimeout > 0 {
+		earlier(time.Now().Add(c.cfg.IdleTimeout), limitIdle)
+	}
+	if c.cfg.MaxLifetime > 0 {
+		earlier(c.start.Add(c.cfg.MaxLifetime), limitLifetime)
+	}
+	if c.cfg.MinRate > 0 {
+		earlier(c.windowStart.Add(c.cfg.RateWindow), limitRate)
+	}
+
+	if read {
+		c.effective[0] = deadline
+		_ = c.Conn.SetReadDeadline(deadline)
+	} else {
+		c.effective[1] = deadline
+		_ = c.Conn.SetWriteDeadline(deadline)
+	}
+
+	return lim, nil
+}
+
+// account counts n transferred bytes and turns a timeout into the error
+// for the limit that caused it. It returns errRateOK if the timeout was
+// only a rate check that passed.
+func (c *Conn) account(n int, err error, lim limit, read bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.windowBytes += n
+
+	timeout := errors.Is(err, os.ErrDeadlineExceeded)
+	if err != nil && !timeout {
+		return err // EOF and the like
+	}
+
+	// Check the rate whenever a window has passed, whether or not the
+	// transfer timed out.
+	if rErr := c.checkRate(time.Now()); rErr != nil {
+		c.err = rErr
+		return rErr
+	}
+
+	if !timeout {
+		return nil
+	}
+
+	caller := c.writeDeadline
+	if read {
+		caller = c.readDeadline
+	}
+	if !caller.IsZero() && !time.Now().Before(caller) {
+		return err // The caller's deadline, even if it moved mid-call
+	}
+
+	switch lim {
+	case limitIdle:
+		c.err = &IdleTimeoutError{Idle: c.cfg.IdleTimeout}
+	case limitLifetime:
+		c.err = &LifetimeError{Lifetime: c.cfg.MaxLifetime}
+	case limitRate:
+		return errRateOK // checkRate started a new window
+	default:
+		return err // The caller's deadline
+	}
+
+	return c.err
+}
+
+// checkRate ends the current window if it's over, failing if too few
+// bytes went through. c.mu must be held.
+func (c *Conn) checkRate(now time.Time) error {
+	if c.cfg.MinRate <= 0 {
+		return nil
+	}
+
+	elapsed := now.Sub(c.windowStart)
+	if elapsed < c.cfg.RateWindow {
+		return nil
+	}
+
+	rate := int(float64(c.windowBytes) / elapsed.Seconds())
+	if rate < c.cfg.MinRate {
+		return &MinRateError{Rate: rate, MinRate: c.cfg.MinRate}
+	}
+
+	c.windowStart, c.windowBytes = now, 0
+
+	return nil
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	_ = c.SetReadDeadline(t)
+
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the caller's deadline, which is combined with the
+// limits on each Read. A deadline earlier than the one in effect applies
+// to a pending Read right away; a later one, from the next Read on.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.readDeadline = t
+	if t.IsZero() || !t.Before(c.effective[0]) && !c.effective[0].IsZero() {
+		return nil
+	}
+	c.effective[0] = t
+
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeDeadline = t
+	if t.IsZero() || !t.Before(c.effective[1]) && !c.effective[1].IsZero() {
+		return nil
+	}
+	c.effective[1] = t
+
+	return c.Conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,164 @@
+package deadline
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// session accepts one connection through a deadline Listener, runs
+// client against it, and reads on the server side until an error. It
+// returns how long that took, how many bytes were read, and the error.
+func session(t *testing.T, cfg Config, client func(net.Conn)) (time.Duration, int, error) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dl := NewListener(l, cfg)
+	defer func() { _ = dl.Close() }()
+
+	done := make(chan struct{})
+	defer func() { <-done }()
+
+	go func() {
+		defer close(done)
+
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		client(conn)
+	}()
+
+	conn, err := dl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	start := time.Now()
+	n, err := io.Copy(io.Discard, conn)
+	if err == nil {
+		err = io.EOF // io.Copy hides it
+	}
+
+	return time.Since(start), int(n), err
+}
+
+// trickle sends one byte every interval, until stop.
+func trickle(interval time.Duration, stop time.Duration) func(net.Conn) {
+	return func(conn net.Conn) {
+		end := time.Now().Add(stop)
+		for time.Now().Before(end) {
+			if _, err := conn.Write([]byte("x")); err != nil {
+				return
+			}
+			time.Sleep(interval)
+		}
+		time.Sleep(200 * time.Millisecond) // Go quiet, without hanging up
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	cfg := Config{IdleTimeout: 50 * time.Millisecond}
+	elapsed, n, err := session(t, cfg, trickle(20*time.Millisecond, 150*time.Millisecond))
+
+	var idleErr *IdleTimeoutError
+	if !errors.As(err, &idleErr) {
+		t.Fatalf("expected an idle timeout; actual %v", err)
+	}
+	if n < 5 || elapsed < 150*time.Millisecond {
+		t.Errorf("timed out too early: %d bytes in %s", n, elapsed)
+	}
+
+	var nErr net.Error
+	if !errors.As(err, &nErr) || !nErr.Timeout() || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected a net.Error timeout; actual %#v", err)
+	}
+}
+
+func TestLifetime(t *testing.T) {
+	cfg := Config{IdleTimeout: 50 * time.Millisecond, MaxLifetime: 100 * time.Millisecond}
+	elapsed, _, err := session(t, cfg, trickle(10*time.Millisecond, time.Second))
+
+	var lifeErr *LifetimeError
+	if !errors.As(err, &lifeErr) {
+		t.Fatalf("expected a lifetime error; actual %v", err)
+	}
+	if elapsed < 90*time.Millisecond || elapsed > 500*time.Millisecond {
+		t.Errorf("expected the session to end after about 100ms; actual %s", elapsed)
+	}
+}
+
+func TestMinRateStopsSlowLoris(t *testing.T) {
+	// Fast enough to never go idle, far too slow to matter.
+	cfg := Config{IdleTimeout: time.Second, MinRate: 1000, RateWindow: 100 * time.Millisecond}
+	elapsed, _, err := session(t, cfg, trickle(20*time.Millisecond, time.Second))
+
+	var rateErr *MinRateError
+	if !errors.As(err, &rateErr) {
+		t.Fatalf("expected a rate error; actual %v", err)
+	}
+	if rateErr.Rate >= rateErr.MinRate || elapsed > 500*time.Millisecond {
+		t.Errorf("unexpected rate error %v after %s", rateErr, elapsed)
+	}
+}
+
+func TestMinRateAllowsFastClient(t *testing.T) {
+	cfg := Config{IdleTimeout: time.Second, MinRate: 1000, RateWindow: 50 * time.Millisecond}
+	chunk := bytes.Repeat([]byte("x"), 1024)
+
+	_, n, err := session(t, cfg, func(conn net.Conn) {
+		for range 30 {
+			if _, err := conn.Write(chunk); err != nil {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+	if err != io.EOF {
+		t.Fatalf("expected the client to finish; actual %v", err)
+	}
+	if n != 30*len(chunk) {
+		t.Errorf("expected %d bytes; actual %d", 30*len(chunk), n)
+	}
+}
+
+func TestCallerDeadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+
+	c := NewConn(server, Config{IdleTimeout: time.Second})
+	defer func() { _ = c.Close() }()
+
+	err := c.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.Read(make([]byte, 1))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected a timeout; actual %v", err)
+	}
+	var idleErr *IdleTimeoutError
+	if errors.As(err, &idleErr) {
+		t.Fatal("caller's deadline reported as an idle timeout")
+	}
+
+	// The caller's timeout doesn't stick, unlike the limits.
+	_ = c.SetReadDeadline(time.Time{})
+	go func() { _, _ = client.Write([]byte("x")) }()
+
+	if _, err = c.Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,246 @@
+// Package eyeballs dials hosts with several addresses the Happy Eyeballs
+// way (RFC 8305): IPv6 and IPv4 candidates are interleaved and tried in
+// staggered, overlapping attempts, and the first connection wins.
+package eyeballs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const defaultAttemptDelay = 250 * time.Millisecond // RFC 8305's recommendation
+
+// Resolver looks up a host's addresses. *net.Resolver implements it.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// Attempt describes one connection attempt, for tracing.
+type Attempt struct {
+	Address string        // The host:port dialed
+	Latency time.Duration // Time until the attempt connected or failed
+	Err     error         // Nil for the winner; context.Canceled for losers
+}
+
+// Dialer races connection attempts to every address of a host.
+type Dialer struct {
+	// Resolver defaults to net.DefaultResolver.
+	Resolver Resolver
+
+	// AttemptDelay is how long to wait on one attempt before starting the
+	// next in parallel. A failed attempt starts the next one right away.
+	// Defaults to 250ms.
+	AttemptDelay time.Duration
+
+	// DialFunc makes each attempt. It defaults to a net.Dialer's
+	// DialContext.
+	DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+	// Trace, if set, is called as each attempt finishes. Attempts run
+	// concurrently, and losers may report after DialContext returns.
+	Trace func(Attempt)
+}
+
+func (d *Dialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to address, a host:port, on network "tcp", "tcp4"
+// or "tcp6". Its result is the first attempt to connect; the rest are
+// canceled, and closed if they connect anyway.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := d.resolve(ctx, network, host)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+
+	candidates := make([]string, len(addrs))
+	for i, ip := range Interleave(addrs) {
+		candidates[i] = net.JoinHostPort(ip.String(), port)
+	}
+
+	return d.race(ctx, network, candidates)
+}
+
+func (d *Dialer) resolve(ctx context.Context, network, host string) ([]net.IPAddr, error) {
+	var addrs []net.IPAddr
+
+	if ip, zone, ok := parseIP(host); ok {
+		addrs = []net.IPAddr{{IP: ip, Zone: zone}} // No lookup for literals
+	} else {
+		r := d.Resolver
+		if r == nil {
+			r = net.DefaultResolver
+		}
+
+		var err error
+		addrs, err = r.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	filtered := addrs[:0:0]
+	for _, a := range addrs {
+		switch v4 := a.IP.To4() != nil; network {
+		case "tcp4":
+			if !v4 {
+				continue
+			}
+		case "tcp6":
+			if v4 {
+				continue
+			}
+		case "tcp":
+		default:
+			return nil, net.UnknownNetworkError(network)
+		}
+		filtered = append(filtered, a)
+	}
+
+	if len(filtered) == 0 {
+		return nil, fmt.Errorf("eyeballs: no %s addresses for %q", network, host)
+	}
+
+	return filtered, nil
+}
+
+// parseIP parses an IP literal, which may have a zone.
+func parseIP(host string) (net.IP, string, bool) {
+	host, zone, _ := strings.Cut(host, "%")
+	ip := net.ParseIP(host)
+
+	return ip, zone, ip != nil
+}
+
+// Interleave orders addresses for dialing: IPv6 first, then alternating
+// between families, keeping the resolver's order within each family.
+func Interleave(addrs []net.IPAddr) []net.IPAddr {
+	var v6, v4 []net.IPAddr
+	for _, a := range addrs {
+		if a.IP.To4() != nil {
+			v4 = append(v4, a)
+		} else {
+			v6 = append(v6, a)
+		}
+	}
+
+	out := make([]net.IPAddr, 0, len(addrs))
+	for len(v6) > 0 || len(v4) > 0 {
+		if len(v6) > 0 {
+			out = append(out, v6[0])
+			v6 = v6[1:]
+		}
+		if len(v4) > 0 {
+			out = append(out, v4[0])
+			v4 = v4[1:]
+		}
+	}
+
+	return out
+}
+
+type result struct {
+	conn net.Conn
+	err  error
+}
+
+// race starts an attempt per candidate, each AttemptDelay after the
+// previous one or as soon as the previous one fails, and returns the
+// first connection.
+func (d *Dialer) race(ctx context.Context, network string, candidates []string) (net.Conn, error) {
+	delay := d.AttemptDelay
+	if delay <= 0 {
+		delay = defaultAttemptDelay
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan result, len(candidates)) // Never blocks an attempt
+
+	var next, pending int
+	start := func() {
+		go d.attempt(ctx, network, candidates[next], results)
+		next++
+		pending++
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	start()
+
+	var errs []error
+	for pending > 0 {
+		var stagger <-chan time.Time
+		if next < len(candidates) && ctx.Err() == nil {
+			stagger = timer.C
+		}
+
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				cancel()
+				go closeLosers(results, pending)
+				return r.conn, nil
+			}
+			errs = append(errs, r.err)
+
+			if next < len(candidates) && ctx.Err() == nil {
+				start() // Don't wait out the delay after a failure
+				timer.Reset(delay)
+			}
+		case <-stagger:
+			start()
+			timer.Reset(delay)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err // Canceled by the caller
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+func (d *Dialer) attempt(ctx context.Context, network, address string, results chan<- result) {
+	dial := d.DialFunc
+	if dial == nil {
+		var nd net.Dialer
+		dial = nd.DialContext
+	}
+
+	start := time.Now()
+	conn, err := dial(ctx, network, address)
+	if err == nil && ctx.Err() != nil {
+		_ = conn.Close() // Connected, but another attempt already won
+		conn, err = nil, ctx.Err()
+	}
+
+	if d.Trace != nil {
+		d.Trace(Attempt{Address: address, Latency: time.Since(start), Err: err})
+	}
+
+	results <- result{conn: conn, err: err}
+}
+
+// closeLosers collects the attempts still in flight after a winner, and
+// closes any that connected before they noticed the cancellation.
+func closeLosers(results <-chan result, pending int) {
+	for ; pending > 0; pending-- {
+		if r := <-results; r.conn != nil {
+			_ = r.conn.Close()
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package eyeballs
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type fakeResolver map[string][]net.IPAddr
+
+func (f fakeResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	addrs, ok := f[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	return addrs, nil
+}
+
+func ips(s ...string) []net.IPAddr {
+	addrs := make([]net.IPAddr, len(s))
+	for i, a := range s {
+		addrs[i] = net.IPAddr{IP: net.ParseIP(a)}
+	}
+
+	return addrs
+}
+
+// tracer collects attempts, which may be reported concurrently.
+type tracer struct {
+	mu       sync.Mutex
+	attempts []Attempt
+}
+
+func (tr *tracer) trace(a Attempt) {
+	tr.mu.Lock()
+	tr.attempts = append(tr.attempts, a)
+	tr.mu.Unlock()
+}
+
+func (tr *tracer) get() []Attempt {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	return append([]Attempt(nil), tr.attempts...)
+}
+
+func listen(t *testing.T, network, address string) string {
+	t.Helper()
+
+	l, err := net.Listen(network, address)
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	return port
+}
+
+func TestInterleave(t *testing.T) {
+	addrs := ips("10.0.0.1", "10.0.0.2", "2001:db8::1", "10.0.0.3", "2001:db8::2")
+	expected := []string{"2001:db8::1", "10.0.0.1", "2001:db8::2", "10.0.0.2", "10.0.0.3"}
+
+	for i, a := range Interleave(addrs) {
+		if a.IP.String() != expected[i] {
+			t.Errorf("%d: expected %s; actual %s", i, expected[i], a.IP)
+		}
+	}
+}
+
+func TestDialPrefersIPv6(t *testing.T) {
+	// Both families listen on the same port, so either could win.
+	port := listen(t, "tcp6", "[::1]:0")
+	listen(t, "tcp4", "127.0.0.1:"+port)
+
+	tr := new(tracer)
+	d := &Dialer{
+		Resolver: fakeResolver{"dual.test": ips("127.0.0.1", "::1")},
+		Trace:    tr.trace,
+	}
+
+	conn, err := d.Dial("tcp", net.JoinHostPort("dual.test", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+
+	if addr := conn.RemoteAddr().(*net.TCPAddr); addr.IP.To4() != nil {
+		t.Errorf("expected an IPv6 connection; actual %s", addr)
+	}
+	if a := tr.get(); len(a) != 1 || a[0].Err != nil {
+		t.Errorf("expected a single successful attempt; actual %+v", a)
+	}
+}
+
+func TestDialFallsBackAfterDelay(t *testing.T) {
+	port := listen(t, "tcp4", "127.0.0.1:0")
+
+	// The IPv6 address is a black hole: its attempt hangs until canceled.
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, _, _ := net.SplitHostPort(address)
+		if host == "::1" {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		var d net.Dialer
+		return d.DialContext(ctx, network, address)
+	}
+
+	tr := new(tracer)
+	d := &Dialer{
+		Resolver:     fakeResolver{"dual.test": ips("::1", "127.0.0.1")},
+		AttemptDelay: 50 * time.Millisecond,
+		DialFunc:     dial,
+		Trace:        tr.trace,
+	}
+
+	start := time.Now()
+	conn, err := d.Dial("tcp", net.JoinHostPort("dual.test", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+
+	if elapsed := time.Since(start); elapsed < d.AttemptDelay || elapsed > time.Second {
+		t.Errorf("expected a fallback after %s; took %s", d.AttemptDelay, elapsed)
+	}
+
+	// The hung IPv6 attempt is canceled and reports so.
+	deadline := time.Now().Add(time.Second)
+	for len(tr.get()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	attempts := tr.get()
+	if len(attempts) != 2 {
+		t.Fatalf("expected two attempts; actual %+v", attempts)
+	}
+	for _, a := range attempts {
+		host, _, _ := net.SplitHostPort(a.Address)
+		switch host {
+		case "127.0.0.1":
+			if a.Err != nil {
+				t.Errorf("expected the IPv4 attempt to win; actual %v", a.Err)
+			}
+		case "::1":
+			if !errors.Is(a.Err, context.Canceled) {
+				t.Errorf("expected the IPv6 attempt canceled; actual %v", a.Err)
+			}
+		}
+	}
+}
+
+func TestDialFailureStartsNextAttempt(t *testing.T) {
+	port := listen(t, "tcp4", "127.0.0.1:0")
+
+	// Nothing listens on ::1 at this port, so the first attempt is refused
+	// straight away and the second shouldn't wait out the delay.
+	d := &Dialer{
+		Resolver:     fakeResolver{"dual.test": ips("::1", "127.0.0.1")},
+		AttemptDelay: 5 * time.Second,
+	}
+
+	start := time.Now()
+	conn, err := d.Dial("tcp", net.JoinHostPort("dual.test", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("waited %s after a refused attempt", elapsed)
+	}
+}
+
+func TestDialAllFail(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	_ = l.Close() // Nobody listens here anymore
+
+	tr := new(tracer)
+	d := &Dialer{
+		Resolver: fakeResolver{"down.test": ips("127.0.0.1", "127.0.0.2")},
+		Trace:    tr.trace,
+	}
+
+	_, err = d.Dial("tcp", net.JoinHostPort("down.test", port))
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Fatalf("expected connection refused; actual %v", err)
+	}
+	if a := tr.get(); len(a) != 2 {
+		t.Errorf("expected two attempts; actual %+v", a)
+	}
+}
+
+func TestDialContextCancel(t *testing.T) {
+	d := &Dialer{
+		Resolver: fakeResolver{"slow.test": ips("::1", "127.0.0.1")},
+		DialFunc: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := d.DialContext(ctx, "tcp", "slow.test:80")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v; actual %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestDialUnknownHost(t *testing.T) {
+	d := &Dialer{Resolver: fakeResolver{}}
+
+	_, err := d.Dial("tcp", "nowhere.test:80")
+
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Fatalf("expected a not-found DNS error; actual %v", err)
+	}
+}
@@ -0,0 +1,393 @@
+// Package heartbeat keeps a TCP connection's liveness in check. Both ends
+// exchange ping and pong frames alongside application data, and a peer
+// that goes quiet for too many beats is declared dead.
+//
+// Unlike Pinger, which only writes "ping" on a timer, a heartbeat Conn
+// answers its peer's pings, extends its read deadline whenever any
+// traffic arrives, and tells you when the peer is gone. Pings carry
+// timestamps, so each side measures round-trip time and jitter as well.
+package heartbeat
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	frameData byte = iota + 1
+	framePing
+	framePong
+)
+
+const (
+	headerSize = 5       // 1-byte type | 4-byte payload length
+	MaxPayload = 1 << 16 // Larger writes are split into several data frames
+
+	defaultInterval  = 1 * time.Second
+	defaultMaxMissed = 3
+)
+
+var (
+	// ErrPeerDead is returned once the peer has been silent for MaxMissed
+	// intervals, or stopped reading our pings.
+	ErrPeerDead = errors.New("heartbeat: peer missed too many beats")
+
+	ErrFrameTooLarge = errors.New("heartbeat: frame exceeds maximum payload")
+
+	// ErrFrameCut is why the Conn fails when the caller's write deadline
+	// cuts a data frame short. The peer can't find the next frame after
+	// it, so the stream is no use anymore.
+	ErrFrameCut = errors.New("heartbeat: frame cut short")
+)
+
+// Config tunes the heartbeat. The zero value pings every second and gives
+// up on the peer after three silent seconds.
+type Config struct {
+	Interval     time.Duration // Time between pings
+	MaxMissed    int           // Silent intervals before the peer is dead
+	WriteTimeout time.Duration // Deadline for each ping; defaults to Interval
+
+	// OnDead, if set, is called once when the peer is lost, with the
+	// reason. It runs on an internal goroutine, so it shouldn't block.
+	OnDead func(err error)
+}
+
+// Conn is a net.Conn that runs a heartbeat with its peer, which must be
+// a heartbeat Conn as well. Reads and writes carry application data only;
+// pings and pongs are handled internally.
+//
+// When the peer is lost, Dead is closed, the underlying connection is
+// closed, and Read and Write return the reason once buffered data is
+// consumed.
+type Conn struct {
+	net.Conn
+	cfg Config
+
+	wmu   sync.Mutex  // Serializes frames on the wire
+	pongs chan []byte // Pong payloads waiting to be sent
+
+	start time.Time // Ping timestamps are offsets from here
+	smu   sync.Mutex
+	rtt   estimator
+
+	rmu     sync.Mutex // Guards pending
+	data    chan []byte
+	pending []byte
+
+	mu                  sync.Mutex
+	readDeadline        time.Time
+	readDeadlineChanged chan struct{}
+	writeDeadline       time.Time
+	err                 error
+
+	dead      chan struct{}
+	deadOnce  sync.Once
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// New starts a heartbeat over conn. Don't use conn directly afterward.
+func New(conn net.Conn, cfg Config) *Conn {
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultInterval
+	}
+	if cfg.MaxMissed <= 0 {
+		cfg.MaxMissed = defaultMaxMissed
+	}
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = cfg.Interval
+	}
+
+	c := &Conn{
+		Conn:                conn,
+		cfg:                 cfg,
+		start:               time.Now(),
+		pongs:               make(chan []byte, 1),
+		data:                make(chan []byte, 16),
+		readDeadlineChanged: make(chan struct{}),
+		dead:                make(chan struct{}),
+		closed:              make(chan struct{}),
+	}
+
+	go c.readLoop()
+	go c.pingLoop()
+
+	return c
+}
+
+// Dead is closed when the peer is lost.
+func (c *Conn) Dead() <-chan struct{} { return c.dead }
+
+// Err returns why the peer was lost, or nil while it's alive. It's
+// ErrPeerDead when the peer went silent and io.EOF when it hung up.
+func (c *Conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	for len(c.pending) == 0 {
+		b, err := c.next()
+		if err != nil {
+			return 0, err
+		}
+		c.pending = b
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+
+	return n, nil
+}
+
+// next waits for the next data payload, honoring the read deadline.
+func (c *Conn) next() ([]byte, error) {
+	for {
+		c.mu.Lock()
+		deadline, changed := c.readDeadline, c.readDeadlineChanged
+		c.mu.Unlock()
+
+		b, retry, err := c.wait(deadline, changed)
+		if !retry {
+			return b, err
+		}
+	}
+}
+
+// wait reports retry when the read deadline changed while waiting.
+func (c *Conn) wait(deadline time.Time, changed <-chan struct{}) ([]byte, bool, error) {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case b, ok := <-c.data:
+		if !ok {
+			err := c.Err() // Set before the read loop closes data
+			if err == nil {
+				err = net.ErrClosed
+			}
+			return nil, false, err
+		}
+		return b, false, nil
+	case <-timeout:
+		return nil, false, os.ErrDeadlineExceeded
+	case <-changed: // Start over with the new deadline
+		return nil, true, nil
+	case <-c.closed:
+		return nil, false, net.ErrClosed
+	}
+}
+
+// Write sends p as one or more data frames.
+func (c *Conn) Write(p []byte) (int, error) {
+	var written int
+
+	for len(p) > 0 {
+		if err := c.Err(); err != nil {
+			return written, err
+		}
+
+		chunk := p[:min(len(p), MaxPayload)]
+		if n, err := c.writeFrame(frameData, chunk); err != nil {
+			if n > 0 {
+				c.fail(fmt.Errorf("%w: %v", ErrFrameCut, err))
+			}
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+
+	return written, nil
+}
+
+// writeFrame writes a frame in a single call so frames never interleave,
+// and returns how much of it, header included, made it out. Control frames
+// get their own write deadline, after which the caller's deadline is
+// restored.
+func (c *Conn) writeFrame(typ byte, payload []byte) (int, error) {
+	b := make([]byte, headerSize, headerSize+len(payload))
+	b[0] = typ
+	binary.BigEndian.PutUint32(b[1:], uint32(len(payload)))
+	b = append(b, payload...)
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if typ != frameData {
+		_ = c.Conn.SetWriteDeadline(time.Now().Add(c.cfg.WriteTimeout))
+		defer func() {
+			c.mu.Lock()
+			_ = c.Conn.SetWriteDeadline(c.writeDeadline)
+			c.mu.Unlock()
+		}()
+	}
+
+	return c.Conn.Write(b)
+}
+
+// readLoop reads every frame from the peer. Before each read it pushes
+// the read deadline out by MaxMissed intervals, so any traffic at all
+// keeps the peer alive, and silence kills it.
+func (c *Conn) readLoop() {
+	defer close(c.data)
+
+	silence := c.cfg.Interval * time.Duration(c.cfg.MaxMissed)
+	r := bufio.NewReader(c.Conn)
+	hdr := make([]byte, headerSize)
+
+	for {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(silence))
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			c.fail(err)
+			return
+		}
+
+		size := binary.BigEndian.Uint32(hdr[1:])
+		if size > MaxPayload {
+			c.fail(ErrFrameTooLarge)
+			return
+		}
+
+		payload := make([]byte, size)
+		_ = c.Conn.SetReadDeadline(time.Now().Add(silence))
+		if _, err := io.ReadFull(r, payload); err != nil {
+			c.fail(err)
+			return
+		}
+
+		switch hdr[0] {
+		case frameData:
+			// Waiting on the application doesn't count against the peer,
+			// since the deadline is reset before the next read.
+			select {
+			case c.data <- payload:
+			case <-c.closed:
+				return
+			}
+		case framePing:
+			select {
+			case c.pongs <- payload: // The ping loop answers it
+			default: // A pong is already queued, which proves we're alive
+			}
+		case framePong: // Its arrival already extended the deadline
+			if len(payload) == timestampSize {
+				c.sample(payload)
+			}
+		default:
+			c.fail(fmt.Errorf("heartbeat: unknown frame type %d", hdr[0]))
+			return
+		}
+	}
+}
+
+// pingLoop follows the Pinger pattern: a timer sends a ping every
+// interval. Pings go out even while data flows, since every pong is
+// another RTT sample.
+func (c *Conn) pingLoop() {
+	timer := time.NewTimer(c.cfg.Interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-c.dead:
+			return
+		case payload := <-c.pongs:
+			if _, err := c.writeFrame(framePong, payload); err != nil {
+				c.fail(err)
+				return
+			}
+		case <-timer.C:
+			if _, err := c.writeFrame(framePing, c.timestamp()); err != nil {
+				c.fail(err)
+				return
+			}
+			timer.Reset(c.cfg.Interval)
+		}
+	}
+}
+
+// fail declares the peer dead, unless we closed the connection ourselves.
+func (c *Conn) fail(err error) {
+	select {
+	case <-c.closed:
+		return
+	default:
+	}
+
+	var nErr net.Error
+	if errors.As(err, &nErr) && nErr.Timeout() {
+		err = ErrPeerDead // Our read or ping deadline ran out
+	}
+
+	c.deadOnce.Do(func() {
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+
+		close(c.dead)
+		_ = c.Conn.Close()
+
+		if c.cfg.OnDead != nil {
+			c.cfg.OnDead(err)
+		}
+	})
+}
+
+// Close stops the heartbeat and closes the connection. It doesn't fire
+// OnDead.
+func (c *Conn) Close() error {
+	err := net.ErrClosed
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = c.Conn.Close()
+	})
+
+	return err
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	_ = c.SetReadDeadline(t)
+
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline applies to Read only; the heartbeat manages the
+// underlying connection's read deadline itself.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.readDeadline = t
+	close(c.readDeadlineChanged) // Wake a blocked Read
+	c.readDeadlineChanged = make(chan struct{})
+
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeDeadline = t
+
+	return c.Conn.SetWriteDeadline(t)
+}
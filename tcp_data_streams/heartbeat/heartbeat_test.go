@@ -0,0 +1,270 @@
+package heartbeat
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"networks/sending_tcp_data/shaping"
+)
+
+var fast = Config{Interval: 20 * time.Millisecond, MaxMissed: 3}
+
+func pair(t *testing.T, cfg Config) (*Conn, *Conn) {
+	t.Helper()
+
+	client, server := net.Pipe()
+	a, b := New(client, cfg), New(server, cfg)
+	t.Cleanup(func() {
+		_ = a.Close()
+		_ = b.Close()
+	})
+
+	return a, b
+}
+
+func TestHeartbeatData(t *testing.T) {
+	a, b := pair(t, fast)
+
+	// Larger than one frame, so it's split and reassembled.
+	payload := bytes.Repeat([]byte("ping pong "), MaxPayload/5)
+
+	go func() {
+		_, _ = a.Write(payload)
+	}()
+
+	actual := make([]byte, len(payload))
+	_, err := io.ReadFull(b, actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(payload, actual) {
+		t.Error("payload corrupted in transit")
+	}
+}
+
+func TestHeartbeatIdleConnStaysAlive(t *testing.T) {
+	a, b := pair(t, fast)
+
+	// Many times the silence limit passes with no application data.
+	time.Sleep(10 * fast.Interval * time.Duration(fast.MaxMissed))
+
+	for _, c := range []*Conn{a, b} {
+		select {
+		case <-c.Dead():
+			t.Fatalf("idle peer declared dead: %v", c.Err())
+		default:
+		}
+	}
+
+	go func() { _, _ = a.Write([]byte("still here")) }()
+
+	buf := make([]byte, 32)
+	n, err := b.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := string(buf[:n]); actual != "still here" {
+		t.Errorf("unexpected data %q", actual)
+	}
+}
+
+func TestHeartbeatSilentPeer(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() { _ = server.Close() }()
+
+	// The peer drains our pings but never says anything back.
+	go func() { _, _ = io.Copy(io.Discard, server) }()
+
+	reasons := make(chan error, 1)
+	cfg := fast
+	cfg.OnDead = func(err error) { reasons <- err }
+
+	start := time.Now()
+	c := New(client, cfg)
+	defer func() { _ = c.Close() }()
+
+	select {
+	case <-c.Dead():
+	case <-time.After(time.Second):
+		t.Fatal("silent peer never declared dead")
+	}
+
+	silence := cfg.Interval * time.Duration(cfg.MaxMissed)
+	if elapsed := time.Since(start); elapsed < silence {
+		t.Errorf("peer declared dead after %s; expected at least %s", elapsed, silence)
+	}
+	if err := <-reasons; err != ErrPeerDead {
+		t.Errorf("expected %v; actual %v", ErrPeerDead, err)
+	}
+
+	_, err := c.Read(make([]byte, 1))
+	if err != ErrPeerDead {
+		t.Errorf("expected Read to return %v; actual %v", ErrPeerDead, err)
+	}
+	_, err = c.Write([]byte("hello?"))
+	if err != ErrPeerDead {
+		t.Errorf("expected Write to return %v; actual %v", ErrPeerDead, err)
+	}
+}
+
+func TestHeartbeatStalledPeer(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() { _ = server.Close() }()
+
+	// The peer doesn't even read, so our first ping can't be delivered.
+	c := New(client, fast)
+	defer func() { _ = c.Close() }()
+
+	select {
+	case <-c.Dead():
+	case <-time.After(time.Second):
+		t.Fatal("stalled peer never declared dead")
+	}
+	if err := c.Err(); err != ErrPeerDead {
+		t.Errorf("expected %v; actual %v", ErrPeerDead, err)
+	}
+}
+
+// TestHeartbeatFrameCut checks a data frame cut short by the caller's
+// write deadline fails the Conn, since the peer can no longer tell where
+// the next frame starts.
+func TestHeartbeatFrameCut(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() { _ = server.Close() }()
+
+	c := New(client, Config{Interval: time.Minute})
+	defer func() { _ = c.Close() }()
+
+	go func() {
+		_, _ = io.ReadFull(server, make([]byte, headerSize+1)) // Then stall
+	}()
+
+	_ = c.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err := c.Write([]byte("hello"))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected %v; actual %v", os.ErrDeadlineExceeded, err)
+	}
+
+	select {
+	case <-c.Dead():
+	default:
+		t.Fatal("Conn still usable after a partial frame")
+	}
+	if err = c.Err(); !errors.Is(err, ErrFrameCut) {
+		t.Errorf("expected %v; actual %v", ErrFrameCut, err)
+	}
+}
+
+func TestHeartbeatPeerHangsUp(t *testing.T) {
+	a, b := pair(t, fast)
+
+	go func() {
+		_, _ = a.Write([]byte("bye"))
+		_ = a.Close()
+	}()
+
+	// Data sent before the hang-up is still delivered.
+	buf := make([]byte, 3)
+	_, err := io.ReadFull(b, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = b.Read(buf)
+	if err != io.EOF {
+		t.Errorf("expected %v; actual %v", io.EOF, err)
+	}
+	<-b.Dead()
+}
+
+func TestHeartbeatReadDeadline(t *testing.T) {
+	a, _ := pair(t, fast)
+
+	err := a.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = a.Read(make([]byte, 1))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected %v; actual %v", os.ErrDeadlineExceeded, err)
+	}
+
+	// The read deadline belongs to the application; the heartbeat is fine.
+	select {
+	case <-a.Dead():
+		t.Fatalf("read deadline killed the heartbeat: %v", a.Err())
+	default:
+	}
+}
+
+func TestHeartbeatRTT(t *testing.T) {
+	client, server := net.Pipe()
+
+	// Every frame from the server, pongs included, takes 20ms to send.
+	delayed := shaping.NewConn(server, shaping.Config{
+		WriteLatency: shaping.Latency{Delay: 20 * time.Millisecond},
+	})
+
+	cfg := fast
+	cfg.WriteTimeout = time.Second // Longer than the delay
+
+	a, b := New(client, cfg), New(delayed, cfg)
+	defer func() {
+		_ = a.Close()
+		_ = b.Close()
+	}()
+
+	time.Sleep(10 * cfg.Interval)
+
+	if err := a.Err(); err != nil {
+		t.Fatal(err)
+	}
+	s := a.Stats()
+	if s.Samples < 3 {
+		t.Fatalf("expected several samples; actual %+v", s)
+	}
+	if s.RTT < 20*time.Millisecond || s.SRTT < 20*time.Millisecond {
+		t.Errorf("RTT doesn't include the delay: %+v", s)
+	}
+	if s.RTO < s.SRTT {
+		t.Errorf("RTO below SRTT: %+v", s)
+	}
+}
+
+func TestEstimator(t *testing.T) {
+	var e estimator
+
+	if s := e.stats(); s != (Stats{}) {
+		t.Fatalf("expected zero stats; actual %+v", s)
+	}
+
+	e.update(100 * time.Millisecond)
+	s := e.stats()
+	if s.SRTT != 100*time.Millisecond || s.RTTVar != 50*time.Millisecond ||
+		s.RTO != 300*time.Millisecond || s.Jitter != 0 {
+		t.Errorf("unexpected stats after first sample %+v", s)
+	}
+
+	e.update(180 * time.Millisecond)
+	s = e.stats()
+	for _, c := range []struct {
+		name             string
+		expected, actual time.Duration
+	}{
+		{"RTT", 180 * time.Millisecond, s.RTT},
+		{"SRTT", 110 * time.Millisecond, s.SRTT},       // 7/8*100 + 1/8*180
+		{"RTTVar", 57500 * time.Microsecond, s.RTTVar}, // 3/4*50 + 1/4*80
+		{"Jitter", 5 * time.Millisecond, s.Jitter},     // 80/16
+		{"RTO", 340 * time.Millisecond, s.RTO},
+	} {
+		if c.actual != c.expected {
+			t.Errorf("%s: expected %s; actual %s", c.name, c.expected, c.actual)
+		}
+	}
+}
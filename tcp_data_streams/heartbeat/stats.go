@@ -0,0 +1,101 @@
+package heartbeat
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// Each ping carries the sender's clock as an offset from when its Conn
+// started, and the peer echoes it back in the pong. Only the sender ever
+// interprets the timestamp, so the two clocks needn't agree.
+const timestampSize = 8
+
+// Stats describes the round-trip times measured from ping/pong exchanges.
+type Stats struct {
+	Samples int           // Pongs received
+	RTT     time.Duration // Latest round-trip time
+	SRTT    time.Duration // Smoothed round-trip time
+	RTTVar  time.Duration // Round-trip time variation
+	Jitter  time.Duration // Mean deviation between consecutive RTTs
+
+	// RTO is a retransmission-style timeout: SRTT + 4*RTTVar. Unlike
+	// TCP's, it has no one second floor, so it suits adaptive timeouts on
+	// fast links.
+	RTO time.Duration
+}
+
+// Stats returns the current round-trip measurements. They're all zero
+// until the first pong arrives.
+func (c *Conn) Stats() Stats {
+	c.smu.Lock()
+	defer c.smu.Unlock()
+
+	return c.rtt.stats()
+}
+
+func (c *Conn) timestamp() []byte {
+	b := make([]byte, timestampSize)
+	binary.BigEndian.PutUint64(b, uint64(time.Since(c.start)))
+
+	return b
+}
+
+func (c *Conn) sample(pong []byte) {
+	sent := time.Duration(binary.BigEndian.Uint64(pong))
+	r := time.Since(c.start) - sent
+	if r < 0 {
+		return // Not a timestamp we sent
+	}
+
+	c.smu.Lock()
+	c.rtt.update(r)
+	c.smu.Unlock()
+}
+
+// estimator smooths RTT samples the way TCP does (RFC 6298), and tracks
+// jitter the way RTP does (RFC 3550), using consecutive RTTs in place of
+// transit times.
+type estimator struct {
+	samples int
+	rtt     time.Duration
+	srtt    time.Duration
+	rttvar  time.Duration
+	jitter  time.Duration
+}
+
+func (e *estimator) update(r time.Duration) {
+	if e.samples == 0 {
+		e.srtt = r
+		e.rttvar = r / 2
+	} else {
+		e.rttvar = (3*e.rttvar + abs(e.srtt-r)) / 4 // beta = 1/4
+		e.srtt = (7*e.srtt + r) / 8                 // alpha = 1/8
+		e.jitter += (abs(r-e.rtt) - e.jitter) / 16
+	}
+
+	e.rtt = r
+	e.samples++
+}
+
+func (e *estimator) stats() Stats {
+	if e.samples == 0 {
+		return Stats{}
+	}
+
+	return Stats{
+		Samples: e.samples,
+		RTT:     e.rtt,
+		SRTT:    e.srtt,
+		RTTVar:  e.rttvar,
+		Jitter:  e.jitter,
+		RTO:     e.srtt + 4*e.rttvar,
+	}
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+
+	return d
+}
@@ -0,0 +1,404 @@
+// Package pool reuses TCP connections across requests, keeping a set of
+// idle connections per address, so RPC clients don't pay for a dial and
+// handshake on every call.
+package pool
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultMaxIdle            = 2 // Same as http.Transport per host
+	defaultHealthCheckTimeout = time.Millisecond
+)
+
+// ErrClosed is returned by Get once the pool is closed.
+var ErrClosed = errors.New("pool: closed")
+
+// Pool is a set of connections keyed by address. Its zero value is ready
+// to use, dialing TCP with default limits. Configure it before first use.
+type Pool struct {
+	// Dial opens new connections. It defaults to a net.Dialer dialing TCP.
+	Dial func(ctx context.Context, address string) (net.Conn, error)
+
+	MaxIdle int // Idle connections kept per address; defaults to 2
+	MaxOpen int // Connections per address, idle or in use; zero means no limit
+
+	IdleTimeout time.Duration // Close connections idle this long; zero means never
+	MaxLifetime time.Duration // Close connections this old; zero means never
+
+	// HealthCheckTimeout bounds the read each idle connection gets on
+	// checkout, if it isn't a socket that can be checked without one.
+	// Defaults to 1ms.
+	HealthCheckTimeout time.Duration
+
+	mu     sync.Mutex
+	hosts  map[string]*host
+	closed bool
+	stats  Stats
+	once   sync.Once
+	stop   chan struct{}
+}
+
+type host struct {
+	idle   []*Conn       // Most recently used last
+	open   int           // Idle and checked out
+	notify chan struct{} // Closed when a connection is returned or closed
+}
+
+// Stats describes pool activity, summed across addresses.
+type Stats struct {
+	Open    int // Connections open, idle or in use
+	Idle    int // Connections waiting for reuse
+	InUse   int // Connections checked out
+	Hits    int64
+	Misses  int64 // Checkouts that needed a dial
+	Waits   int64 // Checkouts that waited on MaxOpen
+	Evicted int64 // Closed for being idle or old
+	Failed  int64 // Closed after failing the health check
+}
+
+// Stats returns a snapshot of the pool's counters.
+func (p *Pool) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := p.stats
+	for _, h := range p.hosts {
+		s.Open += h.open
+		s.Idle += len(h.idle)
+	}
+	s.InUse = s.Open - s.Idle
+
+	return s
+}
+
+func (p *Pool) host(address string) *host {
+	if p.hosts == nil {
+		p.hosts = make(map[string]*host)
+	}
+
+	h, ok := p.hosts[address]
+	if !ok {
+		h = &host{notify: make(chan struct{})}
+		p.hosts[address] = h
+	}
+
+	return h
+}
+
+// Get checks out a connection to address, reusing an idle one when it
+// passes the health check, and dialing otherwise. When MaxOpen connections
+// are out, Get waits for one to come back, or for ctx to be done.
+func (p *Pool) Get(ctx context.Context, address string) (*Conn, error) {
+	p.once.Do(p.start)
+
+	waited := false
+
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrClosed
+		}
+		h := p.host(address)
+
+		if n := len(h.idle); n > 0 {
+			c := h.idle[n-1]
+			h.idle = h.idle[:n-1]
+			p.mu.Unlock()
+
+			if c.expired(p, time.Now()) {
+				_ = p.discard(c, &p.stats.Evicted)
+				continue
+			}
+			if !p.healthy(c) {
+				_ = p.discard(c, &p.stats.Failed)
+				continue
+			}
+
+			p.mu.Lock()
+			p.stats.Hits++
+			p.mu.Unlock()
+			c.inUse = true
+
+			return c, nil
+		}
+
+		if p.MaxOpen > 0 && h.open >= p.MaxOpen {
+			notify := h.notify
+			if !waited {
+				p.stats.Waits++
+				waited = true
+			}
+			p.mu.Unlock()
+
+			select {
+			case <-notify:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		h.open++ // Reserve the slot while dialing
+		p.stats.Misses++
+		p.mu.Unlock()
+
+		conn, err := p.dial(ctx, address)
+		if err != nil {
+			p.mu.Lock()
+			h.open--
+			p.wake(h)
+			p.mu.Unlock()
+			return nil, err
+		}
+
+		return &Conn{Conn: conn, pool: p, address: address, created: time.Now(), inUse: true}, nil
+	}
+}
+
+func (p *Pool) dial(ctx context.Context, address string) (net.Conn, error) {
+	if p.Dial != nil {
+		return p.Dial(ctx, address)
+	}
+
+	var d net.Dialer
+
+	return d.DialContext(ctx, "tcp", address)
+}
+
+// healthy checks an idle connection before reuse. Idle connections should
+// have nothing to read, so EOF, a reset or unexpected data means the
+// connection is no good.
+//
+// Sockets are peeked at without blocking. Anything else gets a one-byte
+// read with a short deadline, which passes only by timing out, and so
+// costs HealthCheckTimeout on every checkout. It reads one byte, not
+// zero: Go returns from a zero-byte Read without asking the kernel,
+// which would tell us nothing.
+func (p *Pool) healthy(c *Conn) bool {
+	if sc, ok := c.Conn.(syscall.Conn); ok {
+		idle, err := idle(sc)
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return err == nil && idle
+		}
+	}
+
+	timeout := p.HealthCheckTimeout
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+
+	if err := c.Conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return false
+	}
+
+	var b [1]byte
+	_, err := c.Conn.Read(b[:])
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		return false // EOF, an error, or stray bytes from the last user
+	}
+
+	return c.Conn.SetReadDeadline(time.Time{}) == nil
+}
+
+// idle peeks at conn's socket and reports whether it has nothing to read.
+func idle(conn syscall.Conn) (bool, error) {
+	c, err := conn.SyscallConn()
+	if err != nil {
+		return false, err
+	}
+
+	var ok bool
+
+	cErr := c.Control(func(fd uintptr) {
+		ok, err = peek(int(fd))
+	})
+	if cErr != nil {
+		return false, cErr
+	}
+
+	return ok, err
+}
+
+// put returns a connection to the pool, or closes it if it can't be kept.
+func (p *Pool) put(c *Conn) error {
+	// Don't leak the last user's deadlines. Clear them while the conn is
+	// still ours: once it's idle, a Get may take it and arm its own.
+	if err := c.Conn.SetDeadline(time.Time{}); err != nil {
+		c.unusable = true
+	}
+
+	p.mu.Lock()
+	h := p.host(c.address)
+
+	maxIdle := p.MaxIdle
+	if maxIdle <= 0 {
+		maxIdle = defaultMaxIdle
+	}
+
+	c.idleSince = time.Now()
+	keep := !p.closed && !c.unusable && len(h.idle) < maxIdle && !c.expired(p, c.idleSince)
+	if keep {
+		h.idle = append(h.idle, c)
+		p.wake(h)
+		p.mu.Unlock()
+
+		return nil
+	}
+	p.mu.Unlock()
+
+	return p.discard(c, nil)
+}
+
+// discard closes a connection that's no longer counted as idle, and
+// counts it in counter, if set.
+func (p *Pool) discard(c *Conn, counter *int64) error {
+	err := c.Conn.Close()
+
+	p.mu.Lock()
+	h := p.host(c.address)
+	h.open--
+	if counter != nil {
+		*counter++
+	}
+	p.wake(h)
+	p.mu.Unlock()
+
+	return err
+}
+
+// wake lets Get calls waiting on MaxOpen try again. p.mu must be held.
+func (p *Pool) wake(h *host) {
+	close(h.notify)
+	h.notify = make(chan struct{})
+}
+
+// start runs the cleaner, which evicts idle connections in the
+// background, if there's anything to evict for.
+func (p *Pool) start() {
+	p.mu.Lock()
+	p.stop = make(chan struct{})
+	p.mu.Unlock()
+
+	var interval time.Duration
+	for _, limit := range []time.Duration{p.IdleTimeout, p.MaxLifetime} {
+		if limit > 0 && (interval == 0 || limit < interval) {
+			interval = limit
+		}
+	}
+	if interval == 0 {
+		return // Nothing expires
+	}
+	interval = max(interval/2, 10*time.Millisecond)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.evict()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// evict closes idle connections that outlived IdleTimeout or MaxLifetime.
+func (p *Pool) evict() {
+	now := time.Now()
+
+	var expired []*Conn
+
+	p.mu.Lock()
+	for _, h := range p.hosts {
+		kept := h.idle[:0]
+		for _, c := range h.idle {
+			if c.expired(p, now) {
+				expired = append(expired, c)
+			} else {
+				kept = append(kept, c)
+			}
+		}
+		clear(h.idle[len(kept):])
+		h.idle = kept
+	}
+	p.mu.Unlock()
+
+	for _, c := range expired {
+		_ = p.discard(c, &p.stats.Evicted)
+	}
+}
+
+// Close closes the idle connections and stops the pool. Connections
+// checked out are closed as they're returned.
+func (p *Pool) Close() error {
+	p.once.Do(p.start)
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrClosed
+	}
+	p.closed = true
+	close(p.stop)
+
+	var idle []*Conn
+	for _, h := range p.hosts {
+		idle = append(idle, h.idle...)
+		h.idle = nil
+	}
+	p.mu.Unlock()
+
+	for _, c := range idle {
+		_ = p.discard(c, nil)
+	}
+
+	return nil
+}
+
+// Conn is a pooled connection. Close returns it to the pool.
+type Conn struct {
+	net.Conn
+	pool      *Pool
+	address   string
+	created   time.Time
+	idleSince time.Time
+	unusable  bool
+	inUse     bool
+}
+
+func (c *Conn) expired(p *Pool, now time.Time) bool {
+	if p.MaxLifetime > 0 && now.Sub(c.created) >= p.MaxLifetime {
+		return true
+	}
+
+	return !c.inUse && p.IdleTimeout > 0 && now.Sub(c.idleSince) >= p.IdleTimeout
+}
+
+// MarkUnusable makes Close close the connection instead of returning it
+// to the pool. Call it after an error that leaves the connection in an
+// unknown state, such as a timeout halfway through a response.
+func (c *Conn) MarkUnusable() {
+	c.unusable = true
+}
+
+// Close returns the connection to the pool. Don't use it afterward.
+func (c *Conn) Close() error {
+	if !c.inUse {
+		return net.ErrClosed
+	}
+	c.inUse = false
+
+	return c.pool.put(c)
+}
@@ -0,0 +1,20 @@
+package pool
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// peek reports whether socket fd has nothing to read: no data, no EOF
+// and no pending error. It neither blocks nor consumes anything.
+func peek(fd int) (bool, error) {
+	var b [1]byte
+
+	_, _, err := unix.Recvfrom(fd, b[:], unix.MSG_PEEK|unix.MSG_DONTWAIT)
+	if errors.Is(err, unix.EAGAIN) {
+		return true, nil // Nothing waiting, as an idle conn should be
+	}
+
+	return false, err // Stray bytes or EOF if err is nil
+}
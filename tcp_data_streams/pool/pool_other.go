@@ -0,0 +1,9 @@
+//go:build !linux
+
+package pool
+
+import "errors"
+
+func peek(int) (bool, error) {
+	return false, errors.ErrUnsupported
+}
@@ -0,0 +1,320 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// server echoes on every connection and counts how many it accepted.
+type server struct {
+	net.Listener
+	accepted atomic.Int64
+	conns    chan net.Conn
+}
+
+func newServer(t *testing.T) *server {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	s := &server{Listener: l, conns: make(chan net.Conn, 16)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.accepted.Add(1)
+			s.conns <- conn
+			go func() { _, _ = io.Copy(conn, conn) }()
+		}
+	}()
+
+	return s
+}
+
+func roundTrip(t *testing.T, c net.Conn) {
+	t.Helper()
+
+	_, err := c.Write([]byte("ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(c, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReuse(t *testing.T) {
+	s := newServer(t)
+	p := new(Pool)
+	defer func() { _ = p.Close() }()
+
+	ctx := context.Background()
+	for range 5 {
+		c, err := p.Get(ctx, s.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		roundTrip(t, c)
+		if err = c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := s.accepted.Load(); n != 1 {
+		t.Errorf("expected 1 connection; actual %d", n)
+	}
+	if st := p.Stats(); st.Hits != 4 || st.Misses != 1 || st.Open != 1 || st.Idle != 1 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+}
+
+func TestMaxIdle(t *testing.T) {
+	s := newServer(t)
+	p := &Pool{MaxIdle: 1}
+	defer func() { _ = p.Close() }()
+
+	ctx := context.Background()
+	a, err := p.Get(ctx, s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := p.Get(ctx, s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = a.Close()
+	_ = b.Close() // One too many to keep
+
+	if st := p.Stats(); st.Open != 1 || st.Idle != 1 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+}
+
+func TestMaxOpenWaits(t *testing.T) {
+	s := newServer(t)
+	p := &Pool{MaxOpen: 1}
+	defer func() { _ = p.Close() }()
+
+	a, err := p.Get(context.Background(), s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A second checkout times out while the only connection is out...
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = p.Get(ctx, s.Addr().String())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v; actual %v", context.DeadlineExceeded, err)
+	}
+
+	// ...and gets it once it's returned.
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_ = a.Close()
+	}()
+	b, err := p.Get(context.Background(), s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = b.Close()
+
+	if st := p.Stats(); st.Waits != 2 || s.accepted.Load() != 1 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	s := newServer(t)
+	p := new(Pool)
+	defer func() { _ = p.Close() }()
+
+	c, err := p.Get(context.Background(), s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Close()
+
+	// The server drops the idle connection.
+	_ = (<-s.conns).Close()
+	time.Sleep(10 * time.Millisecond)
+
+	c, err = p.Get(context.Background(), s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, c) // A fresh connection, not the dead one
+	_ = c.Close()
+
+	if st := p.Stats(); st.Failed != 1 || st.Misses != 2 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+}
+
+// TestHealthCheckLatency checks a healthy idle TCP connection is handed
+// out without waiting for the health check's read deadline.
+func TestHealthCheckLatency(t *testing.T) {
+	s := newServer(t)
+	p := &Pool{HealthCheckTimeout: time.Second}
+	defer func() { _ = p.Close() }()
+
+	c, err := p.Get(context.Background(), s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, c)
+	_ = c.Close()
+
+	start := time.Now()
+	c, err = p.Get(context.Background(), s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+
+	if elapsed := time.Since(start); elapsed > p.HealthCheckTimeout/2 {
+		t.Errorf("checkout took %s", elapsed)
+	}
+	roundTrip(t, c)
+	if st := p.Stats(); st.Hits != 1 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+}
+
+func TestUnusable(t *testing.T) {
+	s := newServer(t)
+	p := new(Pool)
+	defer func() { _ = p.Close() }()
+
+	c, err := p.Get(context.Background(), s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.MarkUnusable()
+	_ = c.Close()
+
+	if st := p.Stats(); st.Open != 0 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+	if err = c.Close(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected %v; actual %v", net.ErrClosed, err)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	s := newServer(t)
+	p := &Pool{IdleTimeout: 20 * time.Millisecond}
+	defer func() { _ = p.Close() }()
+
+	c, err := p.Get(context.Background(), s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Close()
+
+	time.Sleep(100 * time.Millisecond) // The cleaner runs every 10ms
+
+	if st := p.Stats(); st.Open != 0 || st.Evicted != 1 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+}
+
+func TestMaxLifetime(t *testing.T) {
+	s := newServer(t)
+	p := &Pool{MaxLifetime: 30 * time.Millisecond}
+	defer func() { _ = p.Close() }()
+
+	c, err := p.Get(context.Background(), s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	_ = c.Close() // Too old to go back in the pool
+
+	if st := p.Stats(); st.Open != 0 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+}
+
+func TestClosedPool(t *testing.T) {
+	s := newServer(t)
+	p := new(Pool)
+
+	c, err := p.Get(context.Background(), s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Close() // Closed rather than pooled
+
+	if st := p.Stats(); st.Open != 0 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+	if _, err = p.Get(context.Background(), s.Addr().String()); err != ErrClosed {
+		t.Errorf("expected %v; actual %v", ErrClosed, err)
+	}
+}
+
+// TestConcurrentPutGet races Close against Get on a few conns. A conn
+// whose deadlines were cleared after it went back on the idle list could
+// lose its health check's, and hang Get.
+func TestConcurrentPutGet(t *testing.T) {
+	s := newServer(t)
+	p := &Pool{MaxOpen: 1} // Waiters take each conn as soon as it's put
+	defer func() { _ = p.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	errs := make(chan error, 8)
+	for range cap(errs) {
+		go func() {
+			errs <- func() error {
+				buf := make([]byte, 4)
+				for range 200 {
+					c, err := p.Get(ctx, s.Addr().String())
+					if err != nil {
+						return err
+					}
+					_ = c.SetDeadline(time.Now().Add(time.Hour)) // For put to clear
+					if _, err = c.Write([]byte("ping")); err == nil {
+						_, err = io.ReadFull(c, buf)
+					}
+					if err != nil {
+						return err
+					}
+					if err = c.Close(); err != nil {
+						return err
+					}
+				}
+				return nil
+			}()
+		}()
+	}
+
+	for range cap(errs) {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(15 * time.Second):
+			t.Fatal("Get hung")
+		}
+	}
+}
@@ -0,0 +1,472 @@
+// Package reconnect keeps a client connection up across server restarts
+// and network blips by redialing, with exponential backoff, whenever the
+// connection drops.
+package reconnect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"networks/sending_tcp_data/retry"
+)
+
+var (
+	// ErrGaveUp is wrapped by the error returned once MaxAttempts dials in
+	// a row have failed.
+	ErrGaveUp = errors.New("reconnect: gave up redialing")
+
+	// ErrBufferFull is returned by Write when buffering p while
+	// disconnected would exceed BufferSize.
+	ErrBufferFull = errors.New("reconnect: write buffer full")
+)
+
+// State is where a ReconnectingConn is in its life cycle.
+type State int
+
+const (
+	Connecting State = iota // Dialing
+	Connected               // Reads and writes go to a live connection
+	BackingOff              // Waiting to redial after a failed dial
+	Closed                  // Closed by the caller, or gave up
+)
+
+func (s State) String() string {
+	switch s {
+	case Connecting:
+		return "connecting"
+	case Connected:
+		return "connected"
+	case BackingOff:
+		return "backing off"
+	case Closed:
+		return "closed"
+	}
+
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Event reports a state change.
+type Event struct {
+	State   State
+	Attempt int           // Consecutive failed dials so far
+	Err     error         // Why the connection dropped or the dial failed
+	Delay   time.Duration // How long until the next dial, when BackingOff
+}
+
+// Options configure a ReconnectingConn.
+type Options struct {
+	Backoff retry.Backoff // Delay between failed dials
+
+	// MaxAttempts is how many dials in a row may fail before giving up.
+	// Zero means never give up.
+	MaxAttempts int
+
+	// BufferSize is how many bytes of writes to hold while disconnected.
+	// They're sent, in order, as soon as the connection is back. When
+	// zero, writes block until the connection is back instead.
+	BufferSize int
+
+	// OnStateChange, if set, is called with each state change, in order,
+	// from the goroutine that redials. It shouldn't block.
+	OnStateChange func(Event)
+}
+
+// ReconnectingConn is a net.Conn that redials whenever its connection
+// fails. Reads and writes wait out the disconnects instead of failing,
+// though deadlines still apply.
+//
+// Bytes the old connection accepted but never delivered are lost in a
+// disconnect; protocols that can't tolerate that must acknowledge data
+// end to end.
+type ReconnectingConn struct {
+	dial func(ctx context.Context) (net.Conn, error)
+	opts Options
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	lost   chan struct{} // Wakes the redialer when the connection fails
+	done   chan struct{} // Closed when the redialer exits
+
+	closeOnce sync.Once
+
+	mu            sync.Mutex
+	conn          net.Conn      // Nil while disconnected
+	ready         chan struct{} // Closed once conn is set
+	buf           []byte        // Writes waiting for a connection
+	state         State
+	cause         error // Why the last connection dropped
+	err           error // Set once closed
+	local, remote net.Addr
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+// New returns a ReconnectingConn that connects, and reconnects, using
+// dial. The first dial happens in the background, so New doesn't block.
+func New(dial func(ctx context.Context) (net.Conn, error), opts Options) *ReconnectingConn {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c := &ReconnectingConn{
+		dial:   dial,
+		opts:   opts,
+		ctx:    ctx,
+		cancel: cancel,
+		lost:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		ready:  make(chan struct{}),
+	}
+	go c.run()
+
+	return c
+}
+
+// Dial is New with a net.Dialer dialing network and address.
+func Dial(network, address string, opts Options) *ReconnectingConn {
+	var d net.Dialer
+
+	return New(func(ctx context.Context) (net.Conn, error) {
+		return d.DialContext(ctx, network, address)
+	}, opts)
+}
+
+// State returns the current state.
+func (c *ReconnectingConn) State() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.state
+}
+
+// run dials, waits for the connection to fail, and dials again, until
+// the conn is closed or MaxAttempts dials in a row fail.
+func (c *ReconnectingConn) run() {
+	defer close(c.done)
+
+	var failures int
+	var cause error
+
+	for {
+		c.emit(Event{State: Connecting, Attempt: failures, Err: cause})
+
+		conn, err := c.dial(c.ctx)
+		if err == nil {
+			err = c.connected(conn)
+		}
+		if c.ctx.Err() != nil {
+			c.shutdown(net.ErrClosed)
+			return
+		}
+
+		if err != nil {
+			failures++
+			if c.opts.MaxAttempts > 0 && failures >= c.opts.MaxAttempts {
+				c.shutdown(fmt.Errorf("%w after %d attempts: %w", ErrGaveUp, failures, err))
+				return
+			}
+
+			delay := c.opts.Backoff.Delay(failures - 1)
+			c.emit(Event{State: BackingOff, Attempt: failures, Err: err, Delay: delay})
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-c.ctx.Done():
+				timer.Stop()
+				c.shutdown(net.ErrClosed)
+				return
+			}
+			cause = err
+			continue
+		}
+
+		failures, cause = 0, nil
+		c.emit(Event{State: Connected})
+
+		select {
+		case <-c.lost:
+			c.mu.Lock()
+			cause = c.cause
+			c.mu.Unlock()
+		case <-c.ctx.Done():
+			c.shutdown(net.ErrClosed)
+			return
+		}
+	}
+}
+
+// connected flushes buffered writes to conn and then makes it current.
+// Writes buffered during the flush are flushed too, so order is kept.
+// Closing the ReconnectingConn closes conn, so a peer that stops reading
+// can't hold up Close with the flush.
+func (c *ReconnectingConn) connected(conn net.Conn) error {
+	stop := context.AfterFunc(c.ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	for {
+		c.mu.Lock()
+		pending := c.buf
+		if len(pending) == 0 {
+			c.conn = conn
+			c.local, c.remote = conn.LocalAddr(), conn.RemoteAddr()
+			_ = conn.SetReadDeadline(c.readDeadline)
+			_ = conn.SetWriteDeadline(c.writeDeadline)
+			close(c.ready)
+			c.mu.Unlock()
+			return nil
+		}
+		c.buf = nil
+		c.mu.Unlock()
+
+		n, err := conn.Write(pending)
+		if err != nil {
+			c.mu.Lock()
+			c.buf = append(pending[n:], c.buf...) // Try again next time
+			c.mu.Unlock()
+			_ = conn.Close()
+			return err
+		}
+	}
+}
+
+// disconnect retires conn after it failed with err, unless it's already
+// been retired, and wakes the redialer.
+func (c *ReconnectingConn) disconnect(conn net.Conn, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn != conn {
+		return
+	}
+
+	_ = conn.Close()
+	c.conn = nil
+	c.ready = make(chan struct{})
+	c.cause = err
+
+	select {
+	case c.lost <- struct{}{}:
+	default:
+	}
+}
+
+func (c *ReconnectingConn) shutdown(err error) {
+	c.mu.Lock()
+	if c.conn != nil {
+		_ = c.conn.Close()
+		c.conn = nil
+	}
+	c.err = err
+	c.mu.Unlock()
+
+	c.emit(Event{State: Closed, Err: err})
+}
+
+func (c *ReconnectingConn) emit(e Event) {
+	c.mu.Lock()
+	c.state = e.State
+	c.mu.Unlock()
+
+	if c.opts.OnStateChange != nil {
+		c.opts.OnStateChange(e)
+	}
+}
+
+// current returns the live connection, or, while disconnected, a channel
+// that's closed once there's one again.
+func (c *ReconnectingConn) current() (net.Conn, <-chan struct{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.closed(); err != nil {
+		return nil, nil, err
+	}
+
+	return c.conn, c.ready, nil
+}
+
+// closed returns why the conn is closed, or nil while it's open. It's
+// net.ErrClosed from the moment Close is called, before the redialer has
+// shut down, so Reads and Writes don't spin on the retired connection in
+// between. c.mu must be held.
+func (c *ReconnectingConn) closed() error {
+	if c.err != nil {
+		return c.err
+	}
+	if c.ctx.Err() != nil {
+		return net.ErrClosed
+	}
+
+	return nil
+}
+
+func (c *ReconnectingConn) isDone() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// wait blocks until ready is closed, the conn is closed, or deadline.
+func (c *ReconnectingConn) wait(ready <-chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-ready:
+		return nil
+	case <-c.ctx.Done():
+	case <-c.done:
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closed()
+}
+
+func (c *ReconnectingConn) Read(p []byte) (int, error) {
+	for {
+		conn, ready, err := c.current()
+		if err != nil {
+			return 0, err
+		}
+		if conn == nil {
+			c.mu.Lock()
+			deadline := c.readDeadline
+			c.mu.Unlock()
+
+			if err = c.wait(ready, deadline); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		n, err := conn.Read(p)
+		if n > 0 || err == nil {
+			return n, nil // A failure will show up again on the next Read
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return 0, err // The caller's deadline, not a failure
+		}
+		c.disconnect(conn, err) // Including io.EOF: the server went away
+	}
+}
+
+func (c *ReconnectingConn) Write(p []byte) (int, error) {
+	var written int
+
+	for {
+		c.mu.Lock()
+		if err := c.closed(); err != nil {
+			c.mu.Unlock()
+			return written, err
+		}
+
+		conn, ready, deadline := c.conn, c.ready, c.writeDeadline
+		if conn == nil && c.opts.BufferSize > 0 {
+			rest := p[written:]
+			if len(c.buf)+len(rest) > c.opts.BufferSize {
+				c.mu.Unlock()
+				return written, ErrBufferFull
+			}
+			c.buf = append(c.buf, rest...)
+			c.mu.Unlock()
+			return len(p), nil
+		}
+		c.mu.Unlock()
+
+		if conn == nil {
+			if err := c.wait(ready, deadline); err != nil {
+				return written, err
+			}
+			continue
+		}
+
+		n, err := conn.Write(p[written:])
+		written += n
+		if err == nil {
+			return written, nil
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return written, err
+		}
+		c.disconnect(conn, err) // Resume with the rest on the next connection
+	}
+}
+
+// Close stops redialing and closes the connection.
+func (c *ReconnectingConn) Close() error {
+	err := net.ErrClosed
+	c.closeOnce.Do(func() {
+		if !c.isDone() {
+			err = nil // Not given up already
+		}
+		c.cancel()
+	})
+	<-c.done
+
+	return err
+}
+
+// LocalAddr returns the local address of the latest connection, or nil
+// before the first one.
+func (c *ReconnectingConn) LocalAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.local
+}
+
+// RemoteAddr returns the remote address of the latest connection, or nil
+// before the first one.
+func (c *ReconnectingConn) RemoteAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.remote
+}
+
+// SetDeadline and friends apply to the current connection and every
+// connection after it, as well as to waiting for a reconnect.
+func (c *ReconnectingConn) SetDeadline(t time.Time) error {
+	_ = c.SetReadDeadline(t)
+
+	return c.SetWriteDeadline(t)
+}
+
+func (c *ReconnectingConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.readDeadline = t
+	if c.conn != nil {
+		return c.conn.SetReadDeadline(t)
+	}
+
+	return nil
+}
+
+func (c *ReconnectingConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeDeadline = t
+	if c.conn != nil {
+		return c.conn.SetWriteDeadline(t)
+	}
+
+	return nil
+}
@@ -0,0 +1,396 @@
+package reconnect
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"networks/sending_tcp_data/retry"
+)
+
+var fast = retry.Backoff{Initial: 5 * time.Millisecond, Max: 20 * time.Millisecond}
+
+// recorder collects state changes.
+type recorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *recorder) record(e Event) {
+	r.mu.Lock()
+	r.events = append(r.events, e)
+	r.mu.Unlock()
+}
+
+func (r *recorder) states() []State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	states := make([]State, len(r.events))
+	for i, e := range r.events {
+		states[i] = e.State
+	}
+
+	return states
+}
+
+// echoServer greets every connection and then echoes on it, until the
+// listener is closed, which also drops every connection, like a server
+// restart would.
+func echoServer(address, greeting string) (net.Listener, error) {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	closing := make(chan struct{})
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				close(closing)
+				return
+			}
+			go func() {
+				go func() {
+					<-closing
+					_ = conn.Close()
+				}()
+				_, err := conn.Write([]byte(greeting))
+				if err == nil {
+					_, _ = io.Copy(conn, conn)
+				}
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	return l, nil
+}
+
+func echo(t *testing.T, c net.Conn, msg string) {
+	t.Helper()
+
+	_, err := c.Write([]byte(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, c, msg)
+}
+
+func expect(t *testing.T, c net.Conn, msg string) {
+	t.Helper()
+
+	buf := make([]byte, len(msg))
+	_, err := io.ReadFull(c, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := string(buf); actual != msg {
+		t.Fatalf("expected %q; actual %q", msg, actual)
+	}
+}
+
+func TestReconnectAfterServerRestart(t *testing.T) {
+	l, err := echoServer("127.0.0.1:0", "first ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+
+	events := new(recorder)
+	c := Dial("tcp", address, Options{Backoff: fast, OnStateChange: events.record})
+	defer func() { _ = c.Close() }()
+
+	err = c.SetDeadline(time.Now().Add(5 * time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect(t, c, "first ")
+	echo(t, c, "before")
+
+	// Restart the server on the same address while the client is blocked
+	// reading. The read should see the second server's greeting, not the
+	// first one's EOF.
+	restarted := make(chan error, 1)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_ = l.Close()
+		time.Sleep(50 * time.Millisecond)
+
+		l, err := echoServer(address, "second ")
+		if err == nil {
+			t.Cleanup(func() { _ = l.Close() })
+		}
+		restarted <- err
+	}()
+
+	expect(t, c, "second ")
+	if err = <-restarted; err != nil {
+		t.Fatal(err)
+	}
+	echo(t, c, "after")
+
+	connected := 0
+	for _, s := range events.states() {
+		if s == Connected {
+			connected++
+		}
+	}
+	if connected < 2 {
+		t.Errorf("expected to connect twice; states %v", events.states())
+	}
+}
+
+// pipeDialer hands out net.Pipe connections once it's up.
+type pipeDialer struct {
+	mu      sync.Mutex
+	up      bool
+	servers chan net.Conn
+}
+
+func (p *pipeDialer) dial(ctx context.Context) (net.Conn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.up {
+		return nil, errors.New("connection refused")
+	}
+
+	client, server := net.Pipe()
+	p.servers <- server
+
+	return client, nil
+}
+
+func (p *pipeDialer) setUp(up bool) {
+	p.mu.Lock()
+	p.up = up
+	p.mu.Unlock()
+}
+
+func TestBufferWhileDisconnected(t *testing.T) {
+	p := &pipeDialer{servers: make(chan net.Conn, 1)}
+	c := New(p.dial, Options{Backoff: fast, BufferSize: 10})
+	defer func() { _ = c.Close() }()
+
+	for _, msg := range []string{"one ", "two "} {
+		n, err := c.Write([]byte(msg))
+		if err != nil || n != len(msg) {
+			t.Fatalf("buffered write returned %d, %v", n, err)
+		}
+	}
+
+	_, err := c.Write([]byte("three"))
+	if err != ErrBufferFull {
+		t.Fatalf("expected %v; actual %v", ErrBufferFull, err)
+	}
+
+	p.setUp(true)
+	server := <-p.servers
+	defer func() { _ = server.Close() }()
+
+	buf := make([]byte, 8)
+	_, err = io.ReadFull(server, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, []byte("one two ")) {
+		t.Errorf("unexpected flushed writes %q", buf)
+	}
+}
+
+func TestWriteWaitsForReconnect(t *testing.T) {
+	p := &pipeDialer{servers: make(chan net.Conn, 1), up: true}
+	c := New(p.dial, Options{Backoff: fast})
+	defer func() { _ = c.Close() }()
+
+	// Drop the first connection and refuse dials for a while.
+	first := <-p.servers
+	p.setUp(false)
+	_ = first.Close()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		p.setUp(true)
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Write([]byte("hello"))
+		done <- err
+	}()
+
+	server := <-p.servers
+	defer func() { _ = server.Close() }()
+
+	buf := make([]byte, 5)
+	_, err := io.ReadFull(server, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Errorf("unexpected data %q", buf)
+	}
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGiveUp(t *testing.T) {
+	p := &pipeDialer{}
+	events := new(recorder)
+	c := New(p.dial, Options{Backoff: fast, MaxAttempts: 3, OnStateChange: events.record})
+	defer func() { _ = c.Close() }()
+
+	_, err := c.Read(make([]byte, 1))
+	if !errors.Is(err, ErrGaveUp) {
+		t.Fatalf("expected %v; actual %v", ErrGaveUp, err)
+	}
+
+	expected := []State{Connecting, BackingOff, Connecting, BackingOff, Connecting, Closed}
+	actual := events.states()
+	if len(actual) != len(expected) {
+		t.Fatalf("expected states %v; actual %v", expected, actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Fatalf("expected states %v; actual %v", expected, actual)
+		}
+	}
+	if c.State() != Closed {
+		t.Errorf("expected %s; actual %s", Closed, c.State())
+	}
+}
+
+func TestCloseWhileDisconnected(t *testing.T) {
+	c := New((&pipeDialer{}).dial, Options{Backoff: retry.Backoff{Initial: time.Minute}})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Read(make([]byte, 1))
+		done <- err
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-done; !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected %v; actual %v", net.ErrClosed, err)
+	}
+	if err := c.Close(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected a second Close to return %v; actual %v", net.ErrClosed, err)
+	}
+}
+
+// TestReadAfterClose checks a Read blocked on a live connection returns
+// as soon as Close retires it, without waiting for, or spinning until, the
+// redialer finishes shutting down.
+func TestReadAfterClose(t *testing.T) {
+	p := &pipeDialer{up: true, servers: make(chan net.Conn, 1)}
+	release := make(chan struct{})
+	c := New(p.dial, Options{Backoff: fast, OnStateChange: func(e Event) {
+		if e.State == Closed {
+			<-release // Hold up the shutdown
+		}
+	}})
+
+	server := <-p.servers
+	defer func() { _ = server.Close() }()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Read(make([]byte, 1))
+		done <- err
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	closed := make(chan error, 1)
+	go func() { closed <- c.Close() }()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("expected %v; actual %v", net.ErrClosed, err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Read didn't return after Close")
+	}
+
+	close(release)
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCloseDuringFlush checks Close doesn't wait on a peer that stopped
+// reading while buffered writes were being flushed to it.
+func TestCloseDuringFlush(t *testing.T) {
+	p := &pipeDialer{servers: make(chan net.Conn, 1)}
+	c := New(p.dial, Options{Backoff: fast, BufferSize: 10})
+
+	if _, err := c.Write([]byte("stuck")); err != nil {
+		t.Fatal(err)
+	}
+	p.setUp(true)
+	server := <-p.servers // Never read from, so the flush blocks
+	defer func() { _ = server.Close() }()
+
+	closed := make(chan error, 1)
+	go func() { closed <- c.Close() }()
+
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked behind the flush")
+	}
+}
+
+func TestConcurrentClose(t *testing.T) {
+	c := New((&pipeDialer{}).dial, Options{Backoff: fast})
+
+	errs := make(chan error, 2)
+	for range 2 {
+		go func() { errs <- c.Close() }()
+	}
+
+	var succeeded int
+	for range 2 {
+		if err := <-errs; err == nil {
+			succeeded++
+		} else if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("expected %v; actual %v", net.ErrClosed, err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("expected exactly one Close to succeed; actual %d", succeeded)
+	}
+}
+
+func TestDeadlineWhileDisconnected(t *testing.T) {
+	c := New((&pipeDialer{}).dial, Options{Backoff: fast})
+	defer func() { _ = c.Close() }()
+
+	err := c.SetReadDeadline(time.Now().Add(30 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.Read(make([]byte, 1))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected %v; actual %v", os.ErrDeadlineExceeded, err)
+	}
+}
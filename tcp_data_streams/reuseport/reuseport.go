@@ -0,0 +1,153 @@
+// Package reuseport spreads accepting across several sockets bound to the
+// same address with SO_REUSEPORT. The kernel balances new connections
+// among the sockets, so a busy server isn't limited by a single accept
+// loop and its queue.
+package reuseport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"networks/tcp_data_streams/sockopt"
+)
+
+// Group is a set of listeners on one address, with an accept loop each,
+// that looks like a single net.Listener.
+type Group struct {
+	listeners []net.Listener
+	accepted  []atomic.Int64 // Per listener
+	errors    atomic.Int64
+
+	conns chan result
+	done  chan struct{}
+	once  sync.Once
+	wg    sync.WaitGroup
+}
+
+type result struct {
+	conn net.Conn
+	err  error
+}
+
+// Stats are the group's metrics, merged across its listeners.
+type Stats struct {
+	Listeners int
+	Accepted  int64   // Connections accepted by all listeners
+	PerSocket []int64 // Connections accepted by each listener
+	Errors    int64   // Accept errors, other than from closing
+}
+
+// Listen opens n listeners on address, or one per CPU if n isn't positive.
+// If address has port 0, the first listener picks the port and the rest
+// share it.
+func Listen(ctx context.Context, network, address string, n int) (*Group, error) {
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+
+	lc := net.ListenConfig{Control: sockopt.Options{ReusePort: true}.Control}
+	g := &Group{
+		accepted: make([]atomic.Int64, n),
+		conns:    make(chan result),
+		done:     make(chan struct{}),
+	}
+
+	for i := 0; i < n; i++ {
+		l, err := lc.Listen(ctx, network, address)
+		if err != nil {
+			for _, l := range g.listeners {
+				_ = l.Close()
+			}
+			return nil, err
+		}
+		if i == 0 {
+			address = l.Addr().String() // Pin the port picked for port 0
+		}
+		g.listeners = append(g.listeners, l)
+	}
+
+	for i, l := range g.listeners {
+		g.wg.Add(1)
+		go g.acceptLoop(i, l)
+	}
+
+	return g, nil
+}
+
+// acceptLoop hands connections from one listener to Accept.
+func (g *Group) acceptLoop(i int, l net.Listener) {
+	defer g.wg.Done()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			g.errors.Add(1)
+		} else {
+			g.accepted[i].Add(1)
+		}
+
+		select {
+		case g.conns <- result{conn: conn, err: err}:
+		case <-g.done:
+			if conn != nil {
+				_ = conn.Close()
+			}
+			return
+		}
+	}
+}
+
+// Accept returns the next connection from any of the listeners.
+func (g *Group) Accept() (net.Conn, error) {
+	select {
+	case r := <-g.conns:
+		return r.conn, r.err
+	case <-g.done:
+		return nil, &net.OpError{Op: "accept", Net: g.Addr().Network(), Addr: g.Addr(), Err: net.ErrClosed}
+	}
+}
+
+// Close closes every listener and waits for the accept loops to exit.
+func (g *Group) Close() error {
+	err := net.ErrClosed
+
+	g.once.Do(func() {
+		close(g.done)
+
+		var errs []error
+		for _, l := range g.listeners {
+			errs = append(errs, l.Close())
+		}
+		g.wg.Wait()
+
+		err = errors.Join(errs...)
+	})
+
+	return err
+}
+
+// Addr returns the address every listener is bound to.
+func (g *Group) Addr() net.Addr {
+	return g.listeners[0].Addr()
+}
+
+func (g *Group) Stats() Stats {
+	s := Stats{
+		Listeners: len(g.listeners),
+		PerSocket: make([]int64, len(g.accepted)),
+		Errors:    g.errors.Load(),
+	}
+	for i := range g.accepted {
+		s.PerSocket[i] = g.accepted[i].Load()
+		s.Accepted += s.PerSocket[i]
+	}
+
+	return s
+}
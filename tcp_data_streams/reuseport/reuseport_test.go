@@ -0,0 +1,160 @@
+package reuseport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+// echo serves l like streamingEchoServer: one accept loop, and a goroutine
+// echoing on each connection.
+func echo(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer func() { _ = conn.Close() }()
+
+			buf := make([]byte, 1024)
+			for {
+				n, err := conn.Read(buf)
+				if err != nil {
+					return
+				}
+				_, err = conn.Write(buf[:n])
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+func roundTrip(addr string) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	_, err = conn.Write([]byte("ping"))
+	if err != nil {
+		return err
+	}
+	_, err = io.ReadFull(conn, make([]byte, 4))
+
+	return err
+}
+
+func TestGroup(t *testing.T) {
+	g, err := Listen(context.Background(), "tcp", "127.0.0.1:0", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = g.Close() }()
+	go echo(g)
+
+	const clients = 100
+
+	var wg sync.WaitGroup
+	errs := make(chan error, clients)
+	for range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- roundTrip(g.Addr().String())
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := g.Stats()
+	if s.Listeners != 4 || s.Accepted != clients {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	// The kernel hashes connections across the sockets, so with this many
+	// clients more than one socket should have accepted some.
+	busy := 0
+	for _, n := range s.PerSocket {
+		if n > 0 {
+			busy++
+		}
+	}
+	if busy < 2 {
+		t.Errorf("connections weren't spread across sockets: %v", s.PerSocket)
+	}
+}
+
+func TestGroupClose(t *testing.T) {
+	g, err := Listen(context.Background(), "tcp", "127.0.0.1:0", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accepted := make(chan error)
+	go func() {
+		_, err := g.Accept()
+		accepted <- err
+	}()
+
+	if err = g.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-accepted; !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected %v; actual %v", net.ErrClosed, err)
+	}
+	if err = g.Close(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected a second Close to return %v; actual %v", net.ErrClosed, err)
+	}
+
+	// Every socket released the port.
+	if _, err = net.Dial("tcp", g.Addr().String()); err == nil {
+		t.Error("dial succeeded after Close")
+	}
+}
+
+func benchmarkEcho(b *testing.B, l net.Listener) {
+	defer func() { _ = l.Close() }()
+	go echo(l)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := roundTrip(l.Addr().String()); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// BenchmarkSingleAcceptor is the streamingEchoServer pattern: one
+// listener, one accept loop.
+func BenchmarkSingleAcceptor(b *testing.B) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkEcho(b, l)
+}
+
+func BenchmarkReusePort(b *testing.B) {
+	g, err := Listen(context.Background(), "tcp", "127.0.0.1:0", 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkEcho(b, g)
+}
@@ -0,0 +1,114 @@
+// Package sockopt sets TCP socket options from a typed struct, either as
+// a net.Dialer or net.ListenConfig Control function, or on a connection
+// that's already open.
+//
+// Go sets some options itself after connecting: TCP_NODELAY is always
+// enabled, and keepalives are configured from Dialer.KeepAlive unless it's
+// negative. Set KeepAlive to -1 when using the keepalive fields here, and
+// use Apply after dialing to turn NoDelay off.
+package sockopt
+
+import (
+	"syscall"
+	"time"
+)
+
+// Toggle is an on/off option that may also be left at the system default.
+type Toggle int8
+
+const (
+	Unset Toggle = iota // Leave the option alone
+	On
+	Off
+)
+
+// Options are the socket options to set. Zero values leave the system
+// defaults alone.
+type Options struct {
+	// KeepAliveIdle enables SO_KEEPALIVE and sets how long the connection
+	// must be idle before the first probe (TCP_KEEPIDLE).
+	KeepAliveIdle     time.Duration
+	KeepAliveInterval time.Duration // Between probes (TCP_KEEPINTVL)
+	KeepAliveCount    int           // Unanswered probes before giving up (TCP_KEEPCNT)
+
+	NoDelay Toggle // TCP_NODELAY: disables Nagle's algorithm when On
+
+	// RecvBuffer and SendBuffer size the socket buffers (SO_RCVBUF and
+	// SO_SNDBUF). Linux doubles the value for bookkeeping overhead, and
+	// reads back the doubled value.
+	RecvBuffer int
+	SendBuffer int
+
+	// UserTimeout is how long sent data may go unacknowledged before the
+	// connection is dropped (TCP_USER_TIMEOUT).
+	UserTimeout time.Duration
+
+	// Linger, when On, makes Close block for up to LingerTimeout while
+	// unsent data drains (SO_LINGER). A zero LingerTimeout instead
+	// discards the data and resets the connection.
+	Linger        Toggle
+	LingerTimeout time.Duration
+
+	// ReusePort lets several sockets bind the same address and port, with
+	// the kernel balancing connections among them (SO_REUSEPORT).
+	ReusePort bool
+
+	// FastOpen is the TCP Fast Open queue length for listeners
+	// (TCP_FASTOPEN). FastOpenConnect enables Fast Open for dialers
+	// (TCP_FASTOPEN_CONNECT).
+	FastOpen        int
+	FastOpenConnect bool
+}
+
+// Control sets the options on a socket before it connects or binds. Its
+// signature matches net.Dialer.Control and net.ListenConfig.Control.
+func (o Options) Control(_, _ string, c syscall.RawConn) error {
+	var err error
+
+	cErr := c.Control(func(fd uintptr) {
+		err = o.set(int(fd))
+	})
+	if cErr != nil {
+		return cErr
+	}
+
+	return err
+}
+
+// Apply sets the options on an open connection or listener, such as a
+// *net.TCPConn or *net.TCPListener.
+func (o Options) Apply(conn syscall.Conn) error {
+	c, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	return o.Control("", "", c)
+}
+
+// Get reads the options back from a connection or listener.
+func Get(conn syscall.Conn) (Options, error) {
+	c, err := conn.SyscallConn()
+	if err != nil {
+		return Options{}, err
+	}
+
+	var o Options
+
+	cErr := c.Control(func(fd uintptr) {
+		o, err = get(int(fd))
+	})
+	if cErr != nil {
+		return Options{}, cErr
+	}
+
+	return o, err
+}
+
+func toggle(on bool) Toggle {
+	if on {
+		return On
+	}
+
+	return Off
+}
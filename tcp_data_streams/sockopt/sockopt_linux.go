@@ -0,0 +1,126 @@
+package sockopt
+
+import (
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// intOption is a socket option with an integer value.
+type intOption struct {
+	level, opt int
+	name       string
+	value      int
+}
+
+func (o Options) set(fd int) error {
+	var opts []intOption
+
+	if o.KeepAliveIdle > 0 {
+		opts = append(opts,
+			intOption{unix.SOL_SOCKET, unix.SO_KEEPALIVE, "SO_KEEPALIVE", 1},
+			intOption{unix.IPPROTO_TCP, unix.TCP_KEEPIDLE, "TCP_KEEPIDLE", seconds(o.KeepAliveIdle)})
+	}
+	if o.KeepAliveInterval > 0 {
+		opts = append(opts, intOption{unix.IPPROTO_TCP, unix.TCP_KEEPINTVL, "TCP_KEEPINTVL", seconds(o.KeepAliveInterval)})
+	}
+	if o.KeepAliveCount > 0 {
+		opts = append(opts, intOption{unix.IPPROTO_TCP, unix.TCP_KEEPCNT, "TCP_KEEPCNT", o.KeepAliveCount})
+	}
+	if o.NoDelay != Unset {
+		opts = append(opts, intOption{unix.IPPROTO_TCP, unix.TCP_NODELAY, "TCP_NODELAY", boolInt(o.NoDelay == On)})
+	}
+	if o.RecvBuffer > 0 {
+		opts = append(opts, intOption{unix.SOL_SOCKET, unix.SO_RCVBUF, "SO_RCVBUF", o.RecvBuffer})
+	}
+	if o.SendBuffer > 0 {
+		opts = append(opts, intOption{unix.SOL_SOCKET, unix.SO_SNDBUF, "SO_SNDBUF", o.SendBuffer})
+	}
+	if o.UserTimeout > 0 {
+		opts = append(opts, intOption{unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT, "TCP_USER_TIMEOUT", int(o.UserTimeout.Milliseconds())})
+	}
+	if o.ReusePort {
+		opts = append(opts, intOption{unix.SOL_SOCKET, unix.SO_REUSEPORT, "SO_REUSEPORT", 1})
+	}
+	if o.FastOpen > 0 {
+		opts = append(opts, intOption{unix.IPPROTO_TCP, unix.TCP_FASTOPEN, "TCP_FASTOPEN", o.FastOpen})
+	}
+	if o.FastOpenConnect {
+		opts = append(opts, intOption{unix.IPPROTO_TCP, unix.TCP_FASTOPEN_CONNECT, "TCP_FASTOPEN_CONNECT", 1})
+	}
+
+	for _, i := range opts {
+		if err := unix.SetsockoptInt(fd, i.level, i.opt, i.value); err != nil {
+			return os.NewSyscallError("setsockopt "+i.name, err)
+		}
+	}
+
+	if o.Linger != Unset {
+		l := &unix.Linger{Onoff: int32(boolInt(o.Linger == On)), Linger: int32(seconds(o.LingerTimeout))}
+		err := unix.SetsockoptLinger(fd, unix.SOL_SOCKET, unix.SO_LINGER, l)
+		if err != nil {
+			return os.NewSyscallError("setsockopt SO_LINGER", err)
+		}
+	}
+
+	return nil
+}
+
+func get(fd int) (Options, error) {
+	var o Options
+	var err error
+
+	getInt := func(level, opt int, name string) int {
+		if err != nil {
+			return 0
+		}
+		var v int
+		v, err = unix.GetsockoptInt(fd, level, opt)
+		if err != nil {
+			err = os.NewSyscallError("getsockopt "+name, err)
+		}
+		return v
+	}
+
+	keepAlive := getInt(unix.SOL_SOCKET, unix.SO_KEEPALIVE, "SO_KEEPALIVE") != 0
+	idle := getInt(unix.IPPROTO_TCP, unix.TCP_KEEPIDLE, "TCP_KEEPIDLE")
+	if keepAlive {
+		o.KeepAliveIdle = time.Duration(idle) * time.Second
+	}
+	o.KeepAliveInterval = time.Duration(getInt(unix.IPPROTO_TCP, unix.TCP_KEEPINTVL, "TCP_KEEPINTVL")) * time.Second
+	o.KeepAliveCount = getInt(unix.IPPROTO_TCP, unix.TCP_KEEPCNT, "TCP_KEEPCNT")
+	o.NoDelay = toggle(getInt(unix.IPPROTO_TCP, unix.TCP_NODELAY, "TCP_NODELAY") != 0)
+	o.RecvBuffer = getInt(unix.SOL_SOCKET, unix.SO_RCVBUF, "SO_RCVBUF")
+	o.SendBuffer = getInt(unix.SOL_SOCKET, unix.SO_SNDBUF, "SO_SNDBUF")
+	o.UserTimeout = time.Duration(getInt(unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT, "TCP_USER_TIMEOUT")) * time.Millisecond
+	o.ReusePort = getInt(unix.SOL_SOCKET, unix.SO_REUSEPORT, "SO_REUSEPORT") != 0
+	o.FastOpen = getInt(unix.IPPROTO_TCP, unix.TCP_FASTOPEN, "TCP_FASTOPEN")
+	o.FastOpenConnect = getInt(unix.IPPROTO_TCP, unix.TCP_FASTOPEN_CONNECT, "TCP_FASTOPEN_CONNECT") != 0
+	if err != nil {
+		return Options{}, err
+	}
+
+	l, err := unix.GetsockoptLinger(fd, unix.SOL_SOCKET, unix.SO_LINGER)
+	if err != nil {
+		return Options{}, os.NewSyscallError("getsockopt SO_LINGER", err)
+	}
+	o.Linger = toggle(l.Onoff != 0)
+	o.LingerTimeout = time.Duration(l.Linger) * time.Second
+
+	return o, nil
+}
+
+// seconds rounds d up to whole seconds, the unit of most TCP timers,
+// so a nonzero duration never becomes zero.
+func seconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
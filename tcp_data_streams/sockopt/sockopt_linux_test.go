@@ -0,0 +1,122 @@
+package sockopt
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDialerControl(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+
+	opts := Options{
+		KeepAliveIdle:     30 * time.Second,
+		KeepAliveInterval: 5 * time.Second,
+		KeepAliveCount:    4,
+		RecvBuffer:        64 << 10,
+		SendBuffer:        32 << 10,
+		UserTimeout:       10 * time.Second,
+		Linger:            On,
+		LingerTimeout:     3 * time.Second,
+		FastOpenConnect:   true,
+	}
+
+	d := net.Dialer{
+		Control:   opts.Control,
+		KeepAlive: -1, // Keep Go from overriding our keepalive settings
+	}
+	conn, err := d.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	actual, err := Get(conn.(*net.TCPConn))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Linux doubles buffer sizes to account for overhead.
+	expected := opts
+	expected.RecvBuffer *= 2
+	expected.SendBuffer *= 2
+	expected.NoDelay = On // Go enables it after connecting
+
+	if actual != expected {
+		t.Errorf("expected %+v;\nactual   %+v", expected, actual)
+	}
+}
+
+func TestApplyNoDelay(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	tcp := conn.(*net.TCPConn)
+
+	for _, toggle := range []Toggle{Off, On} {
+		if err = (Options{NoDelay: toggle}).Apply(tcp); err != nil {
+			t.Fatal(err)
+		}
+
+		actual, err := Get(tcp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual.NoDelay != toggle {
+			t.Errorf("expected NoDelay %d; actual %d", toggle, actual.NoDelay)
+		}
+	}
+}
+
+func TestListenConfigControl(t *testing.T) {
+	opts := Options{ReusePort: true, FastOpen: 16}
+
+	lc := net.ListenConfig{Control: opts.Control}
+	l, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+
+	actual, err := Get(l.(*net.TCPListener))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !actual.ReusePort || actual.FastOpen != 16 {
+		t.Errorf("unexpected options %+v", actual)
+	}
+
+	// A second listener can share the port.
+	l2, err := lc.Listen(context.Background(), "tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = l2.Close()
+}
+
+func TestSecondsRoundUp(t *testing.T) {
+	for d, expected := range map[time.Duration]int{
+		0:                       0,
+		time.Millisecond:        1,
+		time.Second:             1,
+		1500 * time.Millisecond: 2,
+	} {
+		if actual := seconds(d); actual != expected {
+			t.Errorf("%s: expected %d; actual %d", d, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package sockopt
+
+import "errors"
+
+func (o Options) set(int) error {
+	if o == (Options{}) {
+		return nil
+	}
+
+	return errors.ErrUnsupported
+}
+
+func get(int) (Options, error) {
+	return Options{}, errors.ErrUnsupported
+}
@@ -0,0 +1,136 @@
+// Package tcpinfo reports how the kernel sees a TCP connection: its
+// round-trip time, congestion window, retransmissions and delivery rate,
+// from Linux's TCP_INFO. That's the place to look when a transfer is slow
+// and you need to know whether the network or the application is to blame.
+package tcpinfo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Info is a snapshot of a connection's TCP state.
+type Info struct {
+	State string // Such as "ESTABLISHED"
+
+	RTT    time.Duration // Smoothed round-trip time
+	RTTVar time.Duration // Round-trip time variation
+	MinRTT time.Duration // Lowest RTT seen
+	RTO    time.Duration // Retransmission timeout
+
+	SendMSS       uint32 // Maximum segment size, in bytes
+	SendCwnd      uint32 // Congestion window, in segments
+	SendSSThresh  uint32 // Slow start threshold, in segments
+	Unacked       uint32 // Segments sent but not yet acknowledged
+	Lost          uint32 // Segments presumed lost
+	Retransmits   uint8  // Unrecovered retransmission timeouts in a row
+	TotalRetrans  uint32 // Segments retransmitted over the connection's life
+	NotSentBytes  uint32 // Bytes queued but not yet sent
+	BytesSent     uint64
+	BytesAcked    uint64
+	BytesRetrans  uint64
+	BytesReceived uint64
+
+	PacingRate   uint64 // Bytes per second the sender paces itself to
+	DeliveryRate uint64 // Bytes per second recently delivered to the peer
+}
+
+func (i *Info) String() string {
+	return fmt.Sprintf("%s rtt=%s/%s cwnd=%d retrans=%d/%d acked=%d pacing=%dB/s delivery=%dB/s",
+		i.State, i.RTT, i.RTTVar, i.SendCwnd, i.Retransmits, i.TotalRetrans,
+		i.BytesAcked, i.PacingRate, i.DeliveryRate)
+}
+
+// Get returns a snapshot of conn's TCP state.
+func Get(conn *net.TCPConn) (*Info, error) {
+	c, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var info *Info
+
+	cErr := c.Control(func(fd uintptr) {
+		info, err = get(int(fd))
+	})
+	if cErr != nil {
+		return nil, cErr
+	}
+
+	return info, err
+}
+
+// Sample calls fn with a snapshot of conn's TCP state every interval,
+// until ctx is done or conn is closed, in which case it returns nil.
+//
+//	go tcpinfo.Sample(ctx, conn, time.Second, func(i *tcpinfo.Info) {
+//		log.Printf("[%s] %s", conn.RemoteAddr(), i)
+//	})
+func Sample(ctx context.Context, conn *net.TCPConn, interval time.Duration, fn func(*Info)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		info, err := Get(conn)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		fn(info)
+	}
+}
+
+// Watch runs Sample on conn in the background until conn is closed.
+// Closing the conn Watch returns reports one last snapshot first, while
+// the socket is still open, so the totals for a finished transfer show up
+// too. If conn isn't a *net.TCPConn, such as a connection wrapped by a
+// shaping.Conn, Watch returns it as is.
+func Watch(conn net.Conn, interval time.Duration, fn func(*Info)) net.Conn {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return conn
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		_ = Sample(ctx, tcp, interval, fn) // Off Linux, this ends at the first tick
+	}()
+
+	return &watched{TCPConn: tcp, stop: func() {
+		cancel()
+		<-done
+
+		if info, err := Get(tcp); err == nil {
+			fn(info)
+		}
+	}}
+}
+
+// watched is a connection Watch samples. It embeds *net.TCPConn so
+// CloseWrite and the rest still work.
+type watched struct {
+	*net.TCPConn
+	once sync.Once
+	stop func()
+}
+
+func (w *watched) Close() error {
+	w.once.Do(w.stop)
+
+	return w.TCPConn.Close()
+}
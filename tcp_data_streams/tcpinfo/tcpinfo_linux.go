@@ -0,0 +1,61 @@
+package tcpinfo
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Connection states, from include/net/tcp_states.h.
+var states = [...]string{
+	1:  "ESTABLISHED",
+	2:  "SYN_SENT",
+	3:  "SYN_RECV",
+	4:  "FIN_WAIT1",
+	5:  "FIN_WAIT2",
+	6:  "TIME_WAIT",
+	7:  "CLOSE",
+	8:  "CLOSE_WAIT",
+	9:  "LAST_ACK",
+	10: "LISTEN",
+	11: "CLOSING",
+	12: "NEW_SYN_RECV",
+}
+
+func get(fd int) (*Info, error) {
+	ti, err := unix.GetsockoptTCPInfo(fd, unix.IPPROTO_TCP, unix.TCP_INFO)
+	if err != nil {
+		return nil, os.NewSyscallError("getsockopt TCP_INFO", err)
+	}
+
+	state := fmt.Sprintf("STATE(%d)", ti.State)
+	if int(ti.State) < len(states) && states[ti.State] != "" {
+		state = states[ti.State]
+	}
+
+	usec := func(v uint32) time.Duration { return time.Duration(v) * time.Microsecond }
+
+	return &Info{
+		State:         state,
+		RTT:           usec(ti.Rtt),
+		RTTVar:        usec(ti.Rttvar),
+		MinRTT:        usec(ti.Min_rtt),
+		RTO:           usec(ti.Rto),
+		SendMSS:       ti.Snd_mss,
+		SendCwnd:      ti.Snd_cwnd,
+		SendSSThresh:  ti.Snd_ssthresh,
+		Unacked:       ti.Unacked,
+		Lost:          ti.Lost,
+		Retransmits:   ti.Retransmits,
+		TotalRetrans:  ti.Total_retrans,
+		NotSentBytes:  ti.Notsent_bytes,
+		BytesSent:     ti.Bytes_sent,
+		BytesAcked:    ti.Bytes_acked,
+		BytesRetrans:  ti.Bytes_retrans,
+		BytesReceived: ti.Bytes_received,
+		PacingRate:    ti.Pacing_rate,
+		DeliveryRate:  ti.Delivery_rate,
+	}, nil
+}
@@ -0,0 +1,134 @@
+package tcpinfo
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// transfer connects to a server that discards everything, and returns
+// the client's connection.
+func transfer(t *testing.T) *net.TCPConn {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn.(*net.TCPConn)
+}
+
+func TestGet(t *testing.T) {
+	conn := transfer(t)
+
+	_, err := conn.Write(make([]byte, 1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond) // Let the acknowledgments arrive
+
+	info, err := Get(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(info)
+
+	if info.State != "ESTABLISHED" {
+		t.Errorf("expected ESTABLISHED; actual %s", info.State)
+	}
+	if info.RTT <= 0 || info.SendCwnd == 0 || info.SendMSS == 0 {
+		t.Errorf("missing transport state: %+v", info)
+	}
+	if info.BytesAcked < 1<<20 {
+		t.Errorf("expected at least 1 MiB acknowledged; actual %d", info.BytesAcked)
+	}
+}
+
+func TestSample(t *testing.T) {
+	conn := transfer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	samples := make(chan *Info, 100)
+	done := make(chan error)
+	go func() {
+		done <- Sample(ctx, conn, 5*time.Millisecond, func(i *Info) { samples <- i })
+	}()
+
+	for range 10 {
+		_, err := conn.Write(make([]byte, 64<<10))
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Sampling stops by itself once the connection is closed.
+	_ = conn.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	close(samples)
+
+	var n int
+	var last uint64
+	for i := range samples {
+		if i.BytesAcked < last {
+			t.Errorf("bytes acked went backwards: %d after %d", i.BytesAcked, last)
+		}
+		last = i.BytesAcked
+		n++
+	}
+	if n < 2 {
+		t.Errorf("expected several samples; actual %d", n)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	conn := transfer(t)
+
+	var samples []*Info
+	w := Watch(conn, time.Hour, func(i *Info) { samples = append(samples, i) })
+
+	_, err := w.Write(make([]byte, 64<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Close()
+
+	// Only the final snapshot, since the interval never came around.
+	if len(samples) != 1 || samples[0].BytesAcked < 64<<10 {
+		t.Fatalf("expected one final snapshot with 64 KiB acknowledged; actual %v", samples)
+	}
+
+	client, server := net.Pipe()
+	defer func() { _ = server.Close() }()
+	if w = Watch(client, time.Millisecond, func(*Info) {}); w != client {
+		t.Error("expected a net.Pipe conn back unwatched")
+	}
+	_ = w.Close()
+}
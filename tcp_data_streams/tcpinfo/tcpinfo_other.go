@@ -0,0 +1,9 @@
+//go:build !linux
+
+package tcpinfo
+
+import "errors"
+
+func get(int) (*Info, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,184 @@
+// Package drain serves stream connections, TCP or Unix, and shuts down
+// gracefully: it stops accepting, tells each open connection the server is
+// going away, waits for them to finish, and closes whatever's left once
+// the deadline passes.
+package drain
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+// ErrServerClosed is returned by Serve once the server is shutting down.
+var ErrServerClosed = errors.New("drain: server closed")
+
+// Server tracks its listeners and connections so it can drain them.
+type Server struct {
+	// Handler serves a connection. The server closes conn when it returns.
+	Handler func(conn net.Conn)
+
+	// GoingAway, if set, is called on each open connection when Shutdown
+	// starts, concurrently with its Handler. It's where a protocol sends
+	// its goodbye message, or sets a read deadline so an idle Handler
+	// wakes up and finishes.
+	GoingAway func(conn net.Conn)
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closing   bool
+	wg        sync.WaitGroup // Handlers
+}
+
+// Serve accepts connections on l until the server shuts down, and runs
+// Handler on each. It may be called for several listeners at once.
+func (s *Server) Serve(l net.Listener) error {
+	if !s.addListener(l) {
+		_ = l.Close()
+		return ErrServerClosed
+	}
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			s.removeListener(l)
+			return err
+		}
+
+		if !s.addConn(conn) {
+			_ = conn.Close() // Accepted just as the listener closed
+			return ErrServerClosed
+		}
+
+		go func() {
+			defer s.wg.Done()
+			defer s.removeConn(conn)
+			defer func() { _ = conn.Close() }()
+
+			s.Handler(conn)
+		}()
+	}
+}
+
+func (s *Server) addListener(l net.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing {
+		return false
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[l] = struct{}{}
+
+	return true
+}
+
+func (s *Server) removeListener(l net.Listener) {
+	s.mu.Lock()
+	delete(s.listeners, l)
+	s.mu.Unlock()
+}
+
+// addConn tracks conn and counts its handler, unless the server is
+// shutting down. Doing both under s.mu keeps wg.Add from racing wg.Wait.
+func (s *Server) addConn(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+
+	return true
+}
+
+func (s *Server) removeConn(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+}
+
+func (s *Server) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closing
+}
+
+// Active returns the number of open connections.
+func (s *Server) Active() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.conns)
+}
+
+// stop closes the listeners, so Serve stops accepting, and returns the
+// open connections.
+func (s *Server) stop() []net.Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closing = true
+	for l := range s.listeners {
+		_ = l.Close()
+	}
+	s.listeners = nil
+
+	conns := make([]net.Conn, 0, len(s.conns))
+	for conn := range s.conns {
+		conns = append(conns, conn)
+	}
+
+	return conns
+}
+
+// Shutdown stops accepting, calls GoingAway on each open connection and
+// waits for their handlers to return. If ctx ends first, it closes the
+// connections that are left and returns ctx's error.
+func (s *Server) Shutdown(ctx context.Context) error {
+	conns := s.stop()
+
+	if s.GoingAway != nil {
+		for _, conn := range conns {
+			go s.GoingAway(conn) // A slow peer mustn't hold up the others
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		for _, conn := range s.stop() {
+			_ = conn.Close()
+		}
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting and closes every connection right away.
+func (s *Server) Close() error {
+	for _, conn := range s.stop() {
+		_ = conn.Close()
+	}
+
+	return nil
+}
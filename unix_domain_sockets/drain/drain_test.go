@@ -0,0 +1,161 @@
+package drain
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// listen opens a listener on each network the server should handle.
+func listen(t *testing.T, network string) net.Listener {
+	t.Helper()
+
+	addr := "127.0.0.1:"
+	if network == "unix" {
+		addr = filepath.Join(t.TempDir(), "drain.sock")
+	}
+
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return l
+}
+
+// echo echoes lines until the client hangs up.
+func echo(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if _, err = conn.Write([]byte(line)); err != nil {
+			return
+		}
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	for _, network := range []string{"tcp", "unix"} {
+		t.Run(network, func(t *testing.T) {
+			s := &Server{
+				Handler:   echo,
+				GoingAway: func(conn net.Conn) { _, _ = conn.Write([]byte("going away\n")) },
+			}
+			l := listen(t, network)
+
+			served := make(chan error)
+			go func() { served <- s.Serve(l) }()
+
+			conn, err := net.Dial(network, l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := bufio.NewReader(conn)
+
+			_, err = conn.Write([]byte("ping\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line, _ := r.ReadString('\n'); line != "ping\n" {
+				t.Fatalf("expected %q; actual %q", "ping\n", line)
+			}
+
+			shutdown := make(chan error)
+			go func() { shutdown <- s.Shutdown(context.Background()) }()
+
+			if err = <-served; !errors.Is(err, ErrServerClosed) {
+				t.Errorf("expected %v; actual %v", ErrServerClosed, err)
+			}
+			if _, err = net.Dial(network, l.Addr().String()); err == nil {
+				t.Error("dial succeeded after Shutdown")
+			}
+
+			// The connection keeps working until the client is done with it.
+			if line, _ := r.ReadString('\n'); line != "going away\n" {
+				t.Fatalf("expected %q; actual %q", "going away\n", line)
+			}
+			_, err = conn.Write([]byte("last\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line, _ := r.ReadString('\n'); line != "last\n" {
+				t.Fatalf("expected %q; actual %q", "last\n", line)
+			}
+
+			select {
+			case err = <-shutdown:
+				t.Fatalf("Shutdown returned %v with a connection open", err)
+			case <-time.After(50 * time.Millisecond):
+			}
+
+			_ = conn.Close()
+			if err = <-shutdown; err != nil {
+				t.Fatal(err)
+			}
+			if n := s.Active(); n != 0 {
+				t.Errorf("expected no active connections; actual %d", n)
+			}
+		})
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	for _, network := range []string{"tcp", "unix"} {
+		t.Run(network, func(t *testing.T) {
+			s := &Server{Handler: echo} // Never finishes on its own
+			l := listen(t, network)
+			go func() { _ = s.Serve(l) }()
+
+			conn, err := net.Dial(network, l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = conn.Close() }()
+
+			// Wait for the server to pick the connection up.
+			for s.Active() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+			if err = s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("expected %v; actual %v", context.DeadlineExceeded, err)
+			}
+			if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+				t.Errorf("Shutdown returned after %s, before its deadline", elapsed)
+			}
+
+			// The server hung up on the client.
+			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err = conn.Read(make([]byte, 1)); err != io.EOF {
+				t.Errorf("expected %v; actual %v", io.EOF, err)
+			}
+		})
+	}
+}
+
+func TestServeAfterShutdown(t *testing.T) {
+	s := &Server{Handler: echo}
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	l := listen(t, "tcp")
+	if err := s.Serve(l); !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("expected %v; actual %v", ErrServerClosed, err)
+	}
+	if _, err := net.Dial("tcp", l.Addr().String()); err == nil {
+		t.Error("Serve left the listener open")
+	}
+}
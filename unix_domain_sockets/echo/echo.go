@@ -64,11 +64,17 @@ func streamingEchoServer(ctx context.Context, network string, addr string) (net.
 	return s.Addr(), nil // Return the server's listening address.
 }
 
-func datagramEchoServer(ctx context.Context, network string, addr string) (net.Addr, error) {
+// datagramEchoServer echoes datagrams on network and addr. Any wrap
+// functions wrap the socket in turn, e.g. to encrypt it, before the loop
+// sees it.
+func datagramEchoServer(ctx context.Context, network string, addr string, wrap ...func(net.PacketConn) net.PacketConn) (net.Addr, error) {
 	s, err := net.ListenPacket(network, addr) // Listen for incoming packets on the specified network and address
 	if err != nil {
 		return nil, err // Return error if unable to listen
 	}
+	for _, w := range wrap {
+		s = w(s) // Wrap the socket, e.g. secure.New
+	}
 
 	go func() {
 		// This goroutine will close the socket when the context is done
@@ -0,0 +1,186 @@
+// Package batch reads and writes many UDP datagrams per system call. The
+// echo servers make one call per datagram, which caps a busy receiver at
+// however many syscalls it can make. On Linux, recvmmsg and sendmmsg move
+// a whole batch at once, and UDP generic segmentation and receive offload
+// (GSO and GRO) go further, passing one large buffer of equal-sized
+// datagrams through the stack as a single unit.
+//
+// Elsewhere, or on kernels without these, a Conn falls back to one
+// datagram per call, so callers needn't care.
+package batch
+
+import (
+	"net"
+)
+
+const defaultBatchSize = 64
+
+// Message is one datagram, or with GSO and GRO, a run of datagrams of
+// Segment bytes each (the last may be shorter) sharing one buffer.
+type Message struct {
+	Buf  []byte       // Data to write, or space to read into
+	N    int          // Bytes read or written
+	Addr *net.UDPAddr // Sender on read; destination on write, nil if connected
+
+	// Segment, if positive, splits Buf[:N] into datagrams of this size.
+	// ReadBatch sets it when GRO coalesced several datagrams; set it on
+	// WriteBatch to send Buf as several datagrams with GSO.
+	Segment int
+
+	// Truncated is set by ReadBatch when Buf was too small and the rest
+	// of the datagram, or of a coalesced run, was discarded. Buf[:N] is
+	// then only part of it. Not every platform reports it when falling
+	// back to one datagram per call.
+	Truncated bool
+}
+
+// Datagrams returns the datagrams in m, splitting coalesced ones apart.
+func (m *Message) Datagrams() [][]byte {
+	b := m.Buf[:m.N]
+	if m.Segment <= 0 || m.Segment >= len(b) {
+		return [][]byte{b}
+	}
+
+	var d [][]byte
+	for len(b) > 0 {
+		n := min(m.Segment, len(b))
+		d = append(d, b[:n])
+		b = b[n:]
+	}
+
+	return d
+}
+
+// Config tunes a Conn. The zero value batches 64 messages without GRO.
+type Config struct {
+	BatchSize int // Most messages per system call; defaults to 64
+
+	// GRO lets the kernel coalesce datagrams from the same sender into
+	// one Message, with Segment set. Readers must then use Datagrams, and
+	// give each Message a 64 KiB Buf: the kernel coalesces up to that
+	// much, and cuts short any run that doesn't fit, losing the rest.
+	GRO bool
+}
+
+// Conn batches I/O on a UDP socket.
+type Conn struct {
+	*net.UDPConn
+	cfg Config
+	sys sysConn // nil when falling back to one datagram per call
+}
+
+// New wraps conn. It enables GRO if asked and supported.
+func New(conn *net.UDPConn, cfg Config) (*Conn, error) {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+
+	c := &Conn{UDPConn: conn, cfg: cfg}
+	sys, err := newSysConn(conn, cfg)
+	if err != nil {
+		return nil, err
+	}
+	c.sys = sys
+
+	return c, nil
+}
+
+// Batched reports whether the Conn moves several messages per call.
+func (c *Conn) Batched() bool { return c.sys != nil }
+
+// GSO reports whether WriteBatch can send segmented messages in one go.
+// Without it, it sends each segment as its own datagram.
+func (c *Conn) GSO() bool { return c.sys != nil && c.sys.gso() }
+
+// GRO reports whether ReadBatch may return coalesced messages.
+func (c *Conn) GRO() bool { return c.sys != nil && c.sys.gro() }
+
+// ReadBatch reads at least one datagram, and as many more as are already
+// waiting, up to len(msgs) or the batch size. It returns how many
+// messages it filled. The read deadline applies.
+func (c *Conn) ReadBatch(msgs []Message) (int, error) {
+	msgs = msgs[:min(len(msgs), c.cfg.BatchSize)]
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+
+	if c.sys != nil {
+		return c.sys.read(msgs)
+	}
+
+	// Fall back to a datagram per call.
+	n, _, flags, addr, err := c.ReadMsgUDP(msgs[0].Buf, nil)
+	if err != nil {
+		return 0, err
+	}
+	msgs[0].N, msgs[0].Addr, msgs[0].Segment = n, addr, 0
+	msgs[0].Truncated = truncated(flags)
+
+	return 1, nil
+}
+
+// WriteBatch writes msgs, returning how many were sent in full.
+func (c *Conn) WriteBatch(msgs []Message) (int, error) {
+	var sent int
+
+	for len(msgs) > 0 {
+		batch := msgs[:min(len(msgs), c.cfg.BatchSize)]
+
+		var n int
+		var err error
+		if c.sys != nil && (c.sys.gso() || !segmented(batch)) {
+			n, err = c.sys.write(batch)
+		} else {
+			n, err = c.writeEach(batch)
+		}
+		sent += n
+		if err != nil {
+			return sent, err
+		}
+		msgs = msgs[n:]
+	}
+
+	return sent, nil
+}
+
+// writeEach writes one datagram per call, splitting segmented messages.
+func (c *Conn) writeEach(msgs []Message) (int, error) {
+	for i := range msgs {
+		m := &msgs[i]
+		m.N = 0
+
+		seg := Message{Buf: m.Buf, N: len(m.Buf), Segment: m.Segment}
+		for _, d := range seg.Datagrams() {
+			var err error
+			if m.Addr != nil {
+				_, err = c.WriteToUDP(d, m.Addr)
+			} else {
+				_, err = c.Write(d)
+			}
+			if err != nil {
+				return i, err
+			}
+			m.N += len(d)
+		}
+	}
+
+	return len(msgs), nil
+}
+
+func segmented(msgs []Message) bool {
+	for _, m := range msgs {
+		if m.Segment > 0 && m.Segment < len(m.Buf) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sysConn is the platform's batched implementation.
+type sysConn interface {
+	read(msgs []Message) (int, error)
+	write(msgs []Message) (int, error)
+	gso() bool
+	gro() bool
+}
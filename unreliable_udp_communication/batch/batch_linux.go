@@ -0,0 +1,308 @@
+package batch
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// mmsghdr is struct mmsghdr, which x/sys doesn't define: a msghdr and the
+// number of bytes the kernel moved for it.
+type mmsghdr struct {
+	hdr unix.Msghdr
+	n   uint32
+}
+
+// buffers are the kernel structures for one batch, reused across calls.
+type buffers struct {
+	hdrs  []mmsghdr
+	iovs  []unix.Iovec
+	names []unix.RawSockaddrAny
+	ctrl  []byte
+}
+
+func newBuffers(size, ctrlSpace int) buffers {
+	return buffers{
+		hdrs:  make([]mmsghdr, size),
+		iovs:  make([]unix.Iovec, size),
+		names: make([]unix.RawSockaddrAny, size),
+		ctrl:  make([]byte, size*ctrlSpace),
+	}
+}
+
+type mmsgConn struct {
+	rc       syscall.RawConn
+	v6       bool // An AF_INET6 socket, which needs IPv4 addresses mapped
+	gsoOK    bool
+	groOK    bool
+	rmu, wmu sync.Mutex
+	r, w     buffers
+}
+
+var (
+	groSpace = unix.CmsgSpace(4) // The segment size, an int
+	gsoSpace = unix.CmsgSpace(2) // The segment size, a uint16
+)
+
+func newSysConn(conn *net.UDPConn, cfg Config) (sysConn, error) {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	m := &mmsgConn{rc: rc}
+	supported := true
+
+	cErr := rc.Control(func(fd uintptr) {
+		// A zero-length recvmmsg does nothing, except on kernels without
+		// it, where it fails with ENOSYS.
+		_, _, e := unix.Syscall6(unix.SYS_RECVMMSG, fd, 0, 0, unix.MSG_DONTWAIT, 0, 0)
+		if e == unix.ENOSYS {
+			supported = false
+			return
+		}
+
+		domain, dErr := unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_DOMAIN)
+		if dErr != nil {
+			err = os.NewSyscallError("getsockopt SO_DOMAIN", dErr)
+			return
+		}
+		m.v6 = domain == unix.AF_INET6
+
+		// UDP_SEGMENT is readable on kernels that support GSO (4.18+).
+		_, gErr := unix.GetsockoptInt(int(fd), unix.SOL_UDP, unix.UDP_SEGMENT)
+		m.gsoOK = gErr == nil
+
+		if cfg.GRO {
+			m.groOK = unix.SetsockoptInt(int(fd), unix.SOL_UDP, unix.UDP_GRO, 1) == nil
+		}
+	})
+	if cErr != nil {
+		return nil, cErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !supported {
+		return nil, nil
+	}
+
+	m.r = newBuffers(cfg.BatchSize, groSpace)
+	m.w = newBuffers(cfg.BatchSize, gsoSpace)
+
+	return m, nil
+}
+
+func (m *mmsgConn) gso() bool { return m.gsoOK }
+func (m *mmsgConn) gro() bool { return m.groOK }
+
+func (m *mmsgConn) read(msgs []Message) (int, error) {
+	m.rmu.Lock()
+	defer m.rmu.Unlock()
+
+	b := &m.r
+	for i := range msgs {
+		h := &b.hdrs[i].hdr
+		*h = unix.Msghdr{}
+		setIov(&b.iovs[i], msgs[i].Buf)
+		h.Iov = &b.iovs[i]
+		h.SetIovlen(1)
+		h.Name = (*byte)(unsafe.Pointer(&b.names[i]))
+		h.Namelen = unix.SizeofSockaddrAny
+		if m.groOK {
+			h.Control = &b.ctrl[i*groSpace]
+			h.SetControllen(groSpace)
+		}
+	}
+
+	n, err := m.mmsg(m.rc.Read, unix.SYS_RECVMMSG, "recvmmsg", b.hdrs[:len(msgs)])
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range n {
+		msgs[i].N = int(b.hdrs[i].n)
+		msgs[i].Addr = fromSockaddr(&b.names[i])
+		msgs[i].Segment = 0
+		msgs[i].Truncated = truncated(int(b.hdrs[i].hdr.Flags))
+
+		if m.groOK {
+			ctrl := b.ctrl[i*groSpace:][:b.hdrs[i].hdr.Controllen]
+			if seg := groSegment(ctrl); seg > 0 && seg < msgs[i].N {
+				msgs[i].Segment = seg
+			}
+		}
+	}
+
+	return n, nil
+}
+
+func (m *mmsgConn) write(msgs []Message) (int, error) {
+	m.wmu.Lock()
+	defer m.wmu.Unlock()
+
+	b := &m.w
+	for i := range msgs {
+		h := &b.hdrs[i].hdr
+		*h = unix.Msghdr{}
+		setIov(&b.iovs[i], msgs[i].Buf)
+		h.Iov = &b.iovs[i]
+		h.SetIovlen(1)
+
+		if addr := msgs[i].Addr; addr != nil {
+			size, err := toSockaddr(&b.names[i], addr, m.v6)
+			if err != nil {
+				return i, err
+			}
+			h.Name = (*byte)(unsafe.Pointer(&b.names[i]))
+			h.Namelen = size
+		}
+
+		if seg := msgs[i].Segment; seg > 0 && seg < len(msgs[i].Buf) {
+			ctrl := b.ctrl[i*gsoSpace:][:gsoSpace]
+			clear(ctrl)
+			cmsg := (*unix.Cmsghdr)(unsafe.Pointer(&ctrl[0]))
+			cmsg.Level = unix.SOL_UDP
+			cmsg.Type = unix.UDP_SEGMENT
+			cmsg.SetLen(unix.CmsgLen(2))
+			binary.NativeEndian.PutUint16(ctrl[unix.CmsgLen(0):], uint16(seg))
+			h.Control = &ctrl[0]
+			h.SetControllen(gsoSpace)
+		}
+	}
+
+	n, err := m.mmsg(m.rc.Write, unix.SYS_SENDMMSG, "sendmmsg", b.hdrs[:len(msgs)])
+	for i := range n {
+		msgs[i].N = int(b.hdrs[i].n)
+	}
+
+	return n, err
+}
+
+// mmsg makes a recvmmsg or sendmmsg call through the runtime's poller,
+// so it waits for the socket without tying up a thread and honors
+// deadlines.
+func (m *mmsgConn) mmsg(wait func(func(uintptr) bool) error, trap uintptr, name string, hdrs []mmsghdr) (int, error) {
+	var n int
+	var errno syscall.Errno
+
+	err := wait(func(fd uintptr) bool {
+		for {
+			r, _, e := unix.Syscall6(trap, fd, uintptr(unsafe.Pointer(&hdrs[0])), uintptr(len(hdrs)), 0, 0, 0)
+			switch e {
+			case unix.EINTR:
+				continue
+			case unix.EAGAIN:
+				return false // Wait until the socket is ready
+			}
+			n, errno = int(r), e
+			return true
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	if errno != 0 {
+		return 0, os.NewSyscallError(name, errno)
+	}
+
+	return n, nil
+}
+
+// truncated reports whether recvmsg's flags say the datagram didn't fit.
+func truncated(flags int) bool {
+	return flags&unix.MSG_TRUNC != 0
+}
+
+func setIov(iov *unix.Iovec, buf []byte) {
+	*iov = unix.Iovec{}
+	if len(buf) > 0 {
+		iov.Base = &buf[0]
+		iov.SetLen(len(buf))
+	}
+}
+
+// groSegment finds the segment size in a UDP_GRO control message.
+func groSegment(ctrl []byte) int {
+	cmsgs, err := unix.ParseSocketControlMessage(ctrl)
+	if err != nil {
+		return 0
+	}
+	for _, c := range cmsgs {
+		if c.Header.Level == unix.SOL_UDP && c.Header.Type == unix.UDP_GRO && len(c.Data) >= 4 {
+			return int(int32(binary.NativeEndian.Uint32(c.Data)))
+		}
+	}
+
+	return 0
+}
+
+var errAddrFamily = errors.New("batch: IPv6 destination on an IPv4 socket")
+
+// toSockaddr encodes addr for the kernel, returning its length.
+func toSockaddr(sa *unix.RawSockaddrAny, addr *net.UDPAddr, v6 bool) (uint32, error) {
+	*sa = unix.RawSockaddrAny{}
+
+	if !v6 {
+		ip4 := addr.IP.To4()
+		if ip4 == nil {
+			return 0, errAddrFamily
+		}
+		p := (*unix.RawSockaddrInet4)(unsafe.Pointer(sa))
+		p.Family = unix.AF_INET
+		putPort(&p.Port, addr.Port)
+		copy(p.Addr[:], ip4)
+		return unix.SizeofSockaddrInet4, nil
+	}
+
+	p := (*unix.RawSockaddrInet6)(unsafe.Pointer(sa))
+	p.Family = unix.AF_INET6
+	putPort(&p.Port, addr.Port)
+	copy(p.Addr[:], addr.IP.To16()) // IPv4 addresses become v4-mapped
+	if addr.Zone != "" {
+		if ifi, err := net.InterfaceByName(addr.Zone); err == nil {
+			p.Scope_id = uint32(ifi.Index)
+		} else if id, err := strconv.Atoi(addr.Zone); err == nil {
+			p.Scope_id = uint32(id)
+		}
+	}
+
+	return unix.SizeofSockaddrInet6, nil
+}
+
+func fromSockaddr(sa *unix.RawSockaddrAny) *net.UDPAddr {
+	switch sa.Addr.Family {
+	case unix.AF_INET:
+		p := (*unix.RawSockaddrInet4)(unsafe.Pointer(sa))
+		return &net.UDPAddr{IP: net.IPv4(p.Addr[0], p.Addr[1], p.Addr[2], p.Addr[3]), Port: port(&p.Port)}
+	case unix.AF_INET6:
+		p := (*unix.RawSockaddrInet6)(unsafe.Pointer(sa))
+		addr := &net.UDPAddr{IP: append(net.IP(nil), p.Addr[:]...), Port: port(&p.Port)}
+		if p.Scope_id != 0 {
+			addr.Zone = strconv.Itoa(int(p.Scope_id))
+		}
+		return addr
+	}
+
+	return nil
+}
+
+// Ports are in network byte order.
+
+func putPort(p *uint16, port int) {
+	b := (*[2]byte)(unsafe.Pointer(p))
+	b[0], b[1] = byte(port>>8), byte(port)
+}
+
+func port(p *uint16) int {
+	b := (*[2]byte)(unsafe.Pointer(p))
+
+	return int(b[0])<<8 | int(b[1])
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package batch
+
+import "net"
+
+// newSysConn has no batched I/O to offer here.
+func newSysConn(*net.UDPConn, Config) (sysConn, error) {
+	return nil, nil
+}
+
+// truncated can't tell here: the flag differs between platforms, and
+// some report truncation as an error instead.
+func truncated(int) bool {
+	return false
+}
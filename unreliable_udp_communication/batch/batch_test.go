@@ -0,0 +1,194 @@
+package batch
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func listen(t *testing.T, address string, cfg Config) *Conn {
+	t.Helper()
+
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	c, err := New(conn, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+// readAll reads until it has n datagrams.
+func readAll(t *testing.T, c *Conn, n int) [][]byte {
+	t.Helper()
+
+	msgs := make([]Message, 8)
+	for i := range msgs {
+		msgs[i].Buf = make([]byte, 64<<10) // Room for whatever GRO coalesces
+	}
+
+	var got [][]byte
+	_ = c.SetReadDeadline(time.Now().Add(time.Second))
+	for len(got) < n {
+		k, err := c.ReadBatch(msgs)
+		if err != nil {
+			t.Fatalf("after %d datagrams: %v", len(got), err)
+		}
+		for _, m := range msgs[:k] {
+			for _, d := range m.Datagrams() {
+				got = append(got, append([]byte(nil), d...))
+			}
+		}
+	}
+
+	return got
+}
+
+func TestBatch(t *testing.T) {
+	for _, batched := range []bool{true, false} {
+		t.Run(fmt.Sprintf("batched=%t", batched), func(t *testing.T) {
+			sender := listen(t, "127.0.0.1:0", Config{BatchSize: 16})
+			receiver := listen(t, "127.0.0.1:0", Config{})
+			if !batched {
+				sender.sys, receiver.sys = nil, nil
+			}
+
+			msgs := make([]Message, 40) // More than one batch
+			for i := range msgs {
+				msgs[i] = Message{Buf: []byte(fmt.Sprintf("datagram %d", i)), Addr: receiver.LocalAddr().(*net.UDPAddr)}
+			}
+			n, err := sender.WriteBatch(msgs)
+			if err != nil || n != len(msgs) {
+				t.Fatalf("wrote %d of %d: %v", n, len(msgs), err)
+			}
+
+			got := readAll(t, receiver, len(msgs))
+			for i := range msgs {
+				if !bytes.Equal(got[i], msgs[i].Buf) {
+					t.Fatalf("expected %q; actual %q", msgs[i].Buf, got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReadBatchAddr(t *testing.T) {
+	receiver := listen(t, "127.0.0.1:0", Config{})
+	sender := listen(t, "[::]:0", Config{}) // Dual-stack, sending to an IPv4 address
+
+	_, err := sender.WriteBatch([]Message{{Buf: []byte("hi"), Addr: receiver.LocalAddr().(*net.UDPAddr)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := []Message{{Buf: make([]byte, 16)}}
+	_ = receiver.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = receiver.ReadBatch(msgs); err != nil {
+		t.Fatal(err)
+	}
+	if port := sender.LocalAddr().(*net.UDPAddr).Port; msgs[0].Addr.Port != port || !msgs[0].Addr.IP.IsLoopback() {
+		t.Errorf("expected sender 127.0.0.1:%d; actual %s", port, msgs[0].Addr)
+	}
+}
+
+func TestSegmented(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		fallback bool
+		gro      bool
+	}{
+		{"gso", false, false},
+		{"gso+gro", false, true},
+		{"fallback", true, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			sender := listen(t, "127.0.0.1:0", Config{})
+			receiver := listen(t, "127.0.0.1:0", Config{GRO: c.gro})
+			if c.fallback {
+				sender.sys = nil // Splits the buffer itself
+			}
+			t.Logf("GSO %t, GRO %t", sender.GSO(), receiver.GRO())
+
+			// Ten 100-byte datagrams and a 50-byte one, in one buffer.
+			buf := make([]byte, 1050)
+			for i := range buf {
+				buf[i] = byte(i / 100)
+			}
+			msgs := []Message{{Buf: buf, Addr: receiver.LocalAddr().(*net.UDPAddr), Segment: 100}}
+			if _, err := sender.WriteBatch(msgs); err != nil {
+				t.Fatal(err)
+			}
+			if msgs[0].N != len(buf) {
+				t.Errorf("expected %d bytes written; actual %d", len(buf), msgs[0].N)
+			}
+
+			got := readAll(t, receiver, 11)
+			for i, d := range got {
+				if len(d) != 100 && !(i == 10 && len(d) == 50) || d[0] != byte(i) {
+					t.Fatalf("datagram %d: %d bytes starting with %d", i, len(d), d[0])
+				}
+			}
+		})
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	for _, batched := range []bool{true, false} {
+		t.Run(fmt.Sprintf("batched=%t", batched), func(t *testing.T) {
+			sender := listen(t, "127.0.0.1:0", Config{})
+			receiver := listen(t, "127.0.0.1:0", Config{})
+			if !batched {
+				receiver.sys = nil
+			}
+
+			to := receiver.LocalAddr().(*net.UDPAddr)
+			_, err := sender.WriteBatch([]Message{{Buf: make([]byte, 100), Addr: to}, {Buf: []byte("fits"), Addr: to}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			msgs := []Message{{Buf: make([]byte, 10)}, {Buf: make([]byte, 10)}}
+			var got []Message
+			_ = receiver.SetReadDeadline(time.Now().Add(time.Second))
+			for len(got) < 2 {
+				n, err := receiver.ReadBatch(msgs)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, msgs[:n]...)
+			}
+
+			if !got[0].Truncated || got[0].N != 10 {
+				t.Errorf("expected 10 bytes, truncated; actual %d bytes, truncated %t", got[0].N, got[0].Truncated)
+			}
+			if got[1].Truncated || got[1].N != 4 {
+				t.Errorf("expected 4 bytes, whole; actual %d bytes, truncated %t", got[1].N, got[1].Truncated)
+			}
+		})
+	}
+}
+
+func TestDatagrams(t *testing.T) {
+	m := Message{Buf: []byte("aaabbbc--"), N: 7, Segment: 3}
+
+	got := m.Datagrams()
+	if len(got) != 3 || string(got[0]) != "aaa" || string(got[1]) != "bbb" || string(got[2]) != "c" {
+		t.Errorf("expected [aaa bbb c]; actual %q", got)
+	}
+
+	m.Segment = 0
+	if got = m.Datagrams(); len(got) != 1 || string(got[0]) != "aaabbbc" {
+		t.Errorf("expected [aaabbbc]; actual %q", got)
+	}
+}
@@ -0,0 +1,149 @@
+package echo
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"networks/unreliable_udp_communication/batch"
+)
+
+// window is how many datagrams each client keeps in flight, few enough
+// that the socket buffers on loopback never drop any.
+const window = 32
+
+func dialBatch(b *testing.B, addr net.Addr) *batch.Conn {
+	b.Helper()
+
+	conn, err := net.DialUDP("udp", nil, addr.(*net.UDPAddr))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { _ = conn.Close() })
+
+	c, err := batch.New(conn, batch.Config{})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return c
+}
+
+// batchEchoServer is echoServerUDP moving a batch per system call.
+func batchEchoServer(ctx context.Context, b *testing.B) net.Addr {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	s, err := batch.New(conn, batch.Config{})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = s.Close()
+	}()
+
+	go func() {
+		msgs, replies := make([]batch.Message, window), make([]batch.Message, window)
+		for i := range msgs {
+			msgs[i].Buf = make([]byte, 1024)
+		}
+
+		for {
+			n, err := s.ReadBatch(msgs)
+			if err != nil {
+				return
+			}
+
+			for i, m := range msgs[:n] {
+				replies[i] = batch.Message{Buf: m.Buf[:m.N], Addr: m.Addr}
+			}
+			if _, err = s.WriteBatch(replies[:n]); err != nil {
+				return
+			}
+		}
+	}()
+
+	return s.LocalAddr()
+}
+
+// benchmarkSingle sends a window of datagrams and reads their echoes, a
+// system call per datagram.
+func benchmarkSingle(b *testing.B, addr net.Addr) {
+	conn, err := net.Dial("udp", addr.String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	msg, buf := make([]byte, 64), make([]byte, 1024)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Minute))
+
+	b.SetBytes(int64(len(msg)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i += window {
+		for range window {
+			if _, err = conn.Write(msg); err != nil {
+				b.Fatal(err)
+			}
+		}
+		for range window {
+			if _, err = conn.Read(buf); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// benchmarkBatch does the same a batch at a time.
+func benchmarkBatch(b *testing.B, addr net.Addr) {
+	c := dialBatch(b, addr)
+
+	out, in := make([]batch.Message, window), make([]batch.Message, window)
+	for i := range out {
+		out[i].Buf = make([]byte, 64)
+		in[i].Buf = make([]byte, 1024)
+	}
+	_ = c.SetReadDeadline(time.Now().Add(time.Minute))
+
+	b.SetBytes(64)
+	b.ResetTimer()
+	for i := 0; i < b.N; i += window {
+		if _, err := c.WriteBatch(out); err != nil {
+			b.Fatal(err)
+		}
+		for received := 0; received < window; {
+			n, err := c.ReadBatch(in[:window-received])
+			if err != nil {
+				b.Fatal(err)
+			}
+			received += n
+		}
+	}
+}
+
+func BenchmarkEchoServerUDP(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	addr, err := echoServerUDP(ctx, "127.0.0.1:")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("single", func(b *testing.B) { benchmarkSingle(b, addr) })
+	b.Run("batch", func(b *testing.B) { benchmarkBatch(b, addr) })
+}
+
+func BenchmarkBatchEchoServer(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	addr := batchEchoServer(ctx, b)
+
+	b.Run("single", func(b *testing.B) { benchmarkSingle(b, addr) })
+	b.Run("batch", func(b *testing.B) { benchmarkBatch(b, addr) })
+}
@@ -0,0 +1,393 @@
+// Package demux lets a UDP service be written like a TCP server. One
+// socket receives every datagram, and a UDPListener sorts them by sender
+// into sessions, handing each new one out from Accept as a net.Conn whose
+// Reads return that peer's datagrams and whose Writes reply to it.
+//
+// UDP has no handshake or teardown, so a session starts with a peer's
+// first datagram and ends when either side closes it or it goes idle.
+package demux
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultIdleTimeout = 30 * time.Second
+	defaultBacklog     = 128
+	defaultQueueLen    = 64
+
+	maxDatagram = 1 << 16
+)
+
+// ErrIdleTimeout is returned by a session's Read and Write once it has
+// gone IdleTimeout without traffic.
+var ErrIdleTimeout = errors.New("demux: session idle timeout")
+
+// Config tunes the listener. Zero values use the defaults.
+type Config struct {
+	// IdleTimeout ends a session after this long with no datagrams in
+	// either direction. Defaults to 30s.
+	IdleTimeout time.Duration
+
+	// Backlog is how many new sessions may wait for Accept. Datagrams
+	// that would start another are dropped. Defaults to 128.
+	Backlog int
+
+	// QueueLen is how many datagrams each session buffers for Read.
+	// Further datagrams are dropped, as a full socket buffer would.
+	// Defaults to 64.
+	QueueLen int
+}
+
+// Stats counts the listener's sessions and drops.
+type Stats struct {
+	Active   int // Open sessions
+	Accepted int64
+	Expired  int64 // Sessions ended by the idle timeout
+	Dropped  int64 // Datagrams dropped for a full backlog or queue
+}
+
+// UDPListener accepts sessions from peers sending to one PacketConn.
+type UDPListener struct {
+	pc  net.PacketConn
+	cfg Config
+
+	mu       sync.Mutex
+	sessions map[string]*Conn
+	err      error // Why the read loop stopped
+
+	backlog   chan *Conn
+	done      chan struct{}
+	closeOnce sync.Once
+
+	accepted atomic.Int64
+	expired  atomic.Int64
+	dropped  atomic.Int64
+}
+
+// Listen binds a UDP socket and starts accepting sessions on it.
+func Listen(network, address string, cfg Config) (*UDPListener, error) {
+	pc, err := net.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewListener(pc, cfg), nil
+}
+
+// NewListener accepts sessions on pc, which it takes over.
+func NewListener(pc net.PacketConn, cfg Config) *UDPListener {
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = defaultIdleTimeout
+	}
+	if cfg.Backlog <= 0 {
+		cfg.Backlog = defaultBacklog
+	}
+	if cfg.QueueLen <= 0 {
+		cfg.QueueLen = defaultQueueLen
+	}
+
+	l := &UDPListener{
+		pc:       pc,
+		cfg:      cfg,
+		sessions: make(map[string]*Conn),
+		backlog:  make(chan *Conn, cfg.Backlog),
+		done:     make(chan struct{}),
+	}
+
+	go l.readLoop()
+	go l.expireLoop()
+
+	return l
+}
+
+// readLoop routes each datagram to its sender's session, starting one if
+// need be.
+func (l *UDPListener) readLoop() {
+	buf := make([]byte, maxDatagram)
+
+	for {
+		n, addr, err := l.pc.ReadFrom(buf)
+		if err != nil {
+			l.mu.Lock()
+			l.err = err
+			l.mu.Unlock()
+			_ = l.Close()
+			return
+		}
+
+		c := l.session(addr)
+		if c == nil {
+			l.dropped.Add(1)
+			continue
+		}
+		c.touch()
+
+		select {
+		case c.queue <- append([]byte(nil), buf[:n]...):
+		default:
+			l.dropped.Add(1)
+		}
+	}
+}
+
+// session returns addr's session, starting a new one if there's room in
+// the backlog.
+func (l *UDPListener) session(addr net.Addr) *Conn {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if c, ok := l.sessions[addr.String()]; ok {
+		return c
+	}
+
+	c := &Conn{
+		l:                   l,
+		raddr:               addr,
+		queue:               make(chan []byte, l.cfg.QueueLen),
+		readDeadlineChanged: make(chan struct{}),
+		closed:              make(chan struct{}),
+	}
+	c.touch()
+
+	select {
+	case l.backlog <- c:
+	default:
+		return nil
+	}
+	l.sessions[addr.String()] = c
+
+	return c
+}
+
+// expireLoop ends sessions that have gone idle.
+func (l *UDPListener) expireLoop() {
+	ticker := time.NewTicker(l.cfg.IdleTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case now := <-ticker.C:
+			l.mu.Lock()
+			var idle []*Conn
+			for _, c := range l.sessions {
+				if now.Sub(time.Unix(0, c.lastActive.Load())) >= l.cfg.IdleTimeout {
+					idle = append(idle, c)
+				}
+			}
+			l.mu.Unlock()
+
+			for _, c := range idle {
+				if c.end(ErrIdleTimeout) {
+					l.expired.Add(1)
+				}
+			}
+		}
+	}
+}
+
+// Accept waits for the next peer to send its first datagram.
+func (l *UDPListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.backlog:
+		l.accepted.Add(1)
+		return c, nil
+	case <-l.done:
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := l.err
+	if err == nil || errors.Is(err, net.ErrClosed) {
+		err = net.ErrClosed
+	}
+
+	return nil, &net.OpError{Op: "accept", Net: l.Addr().Network(), Addr: l.Addr(), Err: err}
+}
+
+// Close stops accepting, ends every session and closes the socket.
+func (l *UDPListener) Close() error {
+	err := net.ErrClosed
+
+	l.closeOnce.Do(func() {
+		close(l.done)
+		err = l.pc.Close()
+
+		l.mu.Lock()
+		sessions := l.sessions
+		l.sessions = map[string]*Conn{}
+		l.mu.Unlock()
+
+		for _, c := range sessions {
+			c.end(net.ErrClosed)
+		}
+	})
+
+	return err
+}
+
+func (l *UDPListener) Addr() net.Addr { return l.pc.LocalAddr() }
+
+func (l *UDPListener) Stats() Stats {
+	l.mu.Lock()
+	active := len(l.sessions)
+	l.mu.Unlock()
+
+	return Stats{
+		Active:   active,
+		Accepted: l.accepted.Load(),
+		Expired:  l.expired.Load(),
+		Dropped:  l.dropped.Load(),
+	}
+}
+
+// forget removes c so the peer's next datagram starts a new session.
+func (l *UDPListener) forget(c *Conn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.sessions[c.raddr.String()] == c {
+		delete(l.sessions, c.raddr.String())
+	}
+}
+
+// Conn is one peer's session. Each Read returns one datagram, truncated
+// if p is too small, as with a connected UDP socket.
+type Conn struct {
+	l          *UDPListener
+	raddr      net.Addr
+	queue      chan []byte
+	lastActive atomic.Int64 // Unix nanoseconds
+
+	mu                  sync.Mutex
+	readDeadline        time.Time
+	readDeadlineChanged chan struct{}
+	writeDeadline       time.Time
+	err                 error
+	closed              chan struct{}
+}
+
+func (c *Conn) touch() { c.lastActive.Store(time.Now().UnixNano()) }
+
+// end closes the session with err, reporting whether it was still open.
+func (c *Conn) end(err error) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return false
+	}
+	c.err = err
+	close(c.closed)
+	c.l.forget(c)
+
+	return true
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		deadline, changed := c.readDeadline, c.readDeadlineChanged
+		c.mu.Unlock()
+
+		n, retry, err := c.read(p, deadline, changed)
+		if !retry {
+			return n, err
+		}
+	}
+}
+
+// read reports retry when the read deadline changed while waiting.
+func (c *Conn) read(p []byte, deadline time.Time, changed <-chan struct{}) (int, bool, error) {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case b := <-c.queue:
+		return copy(p, b), false, nil
+	case <-c.closed:
+		return 0, false, c.Err()
+	case <-timeout:
+		return 0, false, os.ErrDeadlineExceeded
+	case <-changed:
+		return 0, true, nil
+	}
+}
+
+// Write sends p to the peer as one datagram.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	err, deadline := c.err, c.writeDeadline
+	c.mu.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	c.touch()
+
+	return c.l.pc.WriteTo(p, c.raddr)
+}
+
+// Err returns why the session ended, or nil while it's open.
+func (c *Conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err
+}
+
+// Close ends the session. The shared socket stays open.
+func (c *Conn) Close() error {
+	if !c.end(net.ErrClosed) {
+		return net.ErrClosed
+	}
+
+	return nil
+}
+
+func (c *Conn) LocalAddr() net.Addr  { return c.l.pc.LocalAddr() }
+func (c *Conn) RemoteAddr() net.Addr { return c.raddr }
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	_ = c.SetReadDeadline(t)
+
+	return c.SetWriteDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.readDeadline = t
+	close(c.readDeadlineChanged) // Wake a blocked Read
+	c.readDeadlineChanged = make(chan struct{})
+
+	return nil
+}
+
+// SetWriteDeadline only fails Writes started after t, since the socket is
+// shared and writing a datagram rarely blocks.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeDeadline = t
+
+	return nil
+}
@@ -0,0 +1,178 @@
+package demux
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func listen(t *testing.T, cfg Config) *UDPListener {
+	t.Helper()
+
+	l, err := Listen("udp", "127.0.0.1:", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	return l
+}
+
+func dial(t *testing.T, l *UDPListener) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("udp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+// echo serves sessions like streamingEchoServer serves connections.
+func echo(l *UDPListener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer func() { _ = conn.Close() }()
+
+			buf := make([]byte, 1024)
+			for {
+				n, err := conn.Read(buf)
+				if err != nil {
+					return
+				}
+				if _, err = conn.Write(buf[:n]); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+func TestSessions(t *testing.T) {
+	l := listen(t, Config{})
+	go echo(l)
+
+	clients := []net.Conn{dial(t, l), dial(t, l), dial(t, l)}
+	for round := range 3 {
+		for i, conn := range clients {
+			msg := []byte{byte(i), byte(round)}
+			if _, err := conn.Write(msg); err != nil {
+				t.Fatal(err)
+			}
+
+			buf := make([]byte, 16)
+			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(buf[:n]) != string(msg) {
+				t.Fatalf("expected %v; actual %v", msg, buf[:n])
+			}
+		}
+	}
+
+	if s := l.Stats(); s.Accepted != 3 || s.Active != 3 {
+		t.Errorf("expected 3 sessions; actual %+v", s)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	l := listen(t, Config{IdleTimeout: 50 * time.Millisecond})
+	client := dial(t, l)
+
+	for range 2 {
+		if _, err := client.Write([]byte("hi")); err != nil {
+			t.Fatal(err)
+		}
+		conn, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = conn.Read(make([]byte, 2)); err != nil {
+			t.Fatal(err)
+		}
+
+		// Silence ends the session, and the next datagram starts another.
+		if _, err = conn.Read(make([]byte, 2)); !errors.Is(err, ErrIdleTimeout) {
+			t.Fatalf("expected %v; actual %v", ErrIdleTimeout, err)
+		}
+	}
+
+	if s := l.Stats(); s.Accepted != 2 || s.Expired != 2 || s.Active != 0 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	l := listen(t, Config{QueueLen: 2})
+	client := dial(t, l)
+
+	for i := range 5 {
+		if _, err := client.Write([]byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for l.Stats().Dropped < 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1)
+	for i := range 2 {
+		if _, err = conn.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+		if buf[0] != byte(i) {
+			t.Errorf("expected datagram %d; actual %d", i, buf[0])
+		}
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err = conn.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected %v; actual %v", os.ErrDeadlineExceeded, err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	l, err := Listen("udp", "127.0.0.1:", Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := dial(t, l)
+	if _, err = client.Write([]byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accepted := make(chan error)
+	go func() {
+		_, err := l.Accept()
+		accepted <- err
+	}()
+
+	if err = l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-accepted; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected %v; actual %v", net.ErrClosed, err)
+	}
+	if _, err = conn.Write([]byte("bye")); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected %v; actual %v", net.ErrClosed, err)
+	}
+}
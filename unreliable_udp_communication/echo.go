@@ -10,12 +10,17 @@ import (
 
 // echoServerUDP starts a simple UDP echo server that listens for incoming messages
 // and sends the same message back to the client (echoes it).
-func echoServerUDP(ctx context.Context, addr string) (net.Addr, error) {
+// Any wrap functions wrap the socket in turn, e.g. to encrypt or impair it,
+// before the server loop sees it.
+func echoServerUDP(ctx context.Context, addr string, wrap ...func(net.PacketConn) net.PacketConn) (net.Addr, error) {
 	// Bind to the specified UDP address
 	s, err := net.ListenPacket("udp", addr)
 	if err != nil {
 		return nil, fmt.Errorf("Binding to udp %s: %w", addr, err)
 	}
+	for _, w := range wrap {
+		s = w(s)
+	}
 
 	go func() {
 		go func() {
@@ -24,8 +29,9 @@ func echoServerUDP(ctx context.Context, addr string) (net.Addr, error) {
 		_ = s.Close()
 	}()
 
-	// Buffer to store incoming data
-	buf := make([]byte, 1024)
+	// Buffer to store incoming data, big enough for any datagram so
+	// path MTU probes are echoed whole
+	buf := make([]byte, 65535)
 
 	// Main server loop: handle incoming messages
 	
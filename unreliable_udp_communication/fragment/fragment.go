@@ -0,0 +1,312 @@
+// Package fragment sends messages larger than a safe UDP payload over a
+// PacketConn by splitting them into numbered fragments, each its own
+// datagram, and reassembling them at the receiver. Like IP fragmentation,
+// losing one fragment loses the message, but unlike it, partial messages
+// are dropped on a timeout and a memory cap of our choosing, and the
+// fragments fit the path so no router splits them further.
+package fragment
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"networks/unreliable_udp_communication/pmtu"
+)
+
+// Each fragment starts with a header:
+//
+//	msgID uint32 | index uint16 | count uint16 | payload
+//
+// msgID is unique per sender, index counts from 0 and count is the number
+// of fragments in the message.
+const HeaderSize = 8
+
+const (
+	defaultMaxDatagram = 1200
+	defaultTimeout     = 2 * time.Second
+	defaultMaxPending  = 4 << 20
+	defaultMaxMessages = 1024
+	defaultMaxMessage  = 1 << 20
+
+	// What holding a partial message and each of its fragments costs
+	// beyond the payload, roughly: the struct, map entries and slice
+	// headers. Charging it keeps a flood of empty fragments from holding
+	// memory the cap doesn't see.
+	messageOverhead  = 128
+	fragmentOverhead = 48
+
+	maxCount   = 1<<16 - 1
+	maxReceive = 1 << 16
+)
+
+var ErrMessageTooLarge = errors.New("fragment: message exceeds maximum size")
+
+// Config tunes fragmentation. Zero values use the defaults.
+type Config struct {
+	// MaxDatagram is the largest datagram to send, header included. If
+	// the PacketConn is a connected *net.UDPConn, it defaults to what
+	// fills the path, from pmtu.Recommended; otherwise to 1200 bytes.
+	MaxDatagram int
+
+	// Timeout is how long a partial message waits for its missing
+	// fragments. Defaults to 2s.
+	Timeout time.Duration
+
+	// MaxPending caps the bytes held in partial messages, bookkeeping
+	// included. When it's reached, the oldest are dropped first. Defaults
+	// to 4 MiB.
+	MaxPending int
+
+	// MaxPendingMessages caps how many partial messages are held, the
+	// oldest dropped first. Defaults to 1024.
+	MaxPendingMessages int
+
+	MaxMessage int // Largest message to send or accept; defaults to 1 MiB
+}
+
+// Stats counts fragmentation and reassembly.
+type Stats struct {
+	MessagesSent      int
+	FragmentsSent     int
+	FragmentsReceived int
+	Reassembled       int // Messages received whole
+	Expired           int // Partial messages dropped on the timeout
+	Evicted           int // Partial messages dropped for the memory cap
+	Duplicates        int // Fragments received more than once
+	Malformed         int // Fragments with bad or inconsistent headers, or too big in total
+	Pending           int // Partial messages held now
+	PendingBytes      int // Memory they hold, bookkeeping included
+}
+
+type key struct {
+	addr string
+	id   uint32
+}
+
+type partial struct {
+	key   key
+	count int
+	frags map[int][]byte // Only what's arrived, whatever count claims
+	size  int            // Payload bytes
+	cost  int            // Bytes charged against MaxPending
+	first time.Time
+}
+
+// Conn fragments messages written with WriteTo and reassembles those read
+// with ReadFrom. Both ends must use a Conn.
+type Conn struct {
+	net.PacketConn
+	connected *net.UDPConn // The PacketConn, if it's connected, when WriteTo won't do
+	cfg       Config
+	nextID    atomic.Uint32
+
+	rmu     sync.Mutex // Serializes ReadFrom
+	buf     []byte
+	mu      sync.Mutex
+	pending map[key]*partial
+	order   []*partial // Oldest first
+	stats   Stats
+}
+
+func New(pc net.PacketConn, cfg Config) *Conn {
+	uc, ok := pc.(*net.UDPConn)
+	if !ok || uc.RemoteAddr() == nil {
+		uc = nil
+	}
+
+	if cfg.MaxDatagram <= HeaderSize {
+		cfg.MaxDatagram = defaultMaxDatagram
+		if uc != nil {
+			cfg.MaxDatagram = pmtu.Recommended(uc)
+		}
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.MaxPending <= 0 {
+		cfg.MaxPending = defaultMaxPending
+	}
+	if cfg.MaxPendingMessages <= 0 {
+		cfg.MaxPendingMessages = defaultMaxMessages
+	}
+	if cfg.MaxMessage <= 0 {
+		cfg.MaxMessage = defaultMaxMessage
+	}
+
+	c := &Conn{
+		PacketConn: pc,
+		connected:  uc,
+		cfg:        cfg,
+		buf:        make([]byte, maxReceive),
+		pending:    make(map[key]*partial),
+	}
+	c.nextID.Store(rand.Uint32()) // IDs from an earlier Conn on the same port won't collide
+
+	return c
+}
+
+// WriteTo sends p to addr as one or more fragments. On a connected
+// *net.UDPConn, addr is ignored and p goes to the connected address.
+func (c *Conn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	chunk := c.cfg.MaxDatagram - HeaderSize
+	count := max(1, (len(p)+chunk-1)/chunk)
+	if len(p) > c.cfg.MaxMessage || count > maxCount {
+		return 0, ErrMessageTooLarge
+	}
+
+	id := c.nextID.Add(1)
+	b := make([]byte, c.cfg.MaxDatagram)
+
+	for i := range count {
+		payload := p[i*chunk : min(len(p), (i+1)*chunk)]
+		binary.BigEndian.PutUint32(b[0:], id)
+		binary.BigEndian.PutUint16(b[4:], uint16(i))
+		binary.BigEndian.PutUint16(b[6:], uint16(count))
+		n := copy(b[HeaderSize:], payload)
+
+		var err error
+		if c.connected != nil {
+			_, err = c.connected.Write(b[:HeaderSize+n])
+		} else {
+			_, err = c.PacketConn.WriteTo(b[:HeaderSize+n], addr)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	c.mu.Lock()
+	c.stats.MessagesSent++
+	c.stats.FragmentsSent += count
+	c.mu.Unlock()
+
+	return len(p), nil
+}
+
+// ReadFrom returns the next whole message. As with a datagram, a message
+// larger than p is truncated.
+func (c *Conn) ReadFrom(p []byte) (int, net.Addr, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(c.buf)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if msg := c.add(c.buf[:n], addr, time.Now()); msg != nil {
+			return copy(p, msg), addr, nil
+		}
+	}
+}
+
+// add files a fragment and returns the message if it's now complete.
+func (c *Conn) add(b []byte, addr net.Addr, now time.Time) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats.FragmentsReceived++
+	c.expire(now)
+
+	if len(b) < HeaderSize {
+		c.stats.Malformed++
+		return nil
+	}
+	id := binary.BigEndian.Uint32(b[0:])
+	index := int(binary.BigEndian.Uint16(b[4:]))
+	count := int(binary.BigEndian.Uint16(b[6:]))
+	payload := b[HeaderSize:]
+
+	if index >= count {
+		c.stats.Malformed++
+		return nil
+	}
+	if count == 1 {
+		c.stats.Reassembled++
+		return payload // Unfragmented; no need to hold onto it
+	}
+
+	k := key{addr: addr.String(), id: id}
+	m, ok := c.pending[k]
+	switch {
+	case !ok:
+		m = &partial{key: k, count: count, frags: make(map[int][]byte), first: now, cost: messageOverhead}
+		c.pending[k] = m
+		c.order = append(c.order, m)
+		c.stats.PendingBytes += m.cost
+	case m.count != count:
+		c.drop(m)
+		c.stats.Malformed++
+		return nil
+	default:
+		if _, dup := m.frags[index]; dup {
+			c.stats.Duplicates++
+			return nil
+		}
+	}
+
+	m.frags[index] = append([]byte(nil), payload...)
+	m.size += len(payload)
+	m.cost += fragmentOverhead + len(payload)
+	c.stats.PendingBytes += fragmentOverhead + len(payload)
+
+	if len(m.frags) == count {
+		c.drop(m)
+		c.stats.Reassembled++
+
+		msg := make([]byte, 0, m.size)
+		for i := range count {
+			msg = append(msg, m.frags[i]...)
+		}
+		return msg
+	}
+
+	if m.size > c.cfg.MaxMessage {
+		c.drop(m)
+		c.stats.Malformed++
+		return nil
+	}
+	for len(c.order) > 0 && (c.stats.PendingBytes > c.cfg.MaxPending || len(c.order) > c.cfg.MaxPendingMessages) {
+		c.drop(c.order[0])
+		c.stats.Evicted++
+	}
+
+	return nil
+}
+
+// expire drops partial messages older than the timeout. c.mu must be held.
+func (c *Conn) expire(now time.Time) {
+	for len(c.order) > 0 && now.Sub(c.order[0].first) >= c.cfg.Timeout {
+		c.drop(c.order[0])
+		c.stats.Expired++
+	}
+}
+
+// drop forgets a partial message. c.mu must be held.
+func (c *Conn) drop(m *partial) {
+	delete(c.pending, m.key)
+	c.stats.PendingBytes -= m.cost
+
+	for i, o := range c.order {
+		if o == m {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (c *Conn) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.stats
+	s.Pending = len(c.pending)
+
+	return s
+}
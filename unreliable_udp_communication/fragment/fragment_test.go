@@ -0,0 +1,226 @@
+package fragment
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"networks/unreliable_udp_communication/pmtu"
+)
+
+var addr = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}
+
+// frag builds fragment index of count in message id.
+func frag(id uint32, index, count int, payload string) []byte {
+	b := make([]byte, HeaderSize, HeaderSize+len(payload))
+	binary.BigEndian.PutUint32(b[0:], id)
+	binary.BigEndian.PutUint16(b[4:], uint16(index))
+	binary.BigEndian.PutUint16(b[6:], uint16(count))
+
+	return append(b, payload...)
+}
+
+func TestRoundTrip(t *testing.T) {
+	a, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, receiver := New(a, Config{}), New(b, Config{})
+	defer func() { _ = sender.Close() }()
+	defer func() { _ = receiver.Close() }()
+
+	for _, size := range []int{0, 100, defaultMaxDatagram - HeaderSize, 10000} {
+		msg := make([]byte, size)
+		_, _ = rand.Read(msg)
+
+		if _, err = sender.WriteTo(msg, receiver.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+
+		buf := make([]byte, 1<<16)
+		_ = receiver.SetReadDeadline(time.Now().Add(time.Second))
+		n, from, err := receiver.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf[:n], msg) {
+			t.Fatalf("%d-byte message came back as %d bytes", size, n)
+		}
+		if from.String() != sender.LocalAddr().String() {
+			t.Errorf("expected sender %s; actual %s", sender.LocalAddr(), from)
+		}
+	}
+
+	s := sender.Stats()
+	if s.MessagesSent != 4 || s.FragmentsSent != 1+1+1+9 {
+		t.Errorf("unexpected sender stats %+v", s)
+	}
+	if r := receiver.Stats(); r.Reassembled != 4 || r.Pending != 0 {
+		t.Errorf("unexpected receiver stats %+v", r)
+	}
+
+	if _, err = sender.WriteTo(make([]byte, defaultMaxMessage+1), receiver.LocalAddr()); err != ErrMessageTooLarge {
+		t.Errorf("expected %v; actual %v", ErrMessageTooLarge, err)
+	}
+}
+
+func TestReassembly(t *testing.T) {
+	c := New(nil, Config{})
+	now := time.Now()
+
+	// Out of order, with a duplicate.
+	for _, b := range [][]byte{frag(1, 2, 3, "!"), frag(1, 0, 3, "hello, "), frag(1, 0, 3, "hello, ")} {
+		if msg := c.add(b, addr, now); msg != nil {
+			t.Fatalf("message %q returned before it was whole", msg)
+		}
+	}
+	if msg := c.add(frag(1, 1, 3, "world"), addr, now); string(msg) != "hello, world!" {
+		t.Fatalf("expected %q; actual %q", "hello, world!", msg)
+	}
+
+	// The same ID from another sender is another message.
+	other := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 9}
+	c.add(frag(2, 0, 2, "a"), addr, now)
+	c.add(frag(2, 1, 2, "b"), other, now)
+
+	// Nonsense headers.
+	c.add([]byte{1, 2, 3}, addr, now)
+	c.add(frag(3, 5, 2, "x"), addr, now)
+
+	s := c.Stats()
+	if s.Reassembled != 1 || s.Duplicates != 1 || s.Malformed != 2 || s.Pending != 2 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	c := New(nil, Config{Timeout: time.Second})
+	now := time.Now()
+
+	c.add(frag(1, 0, 2, "stale"), addr, now)
+	if s := c.Stats(); s.Pending != 1 || s.PendingBytes != messageOverhead+fragmentOverhead+5 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	// The rest arrives too late to complete it, and starts over instead.
+	if msg := c.add(frag(1, 1, 2, "late"), addr, now.Add(time.Second)); msg != nil {
+		t.Fatalf("expired message %q was reassembled", msg)
+	}
+
+	s := c.Stats()
+	if s.Expired != 1 || s.Pending != 1 || s.PendingBytes != messageOverhead+fragmentOverhead+4 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestMemoryCap(t *testing.T) {
+	const held = messageOverhead + fragmentOverhead + 6
+	c := New(nil, Config{MaxPending: 2*held - 1})
+	now := time.Now()
+
+	c.add(frag(1, 0, 2, "123456"), addr, now)
+	c.add(frag(2, 0, 2, "abcdef"), addr, now) // One byte over: drop message 1
+
+	s := c.Stats()
+	if s.Evicted != 1 || s.Pending != 1 || s.PendingBytes != held {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	if msg := c.add(frag(1, 1, 2, "7"), addr, now); msg != nil {
+		t.Errorf("evicted message %q was reassembled", msg)
+	}
+	if msg := c.add(frag(2, 1, 2, "g"), addr, now); string(msg) != "abcdefg" {
+		t.Errorf("expected %q; actual %q", "abcdefg", msg)
+	}
+}
+
+// TestEmptyFragments floods the receiver with empty fragments of messages
+// that never finish. The bookkeeping counts against the cap even without
+// payload.
+func TestEmptyFragments(t *testing.T) {
+	const maxPending = 64 << 10
+	c := New(nil, Config{MaxPending: maxPending})
+	now := time.Now()
+
+	for id := range uint32(10000) {
+		for i := range 4 {
+			c.add(frag(id, i, maxCount, ""), addr, now)
+		}
+	}
+
+	s := c.Stats()
+	if s.PendingBytes > maxPending || s.PendingBytes <= 0 {
+		t.Errorf("expected at most %d bytes pending; actual %d", maxPending, s.PendingBytes)
+	}
+	if expected := maxPending / (messageOverhead + 4*fragmentOverhead); s.Pending > expected {
+		t.Errorf("expected at most %d messages pending; actual %d", expected, s.Pending)
+	}
+	if s.Evicted == 0 {
+		t.Error("expected evictions")
+	}
+}
+
+func TestMessageCap(t *testing.T) {
+	c := New(nil, Config{MaxPendingMessages: 2})
+	now := time.Now()
+
+	for id := range uint32(3) {
+		c.add(frag(id, 0, 2, "x"), addr, now)
+	}
+
+	s := c.Stats()
+	if s.Evicted != 1 || s.Pending != 2 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	if msg := c.add(frag(0, 1, 2, "y"), addr, now); msg != nil {
+		t.Errorf("evicted message %q was reassembled", msg)
+	}
+	if msg := c.add(frag(2, 1, 2, "y"), addr, now); string(msg) != "xy" {
+		t.Errorf("expected %q; actual %q", "xy", msg)
+	}
+}
+
+// TestConnected checks a sender on a connected socket sizes its
+// datagrams to the path, and can send on it at all.
+func TestConnected(t *testing.T) {
+	l, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.DialUDP("udp", nil, l.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, receiver := New(conn, Config{}), New(l, Config{})
+	defer func() { _ = sender.Close() }()
+	defer func() { _ = receiver.Close() }()
+
+	if expected := pmtu.Recommended(conn); sender.cfg.MaxDatagram != expected {
+		t.Errorf("expected %d-byte datagrams; actual %d", expected, sender.cfg.MaxDatagram)
+	}
+	if receiver.cfg.MaxDatagram != defaultMaxDatagram {
+		t.Errorf("expected %d-byte datagrams unconnected; actual %d", defaultMaxDatagram, receiver.cfg.MaxDatagram)
+	}
+
+	msg := make([]byte, 3*sender.cfg.MaxDatagram)
+	_, _ = rand.Read(msg)
+	if _, err = sender.WriteTo(msg, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, len(msg))
+	_ = receiver.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := receiver.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], msg) {
+		t.Errorf("%d-byte message came back as %d bytes", len(msg), n)
+	}
+}
@@ -0,0 +1,48 @@
+package echo
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"networks/unreliable_udp_communication/fragment"
+)
+
+// TestFragmentEcho sends a message too big for one datagram through the
+// echo server, which echoes each fragment without knowing it's one.
+func TestFragmentEcho(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serverAddr, err := echoServerUDP(ctx, "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := fragment.New(pc, fragment.Config{MaxDatagram: 512})
+	defer func() { _ = client.Close() }()
+
+	msg := bytes.Repeat([]byte("telemetry "), 500) // 10 fragments
+	if _, err = client.WriteTo(msg, serverAddr); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 2*len(msg))
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	n, addr, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], msg) {
+		t.Errorf("expected %d-byte echo; actual %d bytes", len(msg), n)
+	}
+	if addr.String() != serverAddr.String() {
+		t.Errorf("expected reply from %s; actual %s", serverAddr, addr)
+	}
+}
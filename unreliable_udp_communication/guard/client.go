@@ -0,0 +1,104 @@
+package guard
+
+import (
+	"net"
+	"sync"
+)
+
+// Client talks to servers behind a guarded PacketConn. It keeps the token
+// each server sends and includes it in every datagram to that server.
+//
+// When a retry arrives, Client resends the last datagram it wrote to that
+// server with the new token, so the caller sees a slow reply rather than
+// a lost one. The server may have seen the first copy too, as with any
+// UDP retransmission.
+type Client struct {
+	net.PacketConn
+
+	mu     sync.Mutex
+	tokens map[string][]byte // By server address
+	last   map[string][]byte // The last payload written to each server
+}
+
+func NewClient(pc net.PacketConn) *Client {
+	return &Client{
+		PacketConn: pc,
+		tokens:     make(map[string][]byte),
+		last:       make(map[string][]byte),
+	}
+}
+
+func (c *Client) WriteTo(p []byte, addr net.Addr) (int, error) {
+	key := addr.String()
+
+	c.mu.Lock()
+	token := c.tokens[key]
+	c.last[key] = append(c.last[key][:0], p...)
+	c.mu.Unlock()
+
+	if _, err := c.PacketConn.WriteTo(frame(token, p), addr); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// ReadFrom returns the next reply's payload, handling retries.
+func (c *Client) ReadFrom(p []byte) (int, net.Addr, error) {
+	buf := make([]byte, 1+len(p))
+
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(buf)
+		if err != nil {
+			return 0, addr, err
+		}
+
+		switch b := buf[:n]; {
+		case n >= 1 && b[0] == kindData:
+			return copy(p, b[1:]), addr, nil
+
+		case n == 1+TokenSize && b[0] == kindRetry:
+			if err = c.retry(b[1:], addr); err != nil {
+				return 0, addr, err
+			}
+		}
+	}
+}
+
+// Validated reports whether c holds a token for addr.
+func (c *Client) Validated(addr net.Addr) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.tokens[addr.String()] != nil
+}
+
+func (c *Client) retry(token []byte, addr net.Addr) error {
+	key := addr.String()
+
+	c.mu.Lock()
+	last, ok := c.last[key]
+	if !ok {
+		c.mu.Unlock()
+		return nil // A retry from a server we never wrote to
+	}
+	c.tokens[key] = append([]byte(nil), token...)
+	b := frame(token, last)
+	c.mu.Unlock()
+
+	_, err := c.PacketConn.WriteTo(b, addr)
+
+	return err
+}
+
+func frame(token, p []byte) []byte {
+	if token == nil {
+		return append([]byte{kindData}, p...)
+	}
+
+	b := make([]byte, 0, 1+len(token)+len(p))
+	b = append(b, kindTokened)
+	b = append(b, token...)
+
+	return append(b, p...)
+}
@@ -0,0 +1,527 @@
+// Package guard keeps UDP services from being used as amplification
+// reflectors. A UDP server can't tell whether a datagram's source address
+// is real, so an attacker can spoof a victim's address and have the
+// server flood the victim with replies, like echoServerUDP does for
+// anyone who asks.
+//
+// PacketConn wraps a server's connection. Until a peer proves it can
+// receive at its address, replies to it are capped at AmplificationFactor
+// times the bytes it sent, and it gets a retry token instead of replies
+// beyond that. Client wraps the peer's connection, echoing the token back
+// in each datagram it sends, which validates its address. Tokens are
+// stateless HMACs, so the server keeps no per-peer state it can't lose.
+// Every source prefix is also rate-limited, validated or not.
+package guard
+
+import (
+	"container/list"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTokenLifetime = 2 * time.Minute
+	defaultFactor        = 3 // As in QUIC, RFC 9000 section 8
+	defaultRate          = 100
+	defaultPrefixV4      = 24
+	defaultPrefixV6      = 56
+	defaultMaxPeers      = 4096
+)
+
+// Each datagram starts with a kind byte. Data carries no token, tokened
+// data carries one ahead of the payload, and a retry carries only the
+// token the client should use.
+const (
+	kindData byte = iota
+	kindTokened
+	kindRetry
+)
+
+const (
+	macSize   = 16
+	TokenSize = 8 + macSize // Expiry and MAC
+	retrySize = 1 + TokenSize
+)
+
+// Config tunes the protection. The zero value is usable.
+type Config struct {
+	// Key signs tokens. Servers sharing a key accept each other's tokens.
+	// Defaults to a random key, so tokens die with the server.
+	Key []byte
+
+	TokenLifetime time.Duration // Defaults to 2 minutes
+
+	// AmplificationFactor caps the bytes sent to an unvalidated peer at
+	// this multiple of the bytes received from it. Defaults to 3, so a
+	// peer has to send at least 9 bytes to earn a retry.
+	AmplificationFactor int
+
+	// Retry sends every unvalidated peer a token rather than passing its
+	// datagrams on, so the server does no work for spoofed addresses.
+	Retry bool
+
+	// Rate and Burst limit each source prefix to this many datagrams per
+	// second. Rate defaults to 100 and Burst to Rate; a negative Rate
+	// disables the limit.
+	Rate  int
+	Burst int
+
+	// PrefixV4 and PrefixV6 group sources for rate limiting, since an
+	// attacker usually controls a whole block. They default to /24 and /56.
+	PrefixV4 int
+	PrefixV6 int
+
+	// MaxPeers bounds how many unvalidated peers and prefixes are
+	// tracked. Beyond it, the least recently seen is forgotten. Defaults
+	// to 4096.
+	MaxPeers int
+}
+
+// Stats counts what the guard did.
+type Stats struct {
+	Received      int // Datagrams passed on to the caller
+	Validated     int // Datagrams carrying a valid token
+	Retries       int // Tokens sent
+	RateLimited   int // Datagrams dropped by the prefix limit
+	Blocked       int // Replies dropped by the amplification limit
+	BlockedBytes  int
+	InvalidTokens int
+	Malformed     int
+}
+
+// peer tracks an unvalidated address's amplification budget.
+type peer struct {
+	ip      netip.Addr
+	in, out int
+	seen    time.Time
+	retried bool // Sent a token since the peer's last datagram
+}
+
+// bucket is a token bucket that refuses rather than waits.
+type bucket struct {
+	prefix netip.Prefix
+	tokens float64
+	last   time.Time
+}
+
+// PacketConn guards a server's connection. Datagrams from clients that
+// don't use Client are read as malformed and dropped.
+type PacketConn struct {
+	net.PacketConn
+	cfg Config
+
+	mu        sync.Mutex
+	validated map[netip.Addr]time.Time // Until when
+	peers     map[netip.Addr]*list.Element
+	peerLRU   *list.List // Of *peer, most recently seen first
+	buckets   map[netip.Prefix]*list.Element
+	bucketLRU *list.List // Of *bucket, most recently used first
+	pruner    *time.Timer
+	closed    bool
+	stats     Stats
+}
+
+func NewPacketConn(pc net.PacketConn, cfg Config) *PacketConn {
+	if len(cfg.Key) == 0 {
+		cfg.Key = make([]byte, 32)
+		_, _ = rand.Read(cfg.Key)
+	}
+	if cfg.TokenLifetime <= 0 {
+		cfg.TokenLifetime = defaultTokenLifetime
+	}
+	if cfg.AmplificationFactor <= 0 {
+		cfg.AmplificationFactor = defaultFactor
+	}
+	if cfg.Rate == 0 {
+		cfg.Rate = defaultRate
+	}
+	if cfg.Burst <= 0 {
+		cfg.Burst = max(cfg.Rate, 1)
+	}
+	if cfg.PrefixV4 <= 0 {
+		cfg.PrefixV4 = defaultPrefixV4
+	}
+	if cfg.PrefixV6 <= 0 {
+		cfg.PrefixV6 = defaultPrefixV6
+	}
+	if cfg.MaxPeers <= 0 {
+		cfg.MaxPeers = defaultMaxPeers
+	}
+
+	c := &PacketConn{
+		PacketConn: pc,
+		cfg:        cfg,
+		validated:  make(map[netip.Addr]time.Time),
+		peers:      make(map[netip.Addr]*list.Element),
+		peerLRU:    list.New(),
+		buckets:    make(map[netip.Prefix]*list.Element),
+		bucketLRU:  list.New(),
+	}
+
+	c.mu.Lock()
+	c.pruner = time.AfterFunc(cfg.TokenLifetime, c.prune)
+	c.mu.Unlock()
+
+	return c
+}
+
+// Close stops pruning and closes the underlying connection.
+func (c *PacketConn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.pruner.Stop()
+	c.mu.Unlock()
+
+	return c.PacketConn.Close()
+}
+
+// ReadFrom returns the next datagram that passes the guard, without its
+// header.
+func (c *PacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	buf := make([]byte, 1+TokenSize+len(p))
+
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(buf)
+		if err != nil {
+			return 0, addr, err
+		}
+
+		payload, ok := c.admit(buf[:n], addr)
+		if !ok {
+			continue
+		}
+
+		return copy(p, payload), addr, nil
+	}
+}
+
+// admit decides whether to pass b on, returning its payload, and sends a
+// retry if the peer needs a token.
+func (c *PacketConn) admit(b []byte, addr net.Addr) ([]byte, bool) {
+	ip, isIP := addrIP(addr)
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if isIP && !c.allow(ip, now) {
+		c.stats.RateLimited++
+		return nil, false
+	}
+
+	if len(b) == 0 {
+		c.stats.Malformed++
+		return nil, false
+	}
+
+	if !isIP {
+		// Nobody can spoof a unix socket's address, so there's nothing to
+		// validate, but the header still has to come off.
+		switch {
+		case b[0] == kindData:
+			c.stats.Received++
+			return b[1:], true
+		case b[0] == kindTokened && len(b) >= 1+TokenSize:
+			c.stats.Received++
+			return b[1+TokenSize:], true
+		}
+		c.stats.Malformed++
+		return nil, false
+	}
+
+	switch b[0] {
+	case kindTokened:
+		if len(b) < 1+TokenSize {
+			c.stats.Malformed++
+			return nil, false
+		}
+		if !c.verify(b[1:1+TokenSize], ip, now) {
+			c.stats.InvalidTokens++
+			return nil, false
+		}
+		c.validated[ip] = now.Add(c.cfg.TokenLifetime)
+		c.forget(ip)
+		c.stats.Validated++
+		c.stats.Received++
+		return b[1+TokenSize:], true
+
+	case kindData:
+		if c.isValidated(ip, now) {
+			c.stats.Received++
+			return b[1:], true
+		}
+
+		pr := c.peer(ip, now)
+		pr.in += len(b)
+		pr.retried = false
+
+		if c.cfg.Retry {
+			c.retry(addr, ip, pr, now)
+			return nil, false
+		}
+		c.stats.Received++
+		return b[1:], true
+	}
+
+	c.stats.Malformed++
+	return nil, false
+}
+
+// WriteTo sends p to addr if addr is validated or within its
+// amplification budget. Otherwise it drops p, as the network might, and
+// sends addr a token if the budget allows that much.
+func (c *PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	ip, ok := addrIP(addr)
+	if ok {
+		now := time.Now()
+
+		c.mu.Lock()
+		if !c.isValidated(ip, now) {
+			pr := c.lookup(ip)
+			// Replies leave room in the budget for a retry.
+			if pr == nil || pr.out+1+len(p)+retrySize > c.cfg.AmplificationFactor*pr.in {
+				c.stats.Blocked++
+				c.stats.BlockedBytes += len(p)
+				if pr != nil {
+					c.retry(addr, ip, pr, now)
+				}
+				c.mu.Unlock()
+				return len(p), nil
+			}
+			pr.out += 1 + len(p)
+		}
+		c.mu.Unlock()
+	}
+
+	buf := make([]byte, 1+len(p))
+	buf[0] = kindData
+	copy(buf[1:], p)
+	if _, err := c.PacketConn.WriteTo(buf, addr); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Validated reports whether addr has proven it receives at its address.
+func (c *PacketConn) Validated(addr net.Addr) bool {
+	ip, ok := addrIP(addr)
+	if !ok {
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.isValidated(ip, time.Now())
+}
+
+func (c *PacketConn) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.stats
+}
+
+// retry sends a token to the peer, once per datagram it sends, if its
+// budget allows. c.mu must be held.
+func (c *PacketConn) retry(addr net.Addr, ip netip.Addr, pr *peer, now time.Time) {
+	if pr.retried || pr.out+retrySize > c.cfg.AmplificationFactor*pr.in {
+		return
+	}
+	pr.out += retrySize
+	pr.retried = true
+
+	buf := make([]byte, retrySize)
+	buf[0] = kindRetry
+	c.token(buf[1:], ip, now.Add(c.cfg.TokenLifetime))
+	if _, err := c.PacketConn.WriteTo(buf, addr); err == nil {
+		c.stats.Retries++
+	}
+}
+
+// token writes a token for ip expiring at expiry into b.
+func (c *PacketConn) token(b []byte, ip netip.Addr, expiry time.Time) {
+	binary.BigEndian.PutUint64(b, uint64(expiry.Unix()))
+	copy(b[8:], c.mac(b[:8], ip))
+}
+
+func (c *PacketConn) verify(token []byte, ip netip.Addr, now time.Time) bool {
+	expiry := time.Unix(int64(binary.BigEndian.Uint64(token)), 0)
+	if now.After(expiry) || expiry.After(now.Add(c.cfg.TokenLifetime)) {
+		return false
+	}
+
+	return hmac.Equal(token[8:], c.mac(token[:8], ip))
+}
+
+// mac binds a token to the peer's IP address but not its port, so a
+// token survives a NAT rebinding the peer's port.
+func (c *PacketConn) mac(expiry []byte, ip netip.Addr) []byte {
+	h := hmac.New(sha256.New, c.cfg.Key)
+	h.Write(expiry)
+	b, _ := ip.MarshalBinary()
+	h.Write(b)
+
+	return h.Sum(nil)[:macSize]
+}
+
+// isValidated reports whether ip validated recently. c.mu must be held.
+func (c *PacketConn) isValidated(ip netip.Addr, now time.Time) bool {
+	until, ok := c.validated[ip]
+	if ok && now.After(until) {
+		delete(c.validated, ip)
+		return false
+	}
+
+	return ok
+}
+
+// peer returns ip's budget, tracking it from now on. c.mu must be held.
+func (c *PacketConn) peer(ip netip.Addr, now time.Time) *peer {
+	if e := c.peers[ip]; e != nil {
+		c.peerLRU.MoveToFront(e)
+		pr := e.Value.(*peer)
+		pr.seen = now
+		return pr
+	}
+
+	if len(c.peers) >= c.cfg.MaxPeers {
+		// Under a flood of fresh addresses. Forgetting the stalest peer
+		// costs it its budget, which it earns back by sending again.
+		c.forget(c.peerLRU.Back().Value.(*peer).ip)
+	}
+	pr := &peer{ip: ip, seen: now}
+	c.peers[ip] = c.peerLRU.PushFront(pr)
+
+	return pr
+}
+
+// lookup returns ip's budget, or nil if it isn't tracked. c.mu must be
+// held.
+func (c *PacketConn) lookup(ip netip.Addr) *peer {
+	if e := c.peers[ip]; e != nil {
+		return e.Value.(*peer)
+	}
+
+	return nil
+}
+
+// forget stops tracking ip's budget. c.mu must be held.
+func (c *PacketConn) forget(ip netip.Addr) {
+	if e := c.peers[ip]; e != nil {
+		c.peerLRU.Remove(e)
+		delete(c.peers, ip)
+	}
+}
+
+// prune forgets peers quiet for a token lifetime, validations that have
+// expired, and prefixes whose buckets have refilled, which are no
+// different from new ones. It runs every token lifetime, off the read
+// path.
+func (c *PacketConn) prune() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+	now := time.Now()
+
+	for e := c.peerLRU.Back(); e != nil; e = c.peerLRU.Back() {
+		pr := e.Value.(*peer)
+		if now.Sub(pr.seen) <= c.cfg.TokenLifetime {
+			break
+		}
+		c.forget(pr.ip)
+	}
+	for ip, until := range c.validated {
+		if now.After(until) {
+			delete(c.validated, ip)
+		}
+	}
+	if c.cfg.Rate > 0 {
+		full := time.Duration(float64(c.cfg.Burst) / float64(c.cfg.Rate) * float64(time.Second))
+		for e := c.bucketLRU.Back(); e != nil; e = c.bucketLRU.Back() {
+			b := e.Value.(*bucket)
+			if now.Sub(b.last) <= full {
+				break
+			}
+			c.bucketLRU.Remove(e)
+			delete(c.buckets, b.prefix)
+		}
+	}
+
+	c.pruner.Reset(c.cfg.TokenLifetime)
+}
+
+// allow takes a token from ip's prefix bucket. c.mu must be held.
+func (c *PacketConn) allow(ip netip.Addr, now time.Time) bool {
+	if c.cfg.Rate < 0 {
+		return true
+	}
+
+	bits := c.cfg.PrefixV6
+	if ip.Is4() {
+		bits = c.cfg.PrefixV4
+	}
+	prefix, err := ip.Prefix(min(bits, ip.BitLen()))
+	if err != nil {
+		return false
+	}
+
+	var b *bucket
+	if e := c.buckets[prefix]; e != nil {
+		c.bucketLRU.MoveToFront(e)
+		b = e.Value.(*bucket)
+	} else {
+		if len(c.buckets) >= c.cfg.MaxPeers {
+			// Forget the stalest prefix rather than refuse a new one, so
+			// spoofed prefixes can't lock everyone else out. The stalest
+			// bucket has had the longest to refill anyway.
+			stalest := c.bucketLRU.Back()
+			c.bucketLRU.Remove(stalest)
+			delete(c.buckets, stalest.Value.(*bucket).prefix)
+		}
+		b = &bucket{prefix: prefix, tokens: float64(c.cfg.Burst), last: now}
+		c.buckets[prefix] = c.bucketLRU.PushFront(b)
+	}
+
+	if c.refill(b, now) < 1 {
+		return false
+	}
+	b.tokens--
+
+	return true
+}
+
+func (c *PacketConn) refill(b *bucket, now time.Time) float64 {
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*float64(c.cfg.Rate), float64(c.cfg.Burst))
+	b.last = now
+
+	return b.tokens
+}
+
+// addrIP returns addr's IP address, unmapping IPv4-mapped IPv6 addresses
+// so a dual-stack socket sees one peer, not two.
+func addrIP(addr net.Addr) (netip.Addr, bool) {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.IPAddr:
+		ip = a.IP
+	default:
+		return netip.Addr{}, false
+	}
+
+	nip, ok := netip.AddrFromSlice(ip)
+
+	return nip.Unmap(), ok
+}
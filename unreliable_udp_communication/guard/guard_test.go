@@ -0,0 +1,330 @@
+package guard
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// serve replies to each datagram with copies copies of it, an amplifier
+// like echoServerUDP when copies is 1.
+func serve(pc net.PacketConn, copies int) {
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		for range copies {
+			if _, err = pc.WriteTo(buf[:n], addr); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func server(t *testing.T, cfg Config, copies int) *PacketConn {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewPacketConn(pc, cfg)
+	t.Cleanup(func() { _ = s.Close() })
+	go serve(s, copies)
+
+	return s
+}
+
+func listen(t *testing.T) net.PacketConn {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = pc.Close() })
+
+	return pc
+}
+
+// drain reads datagrams until none arrive for a while, returning how many
+// and their total size.
+func drain(t *testing.T, pc net.PacketConn) (count, size int) {
+	t.Helper()
+
+	buf := make([]byte, 2048)
+	for {
+		_ = pc.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := pc.ReadFrom(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return count, size
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		count++
+		size += n
+	}
+}
+
+func TestAmplificationLimit(t *testing.T) {
+	s := server(t, Config{}, 10)
+
+	// A client that ignores tokens, as a spoofed victim would.
+	victim := listen(t)
+	req := append([]byte{kindData}, bytes.Repeat([]byte("x"), 99)...)
+	if _, err := victim.WriteTo(req, s.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	_, size := drain(t, victim)
+	if limit := defaultFactor * len(req); size > limit || size == 0 {
+		t.Errorf("expected between 1 and %d bytes; actual %d", limit, size)
+	}
+
+	st := s.Stats()
+	if st.Blocked == 0 || st.Retries != 1 {
+		t.Errorf("expected blocked replies and 1 retry; actual %+v", st)
+	}
+	if s.Validated(victim.LocalAddr()) {
+		t.Error("victim validated without a token")
+	}
+}
+
+func TestClientValidates(t *testing.T) {
+	s := server(t, Config{}, 10)
+
+	client := NewClient(listen(t))
+	msg := bytes.Repeat([]byte("y"), 100)
+	if _, err := client.WriteTo(msg, s.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first few replies fit the budget, the retry validates the client
+	// and its resent request is answered in full.
+	count, size := drain(t, client)
+	if count < 10 || size != count*len(msg) {
+		t.Errorf("expected at least 10 %d-byte replies; actual %d totaling %d bytes", len(msg), count, size)
+	}
+	if !client.Validated(s.LocalAddr()) {
+		t.Error("client has no token")
+	}
+	if !s.Validated(client.LocalAddr()) {
+		t.Error("server didn't validate the client")
+	}
+
+	// Once validated, nothing is held back.
+	before := s.Stats()
+	if _, err := client.WriteTo(msg, s.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ = drain(t, client); count != 10 {
+		t.Errorf("expected 10 replies; actual %d", count)
+	}
+	if after := s.Stats(); after.Blocked != before.Blocked || after.Retries != before.Retries {
+		t.Errorf("validated client was limited: %+v", after)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	s := server(t, Config{Retry: true}, 1)
+
+	// Without a token, the server sees nothing and sends only the retry.
+	raw := listen(t)
+	if _, err := raw.WriteTo(append([]byte{kindData}, "hello, udp"...), s.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if count, size := drain(t, raw); count != 1 || size != 1+TokenSize {
+		t.Errorf("expected a retry; actual %d datagrams totaling %d bytes", count, size)
+	}
+	if st := s.Stats(); st.Received != 0 {
+		t.Errorf("server passed on %d unvalidated datagrams", st.Received)
+	}
+
+	client := NewClient(listen(t))
+	_ = client.SetDeadline(time.Now().Add(time.Second))
+	if _, err := client.WriteTo([]byte("hello, udp"), s.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, _, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := string(buf[:n]); actual != "hello, udp" {
+		t.Errorf("expected %q; actual %q", "hello, udp", actual)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	s := server(t, Config{Rate: 10, Burst: 10}, 1)
+
+	// Two clients in the same /24 share a budget.
+	a, b := listen(t), listen(t)
+	for i := range 50 {
+		pc := a
+		if i%2 == 1 {
+			pc = b
+		}
+		if _, err := pc.WriteTo([]byte{kindData, byte(i)}, s.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	drain(t, a)
+
+	if st := s.Stats(); st.RateLimited < 35 || st.Received > 15 {
+		t.Errorf("expected about 10 received and 40 rate limited; actual %+v", st)
+	}
+}
+
+func TestToken(t *testing.T) {
+	c := NewPacketConn(nil, Config{Key: []byte("key"), TokenLifetime: time.Minute})
+	ip := netip.MustParseAddr("192.0.2.1")
+	now := time.Now()
+
+	token := make([]byte, TokenSize)
+	c.token(token, ip, now.Add(time.Minute))
+
+	if !c.verify(token, ip, now) {
+		t.Error("valid token rejected")
+	}
+	if c.verify(token, netip.MustParseAddr("192.0.2.2"), now) {
+		t.Error("token accepted from another address")
+	}
+	if c.verify(token, ip, now.Add(2*time.Minute)) {
+		t.Error("expired token accepted")
+	}
+
+	other := NewPacketConn(nil, Config{Key: []byte("other key")})
+	if other.verify(token, ip, now) {
+		t.Error("token accepted under another key")
+	}
+
+	token[len(token)-1] ^= 1
+	if c.verify(token, ip, now) {
+		t.Error("tampered token accepted")
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	s := server(t, Config{}, 1)
+
+	raw := listen(t)
+	for _, b := range [][]byte{{}, {kindTokened, 1, 2}, {kindRetry}, {0xff, 'x'}} {
+		if _, err := raw.WriteTo(b, s.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count, _ := drain(t, raw); count != 0 {
+		t.Errorf("expected no replies; actual %d", count)
+	}
+	if st := s.Stats(); st.Malformed != 4 {
+		t.Errorf("expected 4 malformed; actual %d", st.Malformed)
+	}
+}
+
+// TestUnixgram checks the header comes off datagrams from addresses that
+// aren't IP, where there's nothing to validate.
+func TestUnixgram(t *testing.T) {
+	dir := t.TempDir()
+
+	pc, err := net.ListenPacket("unixgram", filepath.Join(dir, "server.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewPacketConn(pc, Config{Retry: true})
+	defer func() { _ = s.Close() }()
+	go serve(s, 1)
+
+	cc, err := net.ListenPacket("unixgram", filepath.Join(dir, "client.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(cc)
+	defer func() { _ = client.Close() }()
+
+	buf := make([]byte, 64)
+	for _, msg := range []string{"hello", "again"} {
+		if _, err = client.WriteTo([]byte(msg), s.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		_ = client.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := client.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := string(buf[:n]); actual != msg {
+			t.Errorf("expected %q; actual %q", msg, actual)
+		}
+	}
+
+	if st := s.Stats(); st.Received != 2 || st.Retries != 0 || st.Malformed != 0 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+}
+
+// TestEviction fills the tables with spoofed sources; newcomers push out
+// the stalest rather than being turned away.
+func TestEviction(t *testing.T) {
+	c := NewPacketConn(nil, Config{MaxPeers: 2, Rate: 1, Burst: 1})
+	now := time.Now()
+
+	for i, s := range []string{"198.51.100.1", "203.0.113.1", "192.0.2.1"} {
+		ip := netip.MustParseAddr(s)
+		at := now.Add(time.Duration(i) * time.Millisecond)
+		if !c.allow(ip, at) {
+			t.Errorf("%s refused", ip)
+		}
+		c.peer(ip, at)
+	}
+
+	if len(c.buckets) != 2 || len(c.peers) != 2 {
+		t.Fatalf("expected 2 buckets and peers; actual %d and %d", len(c.buckets), len(c.peers))
+	}
+	if _, ok := c.peers[netip.MustParseAddr("198.51.100.1")]; ok {
+		t.Error("the stalest peer wasn't evicted")
+	}
+	if c.allow(netip.MustParseAddr("192.0.2.2"), now.Add(3*time.Millisecond)) {
+		t.Error("a tracked prefix's empty bucket allowed another datagram")
+	}
+}
+
+// TestPrune checks that the timer forgets quiet peers and prefixes whose
+// buckets have refilled, leaving active ones alone.
+func TestPrune(t *testing.T) {
+	c := NewPacketConn(nil, Config{TokenLifetime: time.Minute, Rate: 1, Burst: 1})
+	defer c.pruner.Stop()
+
+	stale, fresh := netip.MustParseAddr("198.51.100.1"), netip.MustParseAddr("203.0.113.1")
+	now := time.Now()
+
+	c.mu.Lock()
+	c.allow(stale, now.Add(-2*time.Minute))
+	c.peer(stale, now.Add(-2*time.Minute))
+	c.allow(fresh, now)
+	c.peer(fresh, now)
+	c.validated[stale] = now.Add(-time.Second)
+	c.mu.Unlock()
+
+	c.prune()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.peers) != 1 || c.lookup(fresh) == nil {
+		t.Errorf("expected only %s tracked; actual %d peers", fresh, len(c.peers))
+	}
+	if len(c.buckets) != 1 || c.bucketLRU.Len() != 1 {
+		t.Errorf("expected 1 bucket; actual %d", len(c.buckets))
+	}
+	if len(c.validated) != 0 {
+		t.Errorf("expected the expired validation pruned; actual %d", len(c.validated))
+	}
+}
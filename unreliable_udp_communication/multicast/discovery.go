@@ -0,0 +1,220 @@
+package multicast
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultInterval = time.Second
+	lifetimes       = 3 // Missed announcements before a service is forgotten
+	maxAnnouncement = 8192
+
+	defaultMaxTTL     = time.Minute
+	defaultMaxEntries = 1024
+)
+
+// Service is what a service announces about itself.
+type Service struct {
+	Name         string   `json:"name"`
+	Address      string   `json:"address"` // Where to reach it, such as "10.0.0.5:8080"
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// announcement is the JSON datagram sent to the group.
+type announcement struct {
+	Service
+
+	// TTL is how long listeners should remember the service. Zero means
+	// it's going away.
+	TTL time.Duration `json:"ttl"`
+}
+
+// Announcer advertises a service to a group.
+type Announcer struct {
+	Conn     *net.UDPConn // From Dial
+	Service  Service
+	Interval time.Duration // Between announcements; defaults to 1s
+}
+
+// Run announces the service right away and then every Interval, each
+// announcement good for three intervals. When ctx is done, it says
+// goodbye so registries drop the service at once.
+func (a *Announcer) Run(ctx context.Context) error {
+	interval := a.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := a.send(lifetimes * interval); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return a.send(0)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *Announcer) send(ttl time.Duration) error {
+	b, err := json.Marshal(announcement{Service: a.Service, TTL: ttl})
+	if err != nil {
+		return err
+	}
+	_, err = a.Conn.Write(b)
+
+	return err
+}
+
+// Registry remembers the services announced to a group until their
+// announcements expire. The zero value is ready to use. Anyone on the
+// group can announce, so the limits keep a misbehaving host from pinning
+// entries forever or filling memory.
+type Registry struct {
+	// MaxTTL caps how long an announcement is believed, whatever TTL it
+	// claims. Defaults to a minute.
+	MaxTTL time.Duration
+
+	// MaxEntries caps how many services are remembered. Beyond it, the
+	// one announced least recently is forgotten. Defaults to 1024.
+	MaxEntries int
+
+	mu      sync.Mutex
+	entries map[serviceKey]entry
+}
+
+type serviceKey struct {
+	name, address string
+}
+
+type entry struct {
+	Service
+	seen    time.Time // Last announced
+	expires time.Time
+}
+
+// Run reads announcements from conn, which came from Listen, until ctx
+// is done. It closes conn.
+func (r *Registry) Run(ctx context.Context, conn *net.UDPConn) error {
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+
+	buf := make([]byte, maxAnnouncement)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil && errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		var a announcement
+		if json.Unmarshal(buf[:n], &a) != nil || a.Name == "" {
+			continue // Not one of ours
+		}
+		r.update(a, time.Now())
+	}
+}
+
+func (r *Registry) update(a announcement, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.entries == nil {
+		r.entries = make(map[serviceKey]entry)
+	}
+
+	k := serviceKey{name: a.Name, address: a.Address}
+	if a.TTL <= 0 {
+		delete(r.entries, k)
+		return
+	}
+
+	maxTTL := r.MaxTTL
+	if maxTTL <= 0 {
+		maxTTL = defaultMaxTTL
+	}
+	if _, ok := r.entries[k]; !ok {
+		r.makeRoom(now)
+	}
+	r.entries[k] = entry{Service: a.Service, seen: now, expires: now.Add(min(a.TTL, maxTTL))}
+}
+
+// makeRoom drops expired entries if the registry is full, and then the
+// stalest one if it still is. r.mu must be held.
+func (r *Registry) makeRoom(now time.Time) {
+	maxEntries := r.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultMaxEntries
+	}
+	if len(r.entries) < maxEntries {
+		return
+	}
+
+	var oldest serviceKey
+	var seen time.Time
+	for k, e := range r.entries {
+		if !now.Before(e.expires) {
+			delete(r.entries, k)
+			continue
+		}
+		if seen.IsZero() || e.seen.Before(seen) {
+			oldest, seen = k, e.seen
+		}
+	}
+	if len(r.entries) >= maxEntries {
+		delete(r.entries, oldest)
+	}
+}
+
+// Services returns the services currently announced, sorted by name and
+// address, dropping those whose announcements have expired.
+func (r *Registry) Services() []Service {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	services := make([]Service, 0, len(r.entries))
+	for k, e := range r.entries {
+		if !now.Before(e.expires) {
+			delete(r.entries, k)
+			continue
+		}
+		services = append(services, e.Service)
+	}
+
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Name != services[j].Name {
+			return services[i].Name < services[j].Name
+		}
+		return services[i].Address < services[j].Address
+	})
+
+	return services
+}
+
+// Lookup returns the current services called name.
+func (r *Registry) Lookup(name string) []Service {
+	var found []Service
+	for _, s := range r.Services() {
+		if s.Name == name {
+			found = append(found, s)
+		}
+	}
+
+	return found
+}
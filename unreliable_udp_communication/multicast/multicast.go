@@ -0,0 +1,85 @@
+// Package multicast sends and receives IPv4 and IPv6 multicast: one
+// datagram to a group address reaches every host that has joined it. The
+// group's reach is set by the hop limit, and by default stays on the local
+// network.
+//
+// On top of that, Announcer and Registry make a small discovery protocol.
+// Services announce their name, address and capabilities to a group every
+// so often, and registries listening to it keep a list of those heard
+// from recently.
+package multicast
+
+import (
+	"net"
+)
+
+const defaultHopLimit = 1 // Don't leave the local network
+
+// Options choose where datagrams go. The zero value uses the system's
+// default interface, a hop limit of 1 and loopback to local listeners.
+type Options struct {
+	// Interfaces to join the group on when listening. Dial sends from the
+	// first. Empty means the system's choice.
+	Interfaces []*net.Interface
+
+	// HopLimit is the IPv4 TTL or IPv6 hop limit of datagrams sent.
+	// Each router along the way decrements it. Defaults to 1.
+	HopLimit int
+
+	// NoLoopback keeps datagrams sent from reaching listeners on this
+	// host.
+	NoLoopback bool
+}
+
+func (o Options) iface() *net.Interface {
+	if len(o.Interfaces) == 0 {
+		return nil
+	}
+
+	return o.Interfaces[0]
+}
+
+// Listen joins group on each of the interfaces and returns a connection
+// that receives the group's datagrams. If group has port 0, a port is
+// picked; dial the connection's LocalAddr to send to it.
+func Listen(network string, group *net.UDPAddr, opts Options) (*net.UDPConn, error) {
+	conn, err := net.ListenMulticastUDP(network, opts.iface(), group)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 1; i < len(opts.Interfaces); i++ {
+		if err = join(conn, opts.Interfaces[i], group.IP); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// Dial returns a connection that sends to group.
+func Dial(network string, group *net.UDPAddr, opts Options) (*net.UDPConn, error) {
+	if opts.HopLimit <= 0 {
+		opts.HopLimit = defaultHopLimit
+	}
+
+	// Link-local groups, such as ff02::/16, are only meaningful on an
+	// interface.
+	if ifi := opts.iface(); ifi != nil && group.Zone == "" && group.IP.IsLinkLocalMulticast() {
+		group = &net.UDPAddr{IP: group.IP, Port: group.Port, Zone: ifi.Name}
+	}
+
+	conn, err := net.DialUDP(network, nil, group)
+	if err != nil {
+		return nil, err
+	}
+
+	err = configure(conn, opts.iface(), opts.HopLimit, !opts.NoLoopback)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
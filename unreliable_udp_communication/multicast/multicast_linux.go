@@ -0,0 +1,91 @@
+package multicast
+
+import (
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// join adds conn to group on ifi as well (IP_ADD_MEMBERSHIP or
+// IPV6_JOIN_GROUP).
+func join(conn *net.UDPConn, ifi *net.Interface, group net.IP) error {
+	c, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var name string
+	cErr := c.Control(func(fd uintptr) {
+		if ip4 := group.To4(); ip4 != nil {
+			name = "IP_ADD_MEMBERSHIP"
+			mreq := &unix.IPMreqn{Ifindex: int32(ifi.Index)}
+			copy(mreq.Multiaddr[:], ip4)
+			err = unix.SetsockoptIPMreqn(int(fd), unix.IPPROTO_IP, unix.IP_ADD_MEMBERSHIP, mreq)
+		} else {
+			name = "IPV6_JOIN_GROUP"
+			mreq := &unix.IPv6Mreq{Interface: uint32(ifi.Index)}
+			copy(mreq.Multiaddr[:], group.To16())
+			err = unix.SetsockoptIPv6Mreq(int(fd), unix.IPPROTO_IPV6, unix.IPV6_JOIN_GROUP, mreq)
+		}
+	})
+	if cErr != nil {
+		return cErr
+	}
+
+	return os.NewSyscallError("setsockopt "+name, err)
+}
+
+// configure sets the sending interface, hop limit and loopback.
+func configure(conn *net.UDPConn, ifi *net.Interface, hops int, loop bool) error {
+	c, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	v4 := conn.RemoteAddr().(*net.UDPAddr).IP.To4() != nil
+	loopback := 0
+	if loop {
+		loopback = 1
+	}
+
+	var name string
+	cErr := c.Control(func(raw uintptr) {
+		fd := int(raw)
+
+		if v4 {
+			if ifi != nil {
+				name = "IP_MULTICAST_IF"
+				err = unix.SetsockoptIPMreqn(fd, unix.IPPROTO_IP, unix.IP_MULTICAST_IF, &unix.IPMreqn{Ifindex: int32(ifi.Index)})
+				if err != nil {
+					return
+				}
+			}
+			name = "IP_MULTICAST_TTL"
+			if err = unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_MULTICAST_TTL, hops); err != nil {
+				return
+			}
+			name = "IP_MULTICAST_LOOP"
+			err = unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_MULTICAST_LOOP, loopback)
+			return
+		}
+
+		if ifi != nil {
+			name = "IPV6_MULTICAST_IF"
+			if err = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_IF, ifi.Index); err != nil {
+				return
+			}
+		}
+		name = "IPV6_MULTICAST_HOPS"
+		if err = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_HOPS, hops); err != nil {
+			return
+		}
+		name = "IPV6_MULTICAST_LOOP"
+		err = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_LOOP, loopback)
+	})
+	if cErr != nil {
+		return cErr
+	}
+
+	return os.NewSyscallError("setsockopt "+name, err)
+}
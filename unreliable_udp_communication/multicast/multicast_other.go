@@ -0,0 +1,22 @@
+//go:build !linux
+
+package multicast
+
+import (
+	"errors"
+	"net"
+)
+
+// join isn't supported here, so Listen joins only the first interface.
+func join(*net.UDPConn, *net.Interface, net.IP) error {
+	return errors.ErrUnsupported
+}
+
+// configure can only leave the system defaults alone.
+func configure(_ *net.UDPConn, ifi *net.Interface, hops int, loop bool) error {
+	if ifi != nil || hops != defaultHopLimit || !loop {
+		return errors.ErrUnsupported
+	}
+
+	return nil
+}
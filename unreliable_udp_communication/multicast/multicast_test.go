@@ -0,0 +1,170 @@
+package multicast
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+)
+
+var groups = []struct {
+	network string
+	group   *net.UDPAddr
+}{
+	{"udp4", &net.UDPAddr{IP: net.IPv4(239, 255, 0, 114)}},
+	{"udp6", &net.UDPAddr{IP: net.ParseIP("ff02::114")}},
+}
+
+// loopback returns options that keep the tests' datagrams on loopback.
+func loopback(t *testing.T) Options {
+	t.Helper()
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range ifaces {
+		if ifaces[i].Flags&net.FlagLoopback != 0 && ifaces[i].Flags&net.FlagUp != 0 {
+			return Options{Interfaces: []*net.Interface{&ifaces[i]}}
+		}
+	}
+	t.Skip("no loopback interface")
+
+	return Options{}
+}
+
+func listen(t *testing.T, network string, group *net.UDPAddr, opts Options) *net.UDPConn {
+	t.Helper()
+
+	conn, err := Listen(network, group, opts)
+	if err != nil {
+		t.Skipf("multicast unavailable: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+func dial(t *testing.T, network string, group *net.UDPAddr, opts Options) *net.UDPConn {
+	t.Helper()
+
+	conn, err := Dial(network, group, opts)
+	if errors.Is(err, syscall.ENETUNREACH) {
+		t.Skipf("no multicast route: %v", err) // Loopback lacks IFF_MULTICAST
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+func TestGroup(t *testing.T) {
+	opts := loopback(t)
+
+	for _, g := range groups {
+		t.Run(g.network, func(t *testing.T) {
+			// Two subscribers on the same group and port each get a copy.
+			a := listen(t, g.network, g.group, opts)
+			group := &net.UDPAddr{IP: g.group.IP, Port: a.LocalAddr().(*net.UDPAddr).Port}
+			b := listen(t, g.network, group, opts)
+
+			pub := dial(t, g.network, group, opts)
+			if _, err := pub.Write([]byte("hello, group")); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, sub := range []*net.UDPConn{a, b} {
+				buf := make([]byte, 64)
+				_ = sub.SetReadDeadline(time.Now().Add(time.Second))
+				n, err := sub.Read(buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(buf[:n]) != "hello, group" {
+					t.Errorf("expected %q; actual %q", "hello, group", buf[:n])
+				}
+			}
+		})
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	opts := loopback(t)
+	g := groups[0]
+
+	sub := listen(t, g.network, g.group, opts)
+	group := &net.UDPAddr{IP: g.group.IP, Port: sub.LocalAddr().(*net.UDPAddr).Port}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var r Registry
+	go func() { _ = r.Run(ctx, sub) }()
+
+	svc := Service{Name: "telemetry", Address: "127.0.0.1:9000", Capabilities: []string{"v2", "gzip"}}
+	announced, stop := context.WithCancel(ctx)
+	a := &Announcer{Conn: dial(t, g.network, group, opts), Service: svc, Interval: 20 * time.Millisecond}
+	stopped := make(chan error)
+	go func() { stopped <- a.Run(announced) }()
+
+	waitFor(t, func() bool {
+		found := r.Lookup("telemetry")
+		return len(found) == 1 && slices.Equal(found[0].Capabilities, svc.Capabilities)
+	})
+
+	// Stopping the announcer says goodbye.
+	stop()
+	if err := <-stopped; err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return len(r.Services()) == 0 })
+
+	// A service that vanishes without a goodbye expires.
+	b, _ := json.Marshal(announcement{Service: Service{Name: "flaky"}, TTL: 30 * time.Millisecond})
+	if _, err := dial(t, g.network, group, opts).Write(b); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return len(r.Lookup("flaky")) == 1 })
+	time.Sleep(30 * time.Millisecond)
+	if s := r.Services(); len(s) != 0 {
+		t.Errorf("expected the stale entry to expire; actual %v", s)
+	}
+}
+
+func TestRegistryLimits(t *testing.T) {
+	r := &Registry{MaxTTL: time.Second, MaxEntries: 2}
+	now := time.Now()
+
+	// A peer can't make itself unforgettable.
+	r.update(announcement{Service: Service{Name: "forever"}, TTL: 1000 * time.Hour}, now)
+	if e := r.entries[serviceKey{name: "forever"}]; !e.expires.Equal(now.Add(time.Second)) {
+		t.Errorf("expected expiry in %s; actual %s", time.Second, e.expires.Sub(now))
+	}
+
+	// Nor fill memory: a third service pushes out the stalest.
+	r.update(announcement{Service: Service{Name: "b"}, TTL: time.Second}, now.Add(time.Millisecond))
+	r.update(announcement{Service: Service{Name: "c"}, TTL: time.Second}, now.Add(2*time.Millisecond))
+
+	if len(r.entries) != 2 {
+		t.Fatalf("expected 2 entries; actual %d", len(r.entries))
+	}
+	if _, ok := r.entries[serviceKey{name: "forever"}]; ok {
+		t.Error("expected the stalest entry to be evicted")
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package netem
+
+import (
+	"container/heap"
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// PacketConn impairs the datagrams written with WriteTo. Writes of
+// dropped datagrams succeed, as they would on a real network.
+type PacketConn struct {
+	net.PacketConn
+	im    *impairer
+	sched *scheduler
+}
+
+func NewPacketConn(pc net.PacketConn, cfg Config) *PacketConn {
+	c := &PacketConn{PacketConn: pc, im: newImpairer(cfg)}
+	c.sched = newScheduler(func(b []byte, addr net.Addr) { _, _ = pc.WriteTo(b, addr) })
+
+	return c
+}
+
+func (c *PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	err := c.im.write(c.sched, p, addr, func(b []byte) error {
+		_, err := c.PacketConn.WriteTo(b, addr)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Close discards packets still in flight and closes the connection.
+func (c *PacketConn) Close() error {
+	c.sched.close()
+
+	return c.PacketConn.Close()
+}
+
+func (c *PacketConn) Stats() Stats { return c.im.Stats() }
+
+// Conn impairs a datagram connection, such as one from net.Dial("udp"),
+// treating each Write as a packet. It isn't meant for TCP, whose stream
+// would be corrupted rather than retransmitted.
+type Conn struct {
+	net.Conn
+	im    *impairer
+	sched *scheduler
+}
+
+func NewConn(conn net.Conn, cfg Config) *Conn {
+	c := &Conn{Conn: conn, im: newImpairer(cfg)}
+	c.sched = newScheduler(func(b []byte, _ net.Addr) { _, _ = conn.Write(b) })
+
+	return c
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	err := c.im.write(c.sched, p, nil, func(b []byte) error {
+		_, err := c.Conn.Write(b)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Close discards packets still in flight and closes the connection.
+func (c *Conn) Close() error {
+	c.sched.close()
+
+	return c.Conn.Close()
+}
+
+func (c *Conn) Stats() Stats { return c.im.Stats() }
+
+// write waits for the rate limit, decides p's fate and sends or schedules
+// each copy.
+func (im *impairer) write(s *scheduler, p []byte, addr net.Addr, send func([]byte) error) error {
+	if im.bucket != nil {
+		_ = im.bucket.WaitN(context.Background(), len(p))
+	}
+
+	f := im.decide()
+	for i := range f.copies {
+		b := p
+		if i == 0 && f.corrupt {
+			b = im.corrupt(p)
+		}
+
+		if f.delays[i] == 0 {
+			if err := send(b); err != nil {
+				return err
+			}
+			continue
+		}
+		s.add(time.Now().Add(f.delays[i]), append([]byte(nil), b...), addr)
+	}
+
+	return nil
+}
+
+// scheduler sends delayed packets in the order they're due, from one
+// goroutine, so equal delays never reorder packets.
+type scheduler struct {
+	send func(b []byte, addr net.Addr)
+
+	mu    sync.Mutex
+	queue packets
+	seq   uint64 // Breaks ties in due time by write order
+
+	wake      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	started   sync.Once
+}
+
+type packet struct {
+	due  time.Time
+	seq  uint64
+	b    []byte
+	addr net.Addr
+}
+
+func newScheduler(send func([]byte, net.Addr)) *scheduler {
+	return &scheduler{
+		send: send,
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+}
+
+func (s *scheduler) add(due time.Time, b []byte, addr net.Addr) {
+	s.started.Do(func() { go s.run() }) // Perfect links never need it
+
+	s.mu.Lock()
+	s.seq++
+	heap.Push(&s.queue, packet{due: due, seq: s.seq, b: b, addr: addr})
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *scheduler) run() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+		var due []packet
+		now := time.Now()
+		for len(s.queue) > 0 && !s.queue[0].due.After(now) {
+			due = append(due, heap.Pop(&s.queue).(packet))
+		}
+		next := time.Hour
+		if len(s.queue) > 0 {
+			next = s.queue[0].due.Sub(now)
+		}
+		s.mu.Unlock()
+
+		for _, p := range due {
+			s.send(p.b, p.addr)
+		}
+
+		timer.Reset(next)
+		select {
+		case <-s.done:
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *scheduler) close() {
+	s.closeOnce.Do(func() { close(s.done) })
+}
+
+// packets is a min-heap by due time.
+type packets []packet
+
+func (q packets) Len() int { return len(q) }
+func (q packets) Less(i, j int) bool {
+	if q[i].due.Equal(q[j].due) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].due.Before(q[j].due)
+}
+func (q packets) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *packets) Push(x any)   { *q = append(*q, x.(packet)) }
+func (q *packets) Pop() any {
+	old := *q
+	p := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return p
+}
@@ -0,0 +1,183 @@
+// Package netem makes loopback behave like a bad network, after Linux's
+// netem queueing discipline. PacketConn and Conn wrappers drop, duplicate,
+// reorder, corrupt, delay and rate-limit the datagrams written to them.
+//
+// Every decision comes from a seeded random source, so a test that fails
+// under a given Config fails the same way every run. Only writes are
+// impaired; wrap both ends to impair both directions.
+package netem
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"networks/sending_tcp_data/shaping"
+)
+
+const defaultReorderDelay = 10 * time.Millisecond
+
+// Config describes the impairments. The zero value is a perfect link.
+// Probabilities are per packet, from 0 to 1.
+type Config struct {
+	Seed uint64 // Same seed, same decisions
+
+	Loss  float64         // Random, independent loss
+	Burst *GilbertElliott // Bursty loss; replaces Loss when set
+
+	Duplicate float64 // Sends a second copy
+	Corrupt   float64 // Flips one random bit
+
+	// Reorder holds a packet back by an extra ReorderDelay, so the ones
+	// written after it arrive first. ReorderDelay defaults to 10ms.
+	Reorder      float64
+	ReorderDelay time.Duration
+
+	// Delay postpones every packet, give or take a uniformly random
+	// Jitter. Enough jitter reorders packets by itself.
+	Delay  time.Duration
+	Jitter time.Duration
+
+	// Rate caps the link at this many bytes per second; Writes wait their
+	// turn, as behind a slow bottleneck. Burst is the bucket size, as in
+	// shaping.NewBucket.
+	Rate      int
+	RateBurst int
+}
+
+// GilbertElliott is a two-state loss model. The link is either good or
+// bad, and switches state before each packet with probability P (good to
+// bad) or R (bad to good), so losses come in bursts averaging 1/R
+// packets. The classic Gilbert model loses nothing when good and
+// everything when bad.
+type GilbertElliott struct {
+	P, R     float64
+	LossGood float64 // Loss probability in the good state
+	LossBad  float64 // Loss probability in the bad state
+}
+
+// Stats counts what was done to the packets written.
+type Stats struct {
+	Packets    int `json:"packets"` // Written by the caller
+	Dropped    int `json:"dropped"`
+	Duplicated int `json:"duplicated"`
+	Reordered  int `json:"reordered"`
+	Corrupted  int `json:"corrupted"`
+}
+
+// fate is what happens to one packet.
+type fate struct {
+	copies  int // 0 when dropped, 2 when duplicated
+	delays  [2]time.Duration
+	corrupt bool
+}
+
+// impairer decides each packet's fate.
+type impairer struct {
+	cfg    Config
+	bucket *shaping.Bucket
+
+	mu    sync.Mutex
+	rnd   *rand.Rand
+	bad   bool // Gilbert-Elliott state
+	stats Stats
+}
+
+func newImpairer(cfg Config) *impairer {
+	if cfg.ReorderDelay <= 0 {
+		cfg.ReorderDelay = defaultReorderDelay
+	}
+
+	im := &impairer{
+		cfg: cfg,
+		rnd: rand.New(rand.NewPCG(cfg.Seed, cfg.Seed^0x9e3779b97f4a7c15)),
+	}
+	if cfg.Rate > 0 {
+		im.bucket = shaping.NewBucket(cfg.Rate, cfg.RateBurst)
+	}
+
+	return im
+}
+
+func (im *impairer) decide() fate {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	im.stats.Packets++
+	if im.lost() {
+		im.stats.Dropped++
+		return fate{}
+	}
+
+	f := fate{copies: 1}
+	if im.roll(im.cfg.Duplicate) {
+		f.copies = 2
+		im.stats.Duplicated++
+	}
+	if im.roll(im.cfg.Corrupt) {
+		f.corrupt = true
+		im.stats.Corrupted++
+	}
+
+	for i := range f.copies {
+		d := im.cfg.Delay
+		if im.cfg.Jitter > 0 {
+			d += time.Duration(im.rnd.Int64N(int64(2*im.cfg.Jitter+1))) - im.cfg.Jitter
+		}
+		if i == 0 && im.roll(im.cfg.Reorder) {
+			d += im.cfg.ReorderDelay
+			im.stats.Reordered++
+		}
+		f.delays[i] = max(d, 0)
+	}
+
+	return f
+}
+
+// lost steps the loss model. im.mu must be held.
+func (im *impairer) lost() bool {
+	ge := im.cfg.Burst
+	if ge == nil {
+		return im.roll(im.cfg.Loss)
+	}
+
+	if im.bad {
+		im.bad = !im.roll(ge.R)
+	} else {
+		im.bad = im.roll(ge.P)
+	}
+	if im.bad {
+		return im.roll(ge.LossBad)
+	}
+
+	return im.roll(ge.LossGood)
+}
+
+// roll returns true with probability p. It doesn't consume randomness
+// when p is zero, so turning an impairment on doesn't change the
+// decisions made for the others.
+func (im *impairer) roll(p float64) bool {
+	return p > 0 && im.rnd.Float64() < p
+}
+
+// corrupt flips a random bit in a copy of b.
+func (im *impairer) corrupt(b []byte) []byte {
+	b = append([]byte(nil), b...)
+	if len(b) == 0 {
+		return b
+	}
+
+	im.mu.Lock()
+	bit := im.rnd.IntN(len(b) * 8)
+	im.mu.Unlock()
+	b[bit/8] ^= 1 << (bit % 8)
+
+	return b
+}
+
+func (im *impairer) Stats() Stats {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	return im.stats
+}
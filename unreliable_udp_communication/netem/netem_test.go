@@ -0,0 +1,290 @@
+package netem
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+	"net"
+	"testing"
+	"time"
+)
+
+// fates runs n packets through an impairer and records each one's fate.
+func fates(cfg Config, n int) ([]fate, Stats) {
+	im := newImpairer(cfg)
+	out := make([]fate, n)
+	for i := range out {
+		out[i] = im.decide()
+	}
+
+	return out, im.Stats()
+}
+
+func TestDeterministic(t *testing.T) {
+	cfg := Config{Seed: 42, Loss: 0.2, Duplicate: 0.1, Reorder: 0.1, Corrupt: 0.05, Jitter: time.Millisecond}
+
+	a, _ := fates(cfg, 1000)
+	b, _ := fates(cfg, 1000)
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("packet %d: expected %+v; actual %+v", i, a[i], b[i])
+		}
+	}
+
+	cfg.Seed++
+	c, _ := fates(cfg, 1000)
+	same := true
+	for i := range a {
+		same = same && a[i] == c[i]
+	}
+	if same {
+		t.Error("a different seed made the same decisions")
+	}
+}
+
+func TestLossRate(t *testing.T) {
+	const n = 10000
+
+	_, s := fates(Config{Seed: 1, Loss: 0.1}, n)
+	if s.Packets != n {
+		t.Fatalf("expected %d packets; actual %d", n, s.Packets)
+	}
+	if rate := float64(s.Dropped) / n; math.Abs(rate-0.1) > 0.02 {
+		t.Errorf("expected a loss rate near 0.1; actual %.3f", rate)
+	}
+}
+
+func TestGilbertElliott(t *testing.T) {
+	const n = 20000
+
+	// Enter the bad state 2% of the time and stay for 4 packets on average.
+	ge := &GilbertElliott{P: 0.02, R: 0.25, LossBad: 1}
+	fs, s := fates(Config{Seed: 7, Burst: ge}, n)
+
+	var bursts, lost, run int
+	for _, f := range fs {
+		if f.copies == 0 {
+			lost++
+			run++
+			continue
+		}
+		if run > 0 {
+			bursts++
+			run = 0
+		}
+	}
+	if lost != s.Dropped {
+		t.Fatalf("expected %d dropped; actual %d", s.Dropped, lost)
+	}
+	if bursts == 0 {
+		t.Fatal("no losses")
+	}
+
+	// Independent loss at this rate would make bursts barely longer than 1.
+	if mean := float64(lost) / float64(bursts); mean < 2.5 {
+		t.Errorf("expected bursts averaging about 4 packets; actual %.2f", mean)
+	}
+}
+
+// pair returns an impaired PacketConn and a plain one to receive from it.
+func pair(t *testing.T, cfg Config) (*PacketConn, net.PacketConn) {
+	t.Helper()
+
+	a, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pc := NewPacketConn(a, cfg)
+	t.Cleanup(func() {
+		_ = pc.Close()
+		_ = b.Close()
+	})
+
+	return pc, b
+}
+
+// receive reads datagrams until none arrive for a while.
+func receive(t *testing.T, pc net.PacketConn) [][]byte {
+	t.Helper()
+
+	var got [][]byte
+	buf := make([]byte, 2048)
+	for {
+		_ = pc.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			var nErr net.Error
+			if errors.As(err, &nErr) && nErr.Timeout() {
+				return got
+			}
+			t.Fatal(err)
+		}
+		got = append(got, append([]byte(nil), buf[:n]...))
+	}
+}
+
+func seq(i int) []byte { return binary.BigEndian.AppendUint32(nil, uint32(i)) }
+
+func TestDuplicate(t *testing.T) {
+	pc, r := pair(t, Config{Duplicate: 1})
+
+	msg := []byte("ping")
+	if n, err := pc.WriteTo(msg, r.LocalAddr()); err != nil || n != len(msg) {
+		t.Fatalf("expected %d, nil; actual %d, %v", len(msg), n, err)
+	}
+
+	got := receive(t, r)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 copies; actual %d", len(got))
+	}
+	for _, b := range got {
+		if !bytes.Equal(b, msg) {
+			t.Errorf("expected %q; actual %q", msg, b)
+		}
+	}
+	if s := pc.Stats(); s.Duplicated != 1 {
+		t.Errorf("expected 1 duplicated; actual %d", s.Duplicated)
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	pc, r := pair(t, Config{Seed: 3, Corrupt: 1})
+
+	msg := bytes.Repeat([]byte{0x55}, 64)
+	if _, err := pc.WriteTo(msg, r.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg, bytes.Repeat([]byte{0x55}, 64)) {
+		t.Fatal("the caller's buffer was modified")
+	}
+
+	got := receive(t, r)
+	if len(got) != 1 {
+		t.Fatalf("expected 1 datagram; actual %d", len(got))
+	}
+
+	flipped := 0
+	for i := range msg {
+		flipped += bits.OnesCount8(msg[i] ^ got[0][i])
+	}
+	if flipped != 1 {
+		t.Errorf("expected 1 bit flipped; actual %d", flipped)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	const delay = 50 * time.Millisecond
+
+	pc, r := pair(t, Config{Delay: delay})
+
+	start := time.Now()
+	if _, err := pc.WriteTo([]byte("ping"), r.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed >= delay {
+		t.Errorf("WriteTo blocked for %v", elapsed)
+	}
+
+	_ = r.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := r.ReadFrom(make([]byte, 16)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Errorf("expected a delay of at least %v; actual %v", delay, elapsed)
+	}
+}
+
+func TestRate(t *testing.T) {
+	const (
+		rate  = 64 * 1024
+		total = 32 * 1024
+	)
+
+	pc, r := pair(t, Config{Rate: rate, RateBurst: 1024})
+
+	start := time.Now()
+	for i := range total / 1024 {
+		if _, err := pc.WriteTo(append(seq(i), make([]byte, 1020)...), r.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 32KiB at 64KiB/s, less the initial burst.
+	if elapsed, want := time.Since(start), 400*time.Millisecond; elapsed < want {
+		t.Errorf("expected writes to take at least %v; actual %v", want, elapsed)
+	}
+	if got := receive(t, r); len(got) != total/1024 {
+		t.Errorf("expected %d datagrams; actual %d", total/1024, len(got))
+	}
+}
+
+func TestReorder(t *testing.T) {
+	a, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = a.Close() }()
+
+	d, err := net.Dial("udp", a.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := NewConn(d, Config{Seed: 9, Reorder: 0.2, ReorderDelay: 20 * time.Millisecond})
+	defer func() { _ = conn.Close() }()
+
+	const n = 100
+	for i := range n {
+		if _, err = conn.Write(seq(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := receive(t, a)
+	if len(got) != n {
+		t.Fatalf("expected %d datagrams; actual %d", n, len(got))
+	}
+
+	late := 0
+	seen := make(map[uint32]bool)
+	for i, b := range got {
+		v := binary.BigEndian.Uint32(b)
+		seen[v] = true
+		if int(v) < i {
+			late++
+		}
+	}
+	if len(seen) != n {
+		t.Errorf("expected %d distinct datagrams; actual %d", n, len(seen))
+	}
+	if s := conn.Stats(); s.Reordered == 0 || late == 0 {
+		t.Errorf("expected reordering; stats %+v, %d late", s, late)
+	}
+}
+
+func TestPerfectLink(t *testing.T) {
+	pc, r := pair(t, Config{})
+
+	const n = 50
+	for i := range n {
+		if _, err := pc.WriteTo(seq(i), r.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := receive(t, r)
+	if len(got) != n {
+		t.Fatalf("expected %d datagrams; actual %d", n, len(got))
+	}
+	for i, b := range got {
+		if v := binary.BigEndian.Uint32(b); int(v) != i {
+			t.Fatalf("expected datagram %d; actual %d", i, v)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package echo
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"networks/unreliable_udp_communication/netem"
+)
+
+// TestEchoServerUDPImpaired pings the echo server over a link that loses,
+// duplicates and delays datagrams. UDP promises nothing, so the client
+// sees fewer, extra and late replies; the seed makes it the same every run.
+func TestEchoServerUDPImpaired(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serverAddr, err := echoServerUDP(ctx, "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := netem.NewPacketConn(pc, netem.Config{
+		Seed:      1,
+		Loss:      0.2,
+		Duplicate: 0.1,
+		Delay:     5 * time.Millisecond,
+		Jitter:    2 * time.Millisecond,
+	})
+	defer func() { _ = client.Close() }()
+
+	const pings = 200
+	for i := range pings {
+		_, err = client.WriteTo(binary.BigEndian.AppendUint32(nil, uint32(i)), serverAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	replies := 0
+	buf := make([]byte, 1024)
+	for {
+		_ = client.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		_, _, err = client.ReadFrom(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		replies++
+	}
+
+	s := client.Stats()
+	if expected := pings - s.Dropped + s.Duplicated; replies != expected {
+		t.Errorf("expected %d replies; actual %d (%+v)", expected, replies, s)
+	}
+	if s.Dropped == 0 || s.Duplicated == 0 {
+		t.Errorf("expected losses and duplicates; actual %+v", s)
+	}
+}
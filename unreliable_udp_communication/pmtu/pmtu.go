@@ -0,0 +1,219 @@
+// Package pmtu finds the largest UDP datagram that reaches a destination
+// whole. Anything bigger is fragmented by IP, and losing any fragment
+// loses the datagram, so senders should size their payloads to fit.
+//
+// On Linux, the kernel tracks the path MTU of a connected socket, and
+// sockets can set the don't-fragment bit so oversized datagrams are
+// dropped rather than split. Prober combines that with a binary search
+// over echo replies, which also works where the kernel can't help.
+package pmtu
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	IPv4Header = 20
+	IPv6Header = 40
+	UDPHeader  = 8
+
+	// Every IPv4 host must accept 576-byte datagrams, and every IPv6 link
+	// must carry 1280-byte packets.
+	MinIPv4MTU = 576
+	MinIPv6MTU = 1280
+
+	maxDatagram = 65535 // The IP length field's limit
+
+	defaultTimeout = 500 * time.Millisecond
+	defaultRetries = 2
+	cookieSize     = 8
+)
+
+var (
+	ErrNotConnected = errors.New("pmtu: connection has no remote address")
+
+	// ErrNoReply is returned when even a minimum-size probe isn't echoed.
+	ErrNoReply = errors.New("pmtu: no echo reply from the peer")
+)
+
+// Overhead is the IP and UDP header bytes in each datagram sent to addr.
+func Overhead(addr *net.UDPAddr) int {
+	if addr.IP.To4() != nil {
+		return IPv4Header + UDPHeader
+	}
+
+	return IPv6Header + UDPHeader
+}
+
+// Payload converts a path MTU to the largest UDP payload that fits it.
+func Payload(mtu int, addr *net.UDPAddr) int {
+	return min(mtu, maxDatagram) - Overhead(addr)
+}
+
+// minMTU is the MTU every path to addr is guaranteed to carry.
+func minMTU(addr *net.UDPAddr) int {
+	if addr.IP.To4() != nil {
+		return MinIPv4MTU
+	}
+
+	return MinIPv6MTU
+}
+
+// Recommended returns the payload size a sender on conn should use: the
+// kernel's path MTU for the destination if it knows it, or the size every
+// path is guaranteed to carry if it doesn't.
+func Recommended(conn *net.UDPConn) int {
+	raddr, ok := conn.RemoteAddr().(*net.UDPAddr)
+	if !ok {
+		return MinIPv4MTU - IPv4Header - UDPHeader
+	}
+	if mtu, err := PathMTU(conn); err == nil && mtu > 0 {
+		return Payload(mtu, raddr)
+	}
+
+	return Payload(minMTU(raddr), raddr)
+}
+
+// Result is what a probe found.
+type Result struct {
+	MTU       int // Largest datagram echoed, headers included
+	Payload   int // Largest UDP payload echoed
+	KernelMTU int // The kernel's path MTU when probing started, if known
+	Probes    int // Datagrams sent
+}
+
+// Prober searches for the largest datagram a peer echoes back, such as
+// the UDP echo server.
+type Prober struct {
+	Timeout time.Duration // Wait for each echo; defaults to 500ms
+	Retries int           // Resends before a size is deemed too big; defaults to 2
+
+	// Max caps the payload sizes tried. It defaults to the kernel's path
+	// MTU, or to the largest datagram IP allows.
+	Max int
+}
+
+// Probe finds the largest payload the peer of conn, which must be
+// connected, echoes back. It sets the don't-fragment bit on conn where
+// supported, so a datagram too big for the path is dropped instead of
+// fragmented, and leaves it set.
+func (p Prober) Probe(ctx context.Context, conn *net.UDPConn) (Result, error) {
+	raddr, ok := conn.RemoteAddr().(*net.UDPAddr)
+	if !ok {
+		return Result{}, ErrNotConnected
+	}
+	if p.Timeout <= 0 {
+		p.Timeout = defaultTimeout
+	}
+	if p.Retries <= 0 {
+		p.Retries = defaultRetries
+	}
+	defer func() { _ = conn.SetReadDeadline(time.Time{}) }()
+
+	var r Result
+	_ = SetDontFragment(conn) // Without it, the search finds fragmented sizes too
+	r.KernelMTU, _ = PathMTU(conn)
+
+	hi := Payload(maxDatagram, raddr)
+	if r.KernelMTU > 0 {
+		hi = min(hi, Payload(r.KernelMTU, raddr))
+	}
+	if p.Max > 0 {
+		hi = min(hi, p.Max)
+	}
+	lo := min(Payload(minMTU(raddr), raddr), hi)
+
+	// The floor has to work, or there's nothing to search.
+	ok, err := p.probe(ctx, conn, lo, &r)
+	if err != nil {
+		return r, err
+	}
+	if !ok {
+		return r, ErrNoReply
+	}
+
+	// Usually the first hop's MTU is the path's, so try that first.
+	if lo < hi {
+		ok, err = p.probe(ctx, conn, hi, &r)
+		if err != nil {
+			return r, err
+		}
+		if ok {
+			lo = hi
+		}
+	}
+
+	// lo is echoed and hi isn't.
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		ok, err = p.probe(ctx, conn, mid, &r)
+		if err != nil {
+			return r, err
+		}
+		if ok {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	r.Payload = lo
+	r.MTU = lo + Overhead(raddr)
+
+	return r, nil
+}
+
+// probe reports whether a payload of size bytes is echoed back. Each
+// probe starts with a random cookie, so late echoes of earlier probes
+// aren't mistaken for its reply.
+func (p Prober) probe(ctx context.Context, conn *net.UDPConn, size int, r *Result) (bool, error) {
+	b := make([]byte, max(size, cookieSize))
+	_, _ = rand.Read(b[:cookieSize])
+	reply := make([]byte, len(b)+1) // One more, to catch an oversized reply
+
+	for range p.Retries + 1 {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+
+		r.Probes++
+		if _, err := conn.Write(b); err != nil {
+			if errors.Is(err, syscall.EMSGSIZE) {
+				return false, nil // Bigger than the MTU the kernel knows
+			}
+			return false, err
+		}
+
+		deadline := time.Now().Add(p.Timeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		_ = conn.SetReadDeadline(deadline)
+
+		for {
+			n, err := conn.Read(reply)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break // Resend
+			}
+			if err != nil {
+				if errors.Is(err, syscall.ECONNREFUSED) {
+					return false, ErrNoReply
+				}
+				return false, err
+			}
+			if n == len(b) && bytes.Equal(reply[:cookieSize], b[:cookieSize]) {
+				return true, nil
+			}
+			// A late reply to an earlier probe
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,88 @@
+package pmtu
+
+import (
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// SetDontFragment sets the don't-fragment bit on conn's datagrams
+// (IP_PMTUDISC_PROBE), so they're dropped rather than fragmented when too
+// big for the path. Unlike IP_PMTUDISC_DO, sends aren't limited to the
+// path MTU the kernel has cached, which lets probes find a larger one.
+func SetDontFragment(conn *net.UDPConn) error {
+	c, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	name := "getsockopt SO_DOMAIN"
+	cErr := c.Control(func(fd uintptr) {
+		var v6 bool
+		if v6, err = isIPv6(fd); err != nil {
+			return
+		}
+
+		if !v6 {
+			name = "setsockopt IP_MTU_DISCOVER"
+			err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_PROBE)
+			return
+		}
+
+		// A dual-stack socket sends to IPv4-mapped addresses as IPv4, and
+		// those datagrams follow the IPv4 option.
+		_ = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_PROBE)
+		name = "setsockopt IPV6_MTU_DISCOVER"
+		err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_MTU_DISCOVER, unix.IPV6_PMTUDISC_PROBE)
+	})
+	if cErr != nil {
+		return cErr
+	}
+
+	return os.NewSyscallError(name, err)
+}
+
+// PathMTU returns the kernel's path MTU for a connected conn (IP_MTU).
+// It starts out as the outgoing interface's MTU and drops as ICMP
+// "fragmentation needed" messages arrive from routers along the path.
+func PathMTU(conn *net.UDPConn) (int, error) {
+	c, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var mtu int
+	name := "getsockopt SO_DOMAIN"
+	cErr := c.Control(func(fd uintptr) {
+		var v6 bool
+		if v6, err = isIPv6(fd); err != nil {
+			return
+		}
+
+		level, opt := unix.IPPROTO_IP, unix.IP_MTU
+		name = "getsockopt IP_MTU"
+		if v6 {
+			level, opt = unix.IPPROTO_IPV6, unix.IPV6_MTU
+			name = "getsockopt IPV6_MTU"
+		}
+		mtu, err = unix.GetsockoptInt(int(fd), level, opt)
+	})
+	if cErr != nil {
+		return 0, cErr
+	}
+	if err != nil {
+		return 0, os.NewSyscallError(name, err)
+	}
+
+	return mtu, nil
+}
+
+// isIPv6 reports whether fd is an AF_INET6 socket, which takes IPv6
+// options even when it's talking to an IPv4-mapped address. The
+// addresses Go reports can't tell: it shows those as plain IPv4.
+func isIPv6(fd uintptr) (bool, error) {
+	domain, err := unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_DOMAIN)
+
+	return domain == unix.AF_INET6, err
+}
@@ -0,0 +1,79 @@
+package pmtu
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestPathMTU(t *testing.T) {
+	for _, c := range []struct {
+		addr     string
+		overhead int
+	}{
+		{"127.0.0.1:", IPv4Header + UDPHeader},
+		{"[::1]:", IPv6Header + UDPHeader},
+	} {
+		conn := dial(t, echoServer(t, "udp", c.addr, maxDatagram))
+
+		mtu, err := PathMTU(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mtu < MinIPv6MTU {
+			t.Fatalf("implausible loopback MTU %d", mtu)
+		}
+
+		expected := min(mtu, maxDatagram) - c.overhead
+		if actual := Recommended(conn); actual != expected {
+			t.Errorf("expected recommended payload %d; actual %d", expected, actual)
+		}
+
+		// Nothing along loopback is smaller than the interface, so the
+		// probe confirms the kernel's answer.
+		r, err := Prober{Timeout: 100 * time.Millisecond}.Probe(context.Background(), conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.KernelMTU != mtu || r.Payload != expected {
+			t.Errorf("expected kernel MTU %d and payload %d; actual %+v", mtu, expected, r)
+		}
+	}
+}
+
+// TestDualStack checks an AF_INET6 socket talking to an IPv4 address,
+// which Go reports as an IPv4 conn, gets the options it can take.
+func TestDualStack(t *testing.T) {
+	raddr := echoServer(t, "udp", "127.0.0.1:", maxDatagram).(*net.UDPAddr)
+	conn, err := net.DialUDP("udp", &net.UDPAddr{IP: net.IPv6unspecified}, raddr)
+	if err != nil {
+		t.Skip(err) // No IPv6
+	}
+	defer func() { _ = conn.Close() }()
+
+	if err = SetDontFragment(conn); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = rc.Control(func(fd uintptr) {
+		v4, _ := unix.GetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_MTU_DISCOVER)
+		v6, _ := unix.GetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_MTU_DISCOVER)
+		if v4 != unix.IP_PMTUDISC_PROBE || v6 != unix.IPV6_PMTUDISC_PROBE {
+			t.Errorf("expected both options set to probe; actual %d and %d", v4, v6)
+		}
+	})
+
+	mtu, err := PathMTU(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := min(mtu, maxDatagram) - IPv4Header - UDPHeader; Recommended(conn) != expected {
+		t.Errorf("expected recommended payload %d; actual %d", expected, Recommended(conn))
+	}
+}
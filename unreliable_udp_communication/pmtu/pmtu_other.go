@@ -0,0 +1,19 @@
+//go:build !linux
+
+package pmtu
+
+import (
+	"errors"
+	"net"
+)
+
+// SetDontFragment isn't supported on this platform, so probes may be
+// fragmented.
+func SetDontFragment(*net.UDPConn) error {
+	return errors.ErrUnsupported
+}
+
+// PathMTU isn't supported on this platform.
+func PathMTU(*net.UDPConn) (int, error) {
+	return 0, errors.ErrUnsupported
+}
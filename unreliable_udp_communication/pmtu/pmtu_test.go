@@ -0,0 +1,99 @@
+package pmtu
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// echoServer echoes datagrams with payloads up to limit bytes and drops
+// larger ones, like a path with a smaller MTU than the first hop. A
+// negative limit drops everything.
+func echoServer(t *testing.T, network, addr string, limit int) net.Addr {
+	t.Helper()
+
+	s, err := net.ListenPacket(network, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+
+	go func() {
+		buf := make([]byte, maxDatagram)
+		for {
+			n, clientAddr, err := s.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n > limit {
+				continue
+			}
+			_, _ = s.WriteTo(buf[:n], clientAddr)
+		}
+	}()
+
+	return s.LocalAddr()
+}
+
+func dial(t *testing.T, addr net.Addr) *net.UDPConn {
+	t.Helper()
+
+	conn, err := net.DialUDP("udp", nil, addr.(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+func TestProbeFallback(t *testing.T) {
+	// The path beyond the loopback interface only carries 1400 bytes.
+	const path = 1400
+	conn := dial(t, echoServer(t, "udp", "127.0.0.1:", path-IPv4Header-UDPHeader))
+
+	r, err := Prober{Timeout: 50 * time.Millisecond, Retries: 1}.Probe(context.Background(), conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%+v", r)
+
+	if r.MTU != path {
+		t.Errorf("expected MTU %d; actual %d", path, r.MTU)
+	}
+	if r.Payload != path-IPv4Header-UDPHeader {
+		t.Errorf("expected payload %d; actual %d", path-IPv4Header-UDPHeader, r.Payload)
+	}
+}
+
+func TestProbeNoReply(t *testing.T) {
+	conn := dial(t, echoServer(t, "udp", "127.0.0.1:", -1))
+
+	_, err := Prober{Timeout: 20 * time.Millisecond}.Probe(context.Background(), conn)
+	if !errors.Is(err, ErrNoReply) {
+		t.Fatalf("expected %v; actual %v", ErrNoReply, err)
+	}
+}
+
+func TestPayload(t *testing.T) {
+	v4 := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1)}
+	v6 := &net.UDPAddr{IP: net.ParseIP("2001:db8::1")}
+
+	for _, c := range []struct {
+		mtu      int
+		addr     *net.UDPAddr
+		expected int
+	}{
+		{1500, v4, 1472},
+		{1500, v6, 1452},
+		{MinIPv4MTU, v4, 548},
+		{MinIPv6MTU, v6, 1232},
+		{65536, v4, 65507}, // Loopback's MTU exceeds IP's limit
+	} {
+		if actual := Payload(c.mtu, c.addr); actual != c.expected {
+			t.Errorf("Payload(%d, %s): expected %d; actual %d", c.mtu, c.addr.IP, c.expected, actual)
+		}
+	}
+}
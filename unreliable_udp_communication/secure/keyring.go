@@ -0,0 +1,195 @@
+package secure
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Cipher is an AEAD algorithm.
+type Cipher int
+
+const (
+	AESGCM           Cipher = iota // 16, 24 or 32-byte secrets
+	ChaCha20Poly1305               // 32-byte secrets; faster without AES hardware
+)
+
+func (c Cipher) String() string {
+	switch c {
+	case AESGCM:
+		return "AES-GCM"
+	case ChaCha20Poly1305:
+		return "ChaCha20-Poly1305"
+	}
+
+	return fmt.Sprintf("Cipher(%d)", int(c))
+}
+
+var (
+	ErrUnknownKey = errors.New("secure: unknown key ID")
+	ErrCurrentKey = errors.New("secure: can't remove the current key")
+)
+
+// hkdfInfo binds derived keys to this protocol.
+var hkdfInfo = []byte("networks/secure session key v1")
+
+// Key is a pre-shared key. Its ID travels with each datagram so the
+// receiver knows which key to open it with. The secret is never used
+// directly; each session seals with a key derived from it.
+type Key struct {
+	ID     byte
+	Cipher Cipher
+	Secret []byte
+}
+
+func (k Key) validate() error {
+	switch k.Cipher {
+	case AESGCM:
+		switch len(k.Secret) {
+		case 16, 24, 32:
+			return nil
+		}
+	case ChaCha20Poly1305:
+		if len(k.Secret) == chacha20poly1305.KeySize {
+			return nil
+		}
+	default:
+		return fmt.Errorf("key %d: unknown cipher %v", k.ID, k.Cipher)
+	}
+
+	return fmt.Errorf("key %d: %d-byte secret is the wrong size for %v", k.ID, len(k.Secret), k.Cipher)
+}
+
+// keyEntry is a key in a ring. Sessions keep a pointer to the entry they
+// were derived from, so replacing or removing the key ends them.
+type keyEntry struct {
+	Key
+}
+
+// derive returns the AEAD for the session identified by salt.
+func (e *keyEntry) derive(salt []byte) (cipher.AEAD, error) {
+	sk := make([]byte, len(e.Secret))
+	if _, err := io.ReadFull(hkdf.New(sha256.New, e.Secret, salt, hkdfInfo), sk); err != nil {
+		return nil, err
+	}
+
+	if e.Cipher == ChaCha20Poly1305 {
+		return chacha20poly1305.New(sk)
+	}
+	block, err := aes.NewCipher(sk)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// Keyring holds the keys a PacketConn opens datagrams with and the one it
+// seals them with. It's safe to change while in use, and may be shared by
+// several PacketConns.
+//
+// To rotate keys without dropping datagrams, Add the new key on every
+// peer, then Use it on every peer, then Remove the old one. Each peer
+// opens datagrams sealed with either key in between.
+type Keyring struct {
+	mu      sync.RWMutex
+	keys    map[byte]*keyEntry
+	current byte
+}
+
+// NewKeyring returns a keyring sealing with k.
+func NewKeyring(k Key) (*Keyring, error) {
+	r := &Keyring{keys: make(map[byte]*keyEntry)}
+	if err := r.Rotate(k); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Add accepts datagrams sealed with k, replacing any key with k's ID.
+func (r *Keyring) Add(k Key) error {
+	return r.add(k, false)
+}
+
+// Use seals datagrams with the key with the given ID.
+func (r *Keyring) Use(id byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.keys[id]; !ok {
+		return ErrUnknownKey
+	}
+	r.current = id
+
+	return nil
+}
+
+// Rotate adds k and seals with it from now on. Peers that haven't added
+// k yet drop what's sealed with it.
+func (r *Keyring) Rotate(k Key) error {
+	return r.add(k, true)
+}
+
+func (r *Keyring) add(k Key, use bool) error {
+	if err := k.validate(); err != nil {
+		return err
+	}
+	k.Secret = append([]byte(nil), k.Secret...) // The caller may reuse theirs
+
+	r.mu.Lock()
+	r.keys[k.ID] = &keyEntry{Key: k}
+	if use {
+		r.current = k.ID
+	}
+	r.mu.Unlock()
+
+	return nil
+}
+
+// Remove stops accepting datagrams sealed with the key with the given ID.
+func (r *Keyring) Remove(id byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if id == r.current {
+		return ErrCurrentKey
+	}
+	if _, ok := r.keys[id]; !ok {
+		return ErrUnknownKey
+	}
+	delete(r.keys, id)
+
+	return nil
+}
+
+// Current returns the ID of the key datagrams are sealed with.
+func (r *Keyring) Current() byte {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.current
+}
+
+func (r *Keyring) sealer() *keyEntry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.keys[r.current]
+}
+
+func (r *Keyring) opener(id byte) (*keyEntry, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	e, ok := r.keys[id]
+
+	return e, ok
+}
@@ -0,0 +1,327 @@
+// Package secure encrypts and authenticates datagrams with pre-shared
+// keys, for control messages crossing networks we don't trust. PacketConn
+// wraps any net.PacketConn, UDP or unixgram, and seals each datagram on
+// its way out and opens it on its way in, so servers keep their read and
+// write loops.
+//
+// Every peer shares the same keys, so no PacketConn seals with a key
+// directly. Each picks a random 16-byte salt and derives its own session
+// key from the pre-shared key and the salt with HKDF, then numbers its
+// datagrams from 1. The counter is the nonce, which can't repeat under a
+// session key unless two conns pick the same 128-bit salt. Each datagram
+// carries what the receiver needs to derive the same key:
+//
+//	key ID (1) | salt (16) | counter (8) | ciphertext | tag (16)
+//
+// The header is authenticated too. Receivers drop datagrams they can't
+// open, and replays, using a 64-datagram sliding window per session.
+package secure
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	saltSize   = 16
+	headerSize = 1 + saltSize + 8
+	tagSize    = 16
+
+	// Overhead is what sealing adds to each datagram.
+	Overhead = headerSize + tagSize
+
+	// maxDatagram is the most ReadFrom reads at once. Longer datagrams
+	// are cut short by the socket and can't be opened.
+	maxDatagram = 65535
+
+	windowSize         = 64
+	defaultMaxSessions = 4096
+)
+
+// Config tunes a PacketConn. The zero value is usable.
+type Config struct {
+	// MaxSessions bounds how many sessions' keys and replay windows are
+	// kept. Beyond it, the least recently heard from is forgotten, and its
+	// old datagrams could be replayed once. Only datagrams that open start
+	// sessions, so nobody without the key can push sessions out. Defaults
+	// to 4096.
+	MaxSessions int
+}
+
+// Stats counts datagrams sealed and opened, and why others were dropped.
+type Stats struct {
+	Sealed     int
+	Opened     int
+	Replayed   int // Seen before, or older than the replay window
+	Forged     int // Failed authentication
+	UnknownKey int
+	Malformed  int // Too short to hold a header and tag, or too long to read
+	Truncated  int // Opened, but cut short to fit the caller's buffer
+}
+
+// session identifies a sender's counter space: one PacketConn sealing
+// under one key. It doesn't include the sender's address, so a replay
+// from anywhere else is still a replay.
+type session struct {
+	id   byte
+	salt [saltSize]byte
+}
+
+// state is what a receiver keeps per session.
+type state struct {
+	entry *keyEntry // The key aead was derived from
+	aead  cipher.AEAD
+	window
+}
+
+// window is an RFC 4303 style sliding window over the counters received
+// in one session.
+type window struct {
+	top  uint64 // Highest counter received
+	bits uint64 // Bit i set if top-i was received
+	seen time.Time
+}
+
+// fresh reports whether counter n hasn't been received and isn't too old.
+func (w *window) fresh(n uint64) bool {
+	if n > w.top {
+		return true
+	}
+	off := w.top - n
+
+	return off < windowSize && w.bits&(1<<off) == 0
+}
+
+// record marks n received. It must be fresh.
+func (w *window) record(n uint64) {
+	if n > w.top {
+		shift := n - w.top
+		if shift >= windowSize {
+			w.bits = 0
+		} else {
+			w.bits <<= shift
+		}
+		w.top = n
+	}
+	w.bits |= 1 << (w.top - n)
+}
+
+// PacketConn seals datagrams written to it and opens datagrams read from
+// it. Datagrams that fail to open are dropped, as though lost.
+type PacketConn struct {
+	net.PacketConn
+	ring *Keyring
+	cfg  Config
+	salt [saltSize]byte
+
+	wmu     sync.Mutex
+	counter uint64
+	wentry  *keyEntry // The key waead was derived from
+	waead   cipher.AEAD
+	wbuf    []byte
+
+	rmu  sync.Mutex
+	rbuf []byte
+
+	mu       sync.Mutex
+	sessions map[session]*state
+	stats    Stats
+}
+
+func New(pc net.PacketConn, ring *Keyring, cfg Config) *PacketConn {
+	if cfg.MaxSessions <= 0 {
+		cfg.MaxSessions = defaultMaxSessions
+	}
+
+	c := &PacketConn{
+		PacketConn: pc,
+		ring:       ring,
+		cfg:        cfg,
+		sessions:   make(map[session]*state),
+	}
+	_, _ = rand.Read(c.salt[:])
+
+	return c
+}
+
+// nonce makes the AEAD nonce from a counter.
+func nonce(counter uint64) []byte {
+	var n [12]byte
+	binary.BigEndian.PutUint64(n[4:], counter)
+
+	return n[:]
+}
+
+// WriteTo seals p and sends it to addr.
+func (c *PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	entry := c.ring.sealer()
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if entry != c.wentry {
+		aead, err := entry.derive(c.salt[:])
+		if err != nil {
+			return 0, err
+		}
+		c.wentry, c.waead = entry, aead
+	}
+
+	c.counter++
+	header := c.wbuf[:0]
+	header = append(header, entry.ID)
+	header = append(header, c.salt[:]...)
+	header = binary.BigEndian.AppendUint64(header, c.counter)
+
+	b := c.waead.Seal(header, nonce(c.counter), p, header)
+	c.wbuf = b[:0] // Reuse the grown buffer next time
+
+	if _, err := c.PacketConn.WriteTo(b, addr); err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	c.stats.Sealed++
+	c.mu.Unlock()
+
+	return len(p), nil
+}
+
+// ReadFrom returns the next datagram that opens, skipping the rest. Like
+// a datagram socket, it discards whatever of the datagram doesn't fit p.
+func (c *PacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	if c.rbuf == nil {
+		c.rbuf = make([]byte, maxDatagram)
+	}
+
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(c.rbuf)
+		if err != nil {
+			return 0, addr, err
+		}
+		if n == len(c.rbuf) {
+			c.count(&c.stats.Malformed) // Probably truncated
+			continue
+		}
+
+		plain, ok := c.open(c.rbuf[:n])
+		if !ok {
+			continue
+		}
+
+		n = copy(p, plain)
+		if n < len(plain) {
+			c.count(&c.stats.Truncated)
+		}
+
+		return n, addr, nil
+	}
+}
+
+// open authenticates b and checks it isn't a replay, decrypting it in
+// place.
+func (c *PacketConn) open(b []byte) ([]byte, bool) {
+	if len(b) < Overhead {
+		c.count(&c.stats.Malformed)
+		return nil, false
+	}
+
+	header := b[:headerSize]
+	entry, ok := c.ring.opener(header[0])
+	if !ok {
+		c.count(&c.stats.UnknownKey)
+		return nil, false
+	}
+
+	s := session{id: header[0]}
+	copy(s.salt[:], header[1:])
+	n := binary.BigEndian.Uint64(header[1+saltSize:])
+
+	// Check for a replay before the relatively expensive open, and record
+	// the counter only after, so forgeries can't move the window.
+	c.mu.Lock()
+	st := c.sessions[s]
+	if st != nil && st.entry != entry {
+		delete(c.sessions, s) // The key was replaced
+		st = nil
+	}
+	if st != nil && !st.fresh(n) {
+		c.stats.Replayed++
+		c.mu.Unlock()
+		return nil, false
+	}
+	c.mu.Unlock()
+
+	var aead cipher.AEAD
+	if st != nil {
+		aead = st.aead
+	} else {
+		var err error
+		if aead, err = entry.derive(s.salt[:]); err != nil {
+			c.count(&c.stats.Forged)
+			return nil, false
+		}
+	}
+
+	plain, err := aead.Open(b[headerSize:headerSize], nonce(n), b[headerSize:], header)
+	if err != nil {
+		c.count(&c.stats.Forged)
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	st = c.sessions[s]
+	if st == nil || st.entry != entry {
+		st = c.session(s, entry, aead)
+	} else if !st.fresh(n) {
+		c.stats.Replayed++ // Raced with a copy of itself
+		return nil, false
+	}
+	st.record(n)
+	st.seen = time.Now()
+	c.stats.Opened++
+
+	return plain, true
+}
+
+// session starts a session, evicting the stalest one if there are too
+// many. c.mu must be held.
+func (c *PacketConn) session(s session, entry *keyEntry, aead cipher.AEAD) *state {
+	if _, ok := c.sessions[s]; !ok && len(c.sessions) >= c.cfg.MaxSessions {
+		var oldest session
+		var seen time.Time
+		for k, st := range c.sessions {
+			if seen.IsZero() || st.seen.Before(seen) {
+				oldest, seen = k, st.seen
+			}
+		}
+		delete(c.sessions, oldest)
+	}
+
+	st := &state{entry: entry, aead: aead}
+	c.sessions[s] = st
+
+	return st
+}
+
+func (c *PacketConn) count(n *int) {
+	c.mu.Lock()
+	*n++
+	c.mu.Unlock()
+}
+
+func (c *PacketConn) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.stats
+}
@@ -0,0 +1,70 @@
+package echo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"networks/unreliable_udp_communication/secure"
+)
+
+// TestSecureEcho runs the echo server behind secure.PacketConn. Its loop
+// echoes plaintext; only keyed clients can talk to it.
+func TestSecureEcho(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	k := secure.Key{ID: 1, Cipher: secure.AESGCM, Secret: bytes.Repeat([]byte{7}, 16)}
+	ring, err := secure.NewKeyring(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverAddr, err := echoServerUDP(ctx, "127.0.0.1:", func(pc net.PacketConn) net.PacketConn {
+		return secure.New(pc, ring, secure.Config{})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := secure.New(pc, ring, secure.Config{})
+	defer func() { _ = client.Close() }()
+
+	msg := []byte("ping")
+	if _, err = client.WriteTo(msg, serverAddr); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1024)
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], msg) {
+		t.Errorf("expected %q; actual %q", msg, buf[:n])
+	}
+
+	// A client without the key gets no echo.
+	plain, err := net.Dial("udp", serverAddr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = plain.Close() }()
+
+	if _, err = plain.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	_ = plain.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, err = plain.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected %v; actual %v", os.ErrDeadlineExceeded, err)
+	}
+}
@@ -0,0 +1,371 @@
+// Package traffic measures a UDP path the way iperf does: it sends
+// sequence-numbered, timestamped probes at a steady rate to an echo
+// server and works out loss, duplication, reordering and round-trip
+// times from the echoes that come back.
+//
+// Any echo server, like echoServerUDP, can be the far end. Reflect is an
+// echo loop that also stamps how many probes it has received, which lets
+// Run tell loss on the way there from loss on the way back.
+package traffic
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+	"slices"
+	"time"
+)
+
+// A probe is a header padded to the configured size:
+//
+//	magic (4) | run ID (4) | sequence (8) | sent (8) | reflected (8)
+//
+// sent is nanoseconds since the run started, and reflected the count
+// Reflect stamps, or zero from a plain echo server.
+const (
+	HeaderSize = 32
+
+	magic = 0x55445046 // "UDPF"
+)
+
+const (
+	defaultRate     = 100
+	defaultSize     = 512
+	defaultDuration = 5 * time.Second
+	defaultWait     = time.Second
+)
+
+// MaxRate is the most probes per second Run can pace: one a nanosecond.
+const MaxRate = int(time.Second)
+
+var (
+	ErrSize = errors.New("traffic: probe size smaller than the header")
+	ErrRate = errors.New("traffic: rate above MaxRate")
+)
+
+// Config describes the traffic to send. The zero value sends 100 512-byte
+// probes a second for 5 seconds.
+type Config struct {
+	Rate     int           // Probes per second, at most MaxRate
+	Size     int           // Bytes per probe, at least HeaderSize
+	Duration time.Duration // How long to send for
+	Wait     time.Duration // How long to wait for echoes after the last probe; defaults to 1s
+}
+
+// Report is the outcome of a run. Times are in milliseconds.
+type Report struct {
+	Target   string  `json:"target"`
+	Size     int     `json:"size"`
+	Rate     int     `json:"rate_pps"`
+	Seconds  float64 `json:"duration_s"`
+	Sent     int     `json:"sent"`
+	Received int     `json:"received"` // Distinct probes echoed
+	Lost     int     `json:"lost"`
+	Loss     float64 `json:"loss"` // Lost over sent
+
+	// ForwardLost and ReturnLost split the losses by direction, when the
+	// far end is Reflect. Duplicates on the way there make ForwardLost an
+	// underestimate.
+	ForwardLost *int `json:"forward_lost,omitempty"`
+	ReturnLost  *int `json:"return_lost,omitempty"`
+
+	Duplicates int `json:"duplicates"`
+	Reordered  int `json:"reordered"` // Echoes arriving after a later probe's
+	Ignored    int `json:"ignored"`   // Datagrams that weren't our probes
+
+	RTT RTT `json:"rtt_ms"`
+}
+
+// RTT summarizes round-trip times. Jitter is the mean difference between
+// consecutive probes' round-trip times, as RFC 3550 does for one-way
+// transit.
+type RTT struct {
+	Min    float64 `json:"min"`
+	Mean   float64 `json:"mean"`
+	P50    float64 `json:"p50"`
+	P90    float64 `json:"p90"`
+	P99    float64 `json:"p99"`
+	Max    float64 `json:"max"`
+	Jitter float64 `json:"jitter"`
+}
+
+// Run sends probes from pc to addr as cfg describes, then reports on the
+// echoes. pc should be used for nothing else during the run. Canceling
+// ctx ends the run early, with a report of what was sent so far.
+func Run(ctx context.Context, pc net.PacketConn, addr net.Addr, cfg Config) (*Report, error) {
+	if cfg.Rate <= 0 {
+		cfg.Rate = defaultRate
+	}
+	if cfg.Rate > MaxRate {
+		return nil, ErrRate
+	}
+	if cfg.Size == 0 {
+		cfg.Size = defaultSize
+	}
+	if cfg.Size < HeaderSize {
+		return nil, ErrSize
+	}
+	if cfg.Duration <= 0 {
+		cfg.Duration = defaultDuration
+	}
+	if cfg.Wait <= 0 {
+		cfg.Wait = defaultWait
+	}
+
+	var id [4]byte
+	_, _ = rand.Read(id[:])
+
+	r := &run{
+		id:     binary.BigEndian.Uint32(id[:]),
+		start:  time.Now(),
+		counts: make(map[uint64]int),
+	}
+
+	recvErr := make(chan error, 1)
+	go func() { recvErr <- r.receive(pc) }()
+
+	sent, err := r.send(ctx, pc, addr, cfg)
+	r.sent = sent
+	if err != nil {
+		_ = pc.SetReadDeadline(time.Now())
+		<-recvErr
+		return nil, err
+	}
+
+	// Give the last echoes time to arrive, or stop now if canceled.
+	deadline := time.Now().Add(cfg.Wait)
+	if ctx.Err() != nil {
+		deadline = time.Now()
+	}
+	_ = pc.SetReadDeadline(deadline)
+	if err = <-recvErr; err != nil {
+		return nil, err
+	}
+
+	report := r.report()
+	report.Target = addr.String()
+	report.Size = cfg.Size
+	report.Rate = cfg.Rate
+
+	return report, nil
+}
+
+// echo is one probe's echo.
+type echo struct {
+	seq       uint64
+	rtt       time.Duration
+	reflected uint64
+}
+
+type run struct {
+	id    uint32
+	start time.Time
+
+	// Written by send before receive finishes, read after.
+	sent    int
+	elapsed time.Duration
+
+	// Owned by receive.
+	echoes  []echo // In arrival order, first copies only
+	counts  map[uint64]int
+	ignored int
+}
+
+// send paces probes evenly, catching up in bursts when the scheduler
+// wakes it late, until cfg.Duration passes or ctx is canceled.
+func (r *run) send(ctx context.Context, pc net.PacketConn, addr net.Addr, cfg Config) (int, error) {
+	total := int(cfg.Duration.Seconds() * float64(cfg.Rate))
+	interval := time.Second / time.Duration(cfg.Rate)
+
+	probe := make([]byte, cfg.Size)
+	binary.BigEndian.PutUint32(probe, magic)
+	binary.BigEndian.PutUint32(probe[4:], r.id)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	sent := 0
+	for sent < total {
+		select {
+		case <-ctx.Done():
+			r.elapsed = time.Since(r.start)
+			return sent, nil
+		case <-timer.C:
+		}
+
+		due := min(int(time.Since(r.start)/interval)+1, total)
+		for ; sent < due; sent++ {
+			binary.BigEndian.PutUint64(probe[8:], uint64(sent))
+			binary.BigEndian.PutUint64(probe[16:], uint64(time.Since(r.start)))
+			if _, err := pc.WriteTo(probe, addr); err != nil {
+				return sent, err
+			}
+		}
+
+		timer.Reset(time.Until(r.start.Add(time.Duration(sent) * interval)))
+	}
+	r.elapsed = time.Since(r.start)
+
+	return sent, nil
+}
+
+// receive records echoes until the read deadline passes.
+func (r *run) receive(pc net.PacketConn) error {
+	buf := make([]byte, 65535)
+
+	for {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			var nErr net.Error
+			if errors.As(err, &nErr) && nErr.Timeout() {
+				return nil
+			}
+			return err
+		}
+		now := time.Since(r.start)
+
+		b := buf[:n]
+		if n < HeaderSize || binary.BigEndian.Uint32(b) != magic || binary.BigEndian.Uint32(b[4:]) != r.id {
+			r.ignored++
+			continue
+		}
+
+		seq := binary.BigEndian.Uint64(b[8:])
+		r.counts[seq]++
+		if r.counts[seq] > 1 {
+			continue
+		}
+		r.echoes = append(r.echoes, echo{
+			seq:       seq,
+			rtt:       now - time.Duration(binary.BigEndian.Uint64(b[16:])),
+			reflected: binary.BigEndian.Uint64(b[24:]),
+		})
+	}
+}
+
+func (r *run) report() *Report {
+	rep := &Report{
+		Seconds:  r.elapsed.Seconds(),
+		Sent:     r.sent,
+		Received: len(r.echoes),
+		Ignored:  r.ignored,
+	}
+	rep.Lost = rep.Sent - rep.Received
+	if rep.Sent > 0 {
+		rep.Loss = float64(rep.Lost) / float64(rep.Sent)
+	}
+
+	for _, n := range r.counts {
+		rep.Duplicates += n - 1
+	}
+
+	var highest uint64
+	var last echo // The echo of the highest sequence number
+	rtts := make([]time.Duration, 0, len(r.echoes))
+	for i, e := range r.echoes {
+		if i > 0 && e.seq < highest {
+			rep.Reordered++
+		}
+		if i == 0 || e.seq > highest {
+			highest, last = e.seq, e
+		}
+		rtts = append(rtts, e.rtt)
+	}
+
+	// Reflect stamps its count, so when the highest probe was echoed it
+	// had received last.reflected of the probes up to it.
+	if last.reflected > 0 {
+		upTo := int(highest) + 1
+		forward := max(upTo-int(last.reflected), 0)
+		ret := max(rep.Lost-forward, 0)
+		rep.ForwardLost, rep.ReturnLost = &forward, &ret
+	}
+
+	rep.RTT = summarize(rtts)
+
+	return rep
+}
+
+// summarize takes rtts in arrival order.
+func summarize(rtts []time.Duration) RTT {
+	if len(rtts) == 0 {
+		return RTT{}
+	}
+
+	var jitter, sum time.Duration
+	for i, d := range rtts {
+		sum += d
+		if i > 0 {
+			jitter += (d - rtts[i-1]).Abs()
+		}
+	}
+
+	sorted := slices.Clone(rtts)
+	slices.Sort(sorted)
+
+	rtt := RTT{
+		Min:  ms(sorted[0]),
+		Mean: ms(sum / time.Duration(len(sorted))),
+		P50:  ms(percentile(sorted, 50)),
+		P90:  ms(percentile(sorted, 90)),
+		P99:  ms(percentile(sorted, 99)),
+		Max:  ms(sorted[len(sorted)-1]),
+	}
+	if len(rtts) > 1 {
+		rtt.Jitter = ms(jitter / time.Duration(len(rtts)-1))
+	}
+
+	return rtt
+}
+
+// percentile is the nearest-rank percentile of sorted.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+
+	return sorted[max(rank-1, 0)]
+}
+
+// ms converts d to milliseconds, keeping microseconds.
+func ms(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Microsecond)) / 1000
+}
+
+// maxRuns bounds how many runs Reflect counts for at once.
+const maxRuns = 1024
+
+// Reflect echoes every datagram read from pc back to its sender, like
+// echoServerUDP, stamping probes with the number received so far in
+// their run. It returns nil once pc is closed.
+func Reflect(pc net.PacketConn) error {
+	buf := make([]byte, 65535)
+	received := make(map[uint32]uint64) // By run ID
+
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		if n >= HeaderSize && binary.BigEndian.Uint32(buf) == magic {
+			id := binary.BigEndian.Uint32(buf[4:])
+			if _, ok := received[id]; !ok && len(received) >= maxRuns {
+				clear(received) // Runs in progress lose their split
+			}
+			received[id]++
+			binary.BigEndian.PutUint64(buf[24:], received[id])
+		}
+
+		if _, err = pc.WriteTo(buf[:n], addr); err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package traffic
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"networks/unreliable_udp_communication/netem"
+)
+
+// reflector starts Reflect on a loopback socket, wrapped by wrap if set,
+// and returns the socket.
+func reflector(t *testing.T, wrap func(net.PacketConn) net.PacketConn) net.PacketConn {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wrap != nil {
+		pc = wrap(pc)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- Reflect(pc) }()
+	t.Cleanup(func() {
+		_ = pc.Close()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+
+	return pc
+}
+
+func client(t *testing.T) net.PacketConn {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = pc.Close() })
+
+	return pc
+}
+
+func TestRun(t *testing.T) {
+	server := reflector(t, nil)
+
+	cfg := Config{Rate: 500, Size: 256, Duration: 400 * time.Millisecond, Wait: 200 * time.Millisecond}
+	r, err := Run(context.Background(), client(t), server.LocalAddr(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Sent != 200 {
+		t.Errorf("expected 200 sent; actual %d", r.Sent)
+	}
+	if r.Received != r.Sent || r.Lost != 0 || r.Duplicates != 0 || r.Reordered != 0 {
+		t.Errorf("expected a perfect link; actual %+v", r)
+	}
+	if r.ForwardLost == nil || *r.ForwardLost != 0 || *r.ReturnLost != 0 {
+		t.Errorf("expected no loss either way; actual %v, %v", r.ForwardLost, r.ReturnLost)
+	}
+	if r.RTT.Min <= 0 || r.RTT.Min > r.RTT.P50 || r.RTT.P50 > r.RTT.P99 || r.RTT.P99 > r.RTT.Max {
+		t.Errorf("inconsistent round-trip times %+v", r.RTT)
+	}
+}
+
+// TestImpaired checks the report against what netem did to each
+// direction.
+func TestImpaired(t *testing.T) {
+	var back *netem.PacketConn
+	server := reflector(t, func(pc net.PacketConn) net.PacketConn {
+		back = netem.NewPacketConn(pc, netem.Config{Seed: 2, Loss: 0.05, Duplicate: 0.05})
+		return back
+	})
+
+	const delay = 10 * time.Millisecond
+	there := netem.NewPacketConn(client(t), netem.Config{
+		Seed:         1,
+		Loss:         0.1,
+		Reorder:      0.1,
+		ReorderDelay: 5 * time.Millisecond,
+		Delay:        delay,
+	})
+
+	cfg := Config{Rate: 1000, Size: 128, Duration: time.Second, Wait: 500 * time.Millisecond}
+	r, err := Run(context.Background(), there, server.LocalAddr(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fwd, ret := there.Stats(), back.Stats()
+	if expected := fwd.Dropped + ret.Dropped; r.Lost != expected {
+		t.Errorf("expected %d lost; actual %d", expected, r.Lost)
+	}
+	if r.Duplicates != ret.Duplicated {
+		t.Errorf("expected %d duplicates; actual %d", ret.Duplicated, r.Duplicates)
+	}
+	if r.Reordered == 0 {
+		t.Error("expected reordering")
+	}
+	if r.Loss < 0.1 || r.Loss > 0.2 {
+		t.Errorf("expected about 15%% loss; actual %.3f", r.Loss)
+	}
+
+	// Probes reordered past the last one echoed look lost on the way there.
+	if r.ForwardLost == nil {
+		t.Fatal("no forward loss from Reflect")
+	}
+	if d := *r.ForwardLost - fwd.Dropped; d < 0 || d > 10 {
+		t.Errorf("expected about %d lost on the way there; actual %d", fwd.Dropped, *r.ForwardLost)
+	}
+	if d := ret.Dropped - *r.ReturnLost; d < 0 || d > 10 {
+		t.Errorf("expected about %d lost on the way back; actual %d", ret.Dropped, *r.ReturnLost)
+	}
+
+	if floor := float64(delay) / float64(time.Millisecond); r.RTT.Min < floor {
+		t.Errorf("expected round trips of at least %vms; actual %+v", floor, r.RTT)
+	}
+}
+
+func TestPlainEcho(t *testing.T) {
+	server := client(t)
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := server.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = server.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	cfg := Config{Rate: 200, Size: HeaderSize, Duration: 200 * time.Millisecond, Wait: 200 * time.Millisecond}
+	r, err := Run(context.Background(), client(t), server.LocalAddr(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Received != r.Sent || r.Sent != 40 {
+		t.Errorf("expected 40 sent and received; actual %d and %d", r.Sent, r.Received)
+	}
+	if r.ForwardLost != nil || r.ReturnLost != nil {
+		t.Error("split loss without Reflect")
+	}
+}
+
+func TestCancel(t *testing.T) {
+	server := reflector(t, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	r, err := Run(ctx, client(t), server.LocalAddr(), Config{Rate: 100, Duration: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("canceled run took %v", elapsed)
+	}
+	if r.Sent == 0 || r.Sent > 20 {
+		t.Errorf("expected about 10 sent; actual %d", r.Sent)
+	}
+}
+
+func TestSize(t *testing.T) {
+	_, err := Run(context.Background(), client(t), client(t).LocalAddr(), Config{Size: HeaderSize - 1})
+	if !errors.Is(err, ErrSize) {
+		t.Errorf("expected %v; actual %v", ErrSize, err)
+	}
+}
+
+func TestRate(t *testing.T) {
+	_, err := Run(context.Background(), client(t), client(t).LocalAddr(), Config{Rate: MaxRate + 1})
+	if !errors.Is(err, ErrRate) {
+		t.Errorf("expected %v; actual %v", ErrRate, err)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	var rtts []time.Duration
+	for i := 100; i >= 1; i-- {
+		rtts = append(rtts, time.Duration(i)*time.Millisecond)
+	}
+
+	s := summarize(rtts)
+	expected := RTT{Min: 1, Mean: 50.5, P50: 50, P90: 90, P99: 99, Max: 100, Jitter: 1}
+	if s != expected {
+		t.Errorf("expected %+v; actual %+v", expected, s)
+	}
+
+	if s = summarize(nil); s != (RTT{}) {
+		t.Errorf("expected zero summary; actual %+v", s)
+	}
+}
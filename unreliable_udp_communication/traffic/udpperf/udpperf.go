@@ -0,0 +1,131 @@
+// udpperf qualifies a UDP path: it sends probes at a steady rate to an
+// echo server and prints loss, duplication, reordering and round-trip
+// times as JSON.
+//
+// Run the reflector at the far end:
+//
+//	udpperf -s -a 0.0.0.0:9001
+//
+// and the client at the near end:
+//
+//	udpperf -a 192.0.2.1:9001 -rate 1000 -size 1200 -t 10s
+//
+// Any UDP echo server will do as the far end, though only udpperf's
+// reflector lets the report split losses by direction. The netem flags
+// impair the client's probes, to check the simulator against the report:
+//
+//	udpperf -a 127.0.0.1:9001 -loss 0.05 -reorder 0.01 -delay 20ms
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"time"
+
+	"networks/unreliable_udp_communication/netem"
+	"networks/unreliable_udp_communication/traffic"
+)
+
+var (
+	server   = flag.Bool("s", false, "run the reflector instead of the client")
+	address  = flag.String("a", "127.0.0.1:9001", "address to listen on (-s) or send to")
+	rate     = flag.Int("rate", 100, "probes per second")
+	size     = flag.Int("size", 512, "probe size in bytes, including the 32-byte header")
+	duration = flag.Duration("t", 5*time.Second, "how long to send for")
+	wait     = flag.Duration("wait", time.Second, "how long to wait for echoes after the last probe")
+
+	seed    = flag.Uint64("seed", 1, "netem: random seed")
+	loss    = flag.Float64("loss", 0, "netem: probability of dropping a probe")
+	dup     = flag.Float64("dup", 0, "netem: probability of duplicating a probe")
+	reorder = flag.Float64("reorder", 0, "netem: probability of holding a probe back")
+	delay   = flag.Duration("delay", 0, "netem: added one-way delay")
+	jitter  = flag.Duration("jitter", 0, "netem: random variation in the delay")
+)
+
+func main() {
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var err error
+	if *server {
+		err = reflect(ctx)
+	} else {
+		err = measure(ctx)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func reflect(ctx context.Context) error {
+	pc, err := net.ListenPacket("udp", *address)
+	if err != nil {
+		return err
+	}
+	log.Printf("reflecting on %s", pc.LocalAddr())
+
+	go func() {
+		<-ctx.Done()
+		_ = pc.Close()
+	}()
+
+	return traffic.Reflect(pc)
+}
+
+func measure(ctx context.Context) error {
+	addr, err := net.ResolveUDPAddr("udp", *address)
+	if err != nil {
+		return err
+	}
+
+	var pc net.PacketConn
+	pc, err = net.ListenPacket("udp", ":0")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = pc.Close() }()
+
+	impaired := *loss > 0 || *dup > 0 || *reorder > 0 || *delay > 0 || *jitter > 0
+	if impaired {
+		pc = netem.NewPacketConn(pc, netem.Config{
+			Seed:      *seed,
+			Loss:      *loss,
+			Duplicate: *dup,
+			Reorder:   *reorder,
+			Delay:     *delay,
+			Jitter:    *jitter,
+		})
+	}
+
+	report, err := traffic.Run(ctx, pc, addr, traffic.Config{
+		Rate:     *rate,
+		Size:     *size,
+		Duration: *duration,
+		Wait:     *wait,
+	})
+	if err != nil {
+		return err
+	}
+
+	out := struct {
+		*traffic.Report
+		Netem *netem.Stats `json:"netem,omitempty"`
+	}{Report: report}
+	if impaired {
+		s := pc.(*netem.PacketConn).Stats()
+		out.Netem = &s
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(out)
+}
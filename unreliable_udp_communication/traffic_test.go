@@ -0,0 +1,44 @@
+package echo
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"networks/unreliable_udp_communication/traffic"
+)
+
+// TestTrafficEcho measures loopback through the echo server, which knows
+// nothing about probes, so losses can't be split by direction.
+func TestTrafficEcho(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serverAddr, err := echoServerUDP(ctx, "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = pc.Close() }()
+
+	r, err := traffic.Run(ctx, pc, serverAddr, traffic.Config{
+		Rate:     500,
+		Size:     1024,
+		Duration: 200 * time.Millisecond,
+		Wait:     200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Sent != 100 || r.Received != r.Sent {
+		t.Errorf("expected 100 sent and received; actual %d and %d", r.Sent, r.Received)
+	}
+	if r.ForwardLost != nil {
+		t.Errorf("expected no split by direction; actual %d", *r.ForwardLost)
+	}
+}
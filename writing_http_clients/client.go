@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"os"
+
+	"networks/sending_tcp_data/socks5"
+)
+
+// client sends the examples' requests. Set SOCKS5_PROXY to a host:port
+// to send them through a SOCKS5 server instead of directly, and
+// SOCKS5_USER and SOCKS5_PASSWORD if it wants a username and password.
+var client = newClient(os.Getenv("SOCKS5_PROXY"))
+
+func newClient(proxy string) *http.Client {
+	if proxy == "" {
+		return http.DefaultClient
+	}
+
+	dialer := &socks5.Dialer{
+		ProxyAddress: proxy,
+		Username:     os.Getenv("SOCKS5_USER"),
+		Password:     os.Getenv("SOCKS5_PASSWORD"),
+	}
+
+	// Keep the default transport's timeouts and pooling, but dial
+	// through the proxy, and don't also honor HTTP_PROXY
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{Transport: transport}
+}
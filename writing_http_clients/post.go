@@ -34,7 +34,7 @@ func postUser() {
 	}
 
 	// Make a POST request with JSON content
-	resp, err := http.Post("https://httpbin.org/post", "application/json", buf)
+	resp, err := client.Post("https://httpbin.org/post", "application/json", buf)
 	if err != nil {
 		fmt.Println("Error making POST request:", err)
 		return
@@ -114,7 +114,7 @@ func multipartPost() {
 	req.Header.Set("Content-Type", w.FormDataContentType())
 
 	// Perform the request
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		fmt.Println("Error making POST request:", err)
 		return
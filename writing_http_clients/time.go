@@ -2,14 +2,13 @@ package main
 
 import (
 	"fmt"
-	"net/http"
 	"time"
 )
 
 // Function to fetch the Date header and calculate the time skew
 func CheckTimeSkew() error {
 	// Send a HEAD request to time.gov
-	resp, err := http.Head("https://www.time.gov/")
+	resp, err := client.Head("https://www.time.gov/")
 	if err != nil {
 		return fmt.Errorf("failed to fetch time.gov: %w", err)
 	}